@@ -0,0 +1,105 @@
+package bow
+
+import (
+	"fmt"
+)
+
+// This file provides operations over sets of bag-of-words vectors, which are
+// useful for building family profiles or cluster centroids. Since sparse
+// representations of BOWs (like those in a BOW database) are always expanded
+// to a Bow with a frequency for every fragment, fragments missing from a
+// sparse representation count as a frequency of zero.
+//
+// All functions in this file panic if given an empty set or a set of BOWs
+// with differing lengths.
+
+// checkSet panics if the set of BOWs is empty or if the BOWs have differing
+// lengths. Otherwise, it returns the length of every BOW.
+func checkSet(bows []Bow) int {
+	if len(bows) == 0 {
+		panic("Cannot aggregate an empty set of Bows")
+	}
+	size := bows[0].Len()
+	for _, b := range bows[1:] {
+		if b.Len() != size {
+			panic("Cannot aggregate Bows with differing lengths")
+		}
+	}
+	return size
+}
+
+// WeightedSum returns the sum of every BOW given, where the frequencies of
+// the i'th BOW are multiplied by the i'th weight.
+//
+// WeightedSum will panic if the number of weights differs from the number of
+// BOWs.
+func WeightedSum(bows []Bow, weights []float32) Bow {
+	size := checkSet(bows)
+	if len(weights) != len(bows) {
+		panic(fmt.Sprintf("Got %d weights for %d Bows.",
+			len(weights), len(bows)))
+	}
+
+	sum := NewBow(size)
+	for i, b := range bows {
+		w := weights[i]
+		for j, f := range b.Freqs {
+			sum.Freqs[j] += w * f
+		}
+	}
+	return sum
+}
+
+// Mean returns a BOW where the frequency of each fragment is its mean
+// frequency over the BOWs given. (i.e., the centroid.)
+func Mean(bows []Bow) Bow {
+	size := checkSet(bows)
+	mean := NewBow(size)
+	for _, b := range bows {
+		for j, f := range b.Freqs {
+			mean.Freqs[j] += f
+		}
+	}
+	return mean.Scale(1 / float32(len(bows)))
+}
+
+// Variance returns a BOW where the frequency of each fragment is the
+// (population) variance of its frequency over the BOWs given.
+func Variance(bows []Bow) Bow {
+	mean := Mean(bows)
+	variance := NewBow(mean.Len())
+	for _, b := range bows {
+		for j, f := range b.Freqs {
+			d := f - mean.Freqs[j]
+			variance.Freqs[j] += d * d
+		}
+	}
+	return variance.Scale(1 / float32(len(bows)))
+}
+
+// Medoid returns the index of the BOW with the smallest total distance to
+// every other BOW given, using the distance function given. (e.g., Bow.Cosine
+// or Bow.Euclid.) Unlike the Mean, the medoid is always one of the BOWs in
+// the set. Ties are broken by the smallest index.
+//
+// Computing the medoid requires a distance computation for every pair of
+// BOWs.
+func Medoid(bows []Bow, dist func(b1, b2 Bow) float64) int {
+	checkSet(bows)
+	totals := make([]float64, len(bows))
+	for i := range bows {
+		for j := i + 1; j < len(bows); j++ {
+			d := dist(bows[i], bows[j])
+			totals[i] += d
+			totals[j] += d
+		}
+	}
+
+	best := 0
+	for i, total := range totals {
+		if total < totals[best] {
+			best = i
+		}
+	}
+	return best
+}
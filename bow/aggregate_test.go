@@ -0,0 +1,44 @@
+package bow
+
+import (
+	"testing"
+)
+
+var aggregateSet = []Bow{
+	newBowMap(4, map[int]float32{0: 2, 1: 1}),
+	newBowMap(4, map[int]float32{0: 4, 2: 2}),
+	newBowMap(4, map[int]float32{0: 3, 1: 1, 2: 1}),
+}
+
+func TestMean(t *testing.T) {
+	expected := newBowMap(4, map[int]float32{0: 3, 1: 2.0 / 3, 2: 1})
+	if mean := Mean(aggregateSet); !mean.Equal(expected) {
+		t.Fatalf("Expected mean %s but got %s.", expected, mean)
+	}
+}
+
+func TestVariance(t *testing.T) {
+	expected := newBowMap(4, map[int]float32{0: 2.0 / 3, 1: 2.0 / 9,
+		2: 2.0 / 3})
+	variance := Variance(aggregateSet)
+	for i := range expected.Freqs {
+		d := variance.Freqs[i] - expected.Freqs[i]
+		if d > 1e-6 || d < -1e-6 {
+			t.Fatalf("Expected variance %s but got %s.", expected, variance)
+		}
+	}
+}
+
+func TestWeightedSum(t *testing.T) {
+	expected := newBowMap(4, map[int]float32{0: 4, 1: 1, 2: 1})
+	sum := WeightedSum(aggregateSet, []float32{1, 0.5, 0})
+	if !sum.Equal(expected) {
+		t.Fatalf("Expected weighted sum %s but got %s.", expected, sum)
+	}
+}
+
+func TestMedoid(t *testing.T) {
+	if m := Medoid(aggregateSet, Bow.Euclid); m != 2 {
+		t.Fatalf("Expected medoid 2 but got %d.", m)
+	}
+}
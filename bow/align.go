@@ -0,0 +1,233 @@
+package bow
+
+import (
+	"fmt"
+
+	"github.com/TuftsBCB/structure"
+	"github.com/yunwilliamyu/esfragbag"
+)
+
+// SubstMatrix is a fragment-to-fragment substitution matrix used to score
+// alignments of Fragments values. Scores should be positive for similar
+// fragments and negative for dissimilar fragments.
+type SubstMatrix struct {
+	// Size is the number of fragments in the library the matrix was
+	// computed for.
+	Size int
+
+	// Scores is a Size x Size matrix in row-major order.
+	Scores []float32
+}
+
+// NewIdentitySubstMatrix returns a substitution matrix for a library with
+// `size` fragments that scores `match` for identical fragments and
+// `mismatch` otherwise. This is useful for libraries (like sequence
+// libraries) without a natural notion of fragment similarity.
+func NewIdentitySubstMatrix(size int, match, mismatch float32) SubstMatrix {
+	m := SubstMatrix{size, make([]float32, size*size)}
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
+			if i == j {
+				m.Scores[i*size+j] = match
+			} else {
+				m.Scores[i*size+j] = mismatch
+			}
+		}
+	}
+	return m
+}
+
+// NewStructureSubstMatrix computes a substitution matrix from the geometry
+// of the fragments in a structure library. The score of two fragments is
+// derived from the RMSD between them, normalized by the mean RMSD of all
+// pairs of fragments in the library:
+//
+//	score(i, j) = 1 - rmsd(i, j) / mean
+//
+// Identical fragments score 1, a pair of fragments at the average distance
+// scores 0 and more dissimilar pairs score negatively.
+func NewStructureSubstMatrix(lib fragbag.StructureLibrary) SubstMatrix {
+	size := lib.Size()
+	m := SubstMatrix{size, make([]float32, size*size)}
+	if size < 2 {
+		for i := range m.Scores {
+			m.Scores[i] = 1
+		}
+		return m
+	}
+
+	mem := structure.NewMemory(lib.FragmentSize())
+	rmsds := make([]float64, size*size)
+	total := 0.0
+	for i := 0; i < size; i++ {
+		for j := i + 1; j < size; j++ {
+			r := structure.RMSDMem(mem, lib.Atoms(i), lib.Atoms(j))
+			rmsds[i*size+j], rmsds[j*size+i] = r, r
+			total += r
+		}
+	}
+	mean := total / float64(size*(size-1)/2)
+	for i := range rmsds {
+		m.Scores[i] = float32(1 - rmsds[i]/mean)
+	}
+	return m
+}
+
+// Score returns the substitution score of two fragments. Unknown fragments
+// (`-1`) always score 0.
+func (m SubstMatrix) Score(frag1, frag2 int) float32 {
+	if frag1 < 0 || frag2 < 0 {
+		return 0
+	}
+	return m.Scores[frag1*m.Size+frag2]
+}
+
+// AlignOptions corresponds to the parameters of a fragment alignment.
+type AlignOptions struct {
+	// Local selects a local (Smith-Waterman) alignment when true and a
+	// global (Needleman-Wunsch) alignment when false.
+	Local bool
+
+	// Gap is the penalty subtracted from the score of an alignment for
+	// every fragment aligned to a gap. It should not be negative.
+	Gap float32
+}
+
+// AlignDefault provides default alignment settings. Namely, a local alignment
+// with a gap penalty that is half of the score of an identical fragment
+// in a structure substitution matrix.
+var AlignDefault = AlignOptions{
+	Local: true,
+	Gap:   0.5,
+}
+
+// Alignment corresponds to the result of aligning two Fragments values.
+// The aligned region of each value is given as the half-open interval
+// [Start, End) of fragment positions.
+type Alignment struct {
+	Score                  float64
+	QueryStart, QueryEnd   int
+	TargetStart, TargetEnd int
+}
+
+// QueryResidues returns the half-open interval of residues covered by the
+// aligned region of the query, where fragSize is the size of each fragment
+// in the library used to compute the Fragments values.
+func (a Alignment) QueryResidues(fragSize int) (start, end int) {
+	return fragsToResidues(a.QueryStart, a.QueryEnd, fragSize)
+}
+
+// TargetResidues returns the half-open interval of residues covered by the
+// aligned region of the target, where fragSize is the size of each fragment
+// in the library used to compute the Fragments values.
+func (a Alignment) TargetResidues(fragSize int) (start, end int) {
+	return fragsToResidues(a.TargetStart, a.TargetEnd, fragSize)
+}
+
+func fragsToResidues(start, end, fragSize int) (int, int) {
+	if end <= start {
+		return start, start
+	}
+	return start, end - 1 + fragSize
+}
+
+// Traceback directions used by Align.
+const (
+	alignStop = iota
+	alignDiag
+	alignUp
+	alignLeft
+)
+
+// Align computes the optimal alignment of the query and target fragment
+// lists with respect to the substitution matrix and options given.
+//
+// Align will panic if either list contains a fragment number outside the
+// range of the substitution matrix.
+func Align(
+	opts AlignOptions,
+	m SubstMatrix,
+	query, target Fragments,
+) Alignment {
+	for _, fs := range []Fragments{query, target} {
+		for _, f := range fs {
+			if f >= m.Size {
+				panic(fmt.Sprintf("Fragment number %d is outside the range "+
+					"of a substitution matrix with size %d.", f, m.Size))
+			}
+		}
+	}
+
+	n, k := len(query), len(target)
+	cols := k + 1
+	scores := make([]float64, (n+1)*cols)
+	trace := make([]byte, (n+1)*cols)
+	gap := float64(opts.Gap)
+	if !opts.Local {
+		for i := 1; i <= n; i++ {
+			scores[i*cols] = -gap * float64(i)
+			trace[i*cols] = alignUp
+		}
+		for j := 1; j <= k; j++ {
+			scores[j] = -gap * float64(j)
+			trace[j] = alignLeft
+		}
+	}
+
+	// A global alignment always ends in the last cell. A local alignment
+	// ends in the best scoring cell.
+	besti, bestj := n, k
+	if opts.Local {
+		besti, bestj = 0, 0
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= k; j++ {
+			diag := scores[(i-1)*cols+j-1] +
+				float64(m.Score(query[i-1], target[j-1]))
+			up := scores[(i-1)*cols+j] - gap
+			left := scores[i*cols+j-1] - gap
+
+			best, dir := diag, byte(alignDiag)
+			if up > best {
+				best, dir = up, alignUp
+			}
+			if left > best {
+				best, dir = left, alignLeft
+			}
+			if opts.Local && best <= 0 {
+				best, dir = 0, alignStop
+			}
+			scores[i*cols+j], trace[i*cols+j] = best, dir
+
+			if opts.Local && best > scores[besti*cols+bestj] {
+				besti, bestj = i, j
+			}
+		}
+	}
+	if opts.Local && scores[besti*cols+bestj] <= 0 {
+		return Alignment{}
+	}
+
+	i, j := besti, bestj
+	for i > 0 || j > 0 {
+		dir := trace[i*cols+j]
+		if dir == alignStop {
+			break
+		}
+		switch dir {
+		case alignDiag:
+			i, j = i-1, j-1
+		case alignUp:
+			i--
+		case alignLeft:
+			j--
+		}
+	}
+	return Alignment{
+		Score:       scores[besti*cols+bestj],
+		QueryStart:  i,
+		QueryEnd:    besti,
+		TargetStart: j,
+		TargetEnd:   bestj,
+	}
+}
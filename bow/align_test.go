@@ -0,0 +1,62 @@
+package bow
+
+import (
+	"testing"
+)
+
+func TestAlign(t *testing.T) {
+	subst := NewIdentitySubstMatrix(10, 1, -1)
+	tests := []struct {
+		opts          AlignOptions
+		query, target Fragments
+		expected      Alignment
+	}{
+		{
+			AlignOptions{Local: true, Gap: 1},
+			Fragments{9, 9, 1, 2, 3, 4, 9},
+			Fragments{8, 1, 2, 3, 4, 8, 8},
+			Alignment{4, 2, 6, 1, 5},
+		},
+		{
+			AlignOptions{Local: true, Gap: 0.5},
+			Fragments{1, 2, 3, 4, 5},
+			Fragments{1, 2, 4, 5},
+			Alignment{3.5, 0, 5, 0, 4},
+		},
+		{
+			AlignOptions{Local: true, Gap: 1},
+			Fragments{1, 2, 3},
+			Fragments{4, 5, 6},
+			Alignment{0, 0, 0, 0, 0},
+		},
+		{
+			AlignOptions{Local: false, Gap: 1},
+			Fragments{1, 2, 3},
+			Fragments{1, 2, 3, 4},
+			Alignment{2, 0, 3, 0, 4},
+		},
+		{
+			AlignOptions{Local: false, Gap: 1},
+			Fragments{1, -1, 3},
+			Fragments{1, 2, 3},
+			Alignment{2, 0, 3, 0, 3},
+		},
+	}
+	for _, test := range tests {
+		aln := Align(test.opts, subst, test.query, test.target)
+		if aln != test.expected {
+			t.Fatalf("Aligning %v with %v resulted in %+v, but expected %+v.",
+				test.query, test.target, aln, test.expected)
+		}
+	}
+}
+
+func TestAlignResidues(t *testing.T) {
+	aln := Alignment{QueryStart: 2, QueryEnd: 6, TargetStart: 1, TargetEnd: 1}
+	if s, e := aln.QueryResidues(11); s != 2 || e != 16 {
+		t.Fatalf("Expected query residues [2, 16) but got [%d, %d).", s, e)
+	}
+	if s, e := aln.TargetResidues(11); s != 1 || e != 1 {
+		t.Fatalf("Expected target residues [1, 1) but got [%d, %d).", s, e)
+	}
+}
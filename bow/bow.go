@@ -5,7 +5,7 @@ import (
 	"math"
 	"strings"
 
-	"github.com/TuftsBCB/fragbag"
+	"github.com/yunwilliamyu/esfragbag"
 )
 
 // Bow represents a bag-of-words vector of size N for a particular fragment
@@ -84,6 +84,33 @@ func (b Bow) Add(b2 Bow) Bow {
 	return sum
 }
 
+// Sub performs a subtraction operation on each fragment frequency and returns
+// a new Bow. Sub will panic if the operands have different lengths.
+//
+// Note that the result may have negative frequencies, which cannot be
+// stored in a BOW database.
+func (b Bow) Sub(b2 Bow) Bow {
+	if b.Len() != b2.Len() {
+		panic("Cannot subtract two Bows with differing lengths")
+	}
+
+	diff := NewBow(b.Len())
+	for i := 0; i < diff.Len(); i++ {
+		diff.Freqs[i] = b.Freqs[i] - b2.Freqs[i]
+	}
+	return diff
+}
+
+// Scale multiplies each fragment frequency by the factor given and returns
+// a new Bow.
+func (b Bow) Scale(factor float32) Bow {
+	scaled := NewBow(b.Len())
+	for i := 0; i < scaled.Len(); i++ {
+		scaled.Freqs[i] = b.Freqs[i] * factor
+	}
+	return scaled
+}
+
 // Euclid returns the euclidean distance between b and b2.
 func (b Bow) Euclid(b2 Bow) float64 {
 	f1, f2 := b.Freqs, b2.Freqs
@@ -0,0 +1,245 @@
+package bow
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/structure"
+	"github.com/yunwilliamyu/esfragbag"
+)
+
+// MaxCaDistance is the maximum distance (in Angstroms) allowed between two
+// consecutive alpha-carbons. Consecutive alpha-carbons that are farther apart
+// than this are assumed to be separated by missing residues.
+//
+// The distance between consecutive alpha-carbons in a peptide is about 3.8
+// Angstroms.
+var MaxCaDistance = 4.2
+
+// ChainBreaks returns the positions of chain breaks in a list of
+// alpha-carbon atoms. A position `i` in the list returned indicates that
+// residues are missing between atoms `i-1` and `i`. The positions are sorted
+// in ascending order.
+//
+// A break is detected when the distance between consecutive atoms exceeds
+// maxDist, or when `nums` is not nil and the residue numbers of consecutive
+// atoms are not consecutive. When `nums` is not nil, it must have the same
+// length as `atoms`. (Residues that share a number because of insertion codes
+// are considered consecutive.)
+func ChainBreaks(atoms []structure.Coords, nums []int, maxDist float64) []int {
+	breaks := make([]int, 0)
+	for i := 1; i < len(atoms); i++ {
+		if nums != nil && nums[i]-nums[i-1] > 1 {
+			breaks = append(breaks, i)
+		} else if caDistance(atoms[i-1], atoms[i]) > maxDist {
+			breaks = append(breaks, i)
+		}
+	}
+	return breaks
+}
+
+func caDistance(a, b structure.Coords) float64 {
+	dx, dy, dz := a.X-b.X, a.Y-b.Y, a.Z-b.Z
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// StructureFragmentsBreaks is like StructureFragments, except every window
+// that spans one of the chain breaks given is skipped. Skipped windows are
+// recorded as `-1`. The number of skipped windows is also returned.
+//
+// The breaks given should be in the format returned by ChainBreaks.
+func StructureFragmentsBreaks(
+	lib fragbag.StructureLibrary,
+	atoms []structure.Coords,
+	breaks []int,
+) (Fragments, int) {
+	libSize := lib.FragmentSize()
+	uplimit := len(atoms) - libSize
+	if uplimit < 0 {
+		return Fragments{}, 0
+	}
+
+	// brokenBefore[i] is the number of breaks at positions less than or
+	// equal to i. A window starting at i spans a break when there is a break
+	// at any position in (i, i+libSize).
+	brokenBefore := make([]int, len(atoms))
+	for _, b := range breaks {
+		if b > 0 && b < len(atoms) {
+			brokenBefore[b]++
+		}
+	}
+	for i := 1; i < len(brokenBefore); i++ {
+		brokenBefore[i] += brokenBefore[i-1]
+	}
+
+	skipped := 0
+	frags := make(Fragments, uplimit+1)
+	for i := 0; i <= uplimit; i++ {
+		if brokenBefore[i+libSize-1]-brokenBefore[i] > 0 {
+			frags[i] = -1
+			skipped++
+			continue
+		}
+		frags[i] = lib.BestStructureFragment(atoms[i : i+libSize])
+	}
+	return frags, skipped
+}
+
+// StructureBowBreaks is like StructureBow, except it uses the chain breaks
+// given instead of detecting them. The number of windows skipped because they
+// span a chain break is also returned.
+//
+// The breaks given should be in the format returned by ChainBreaks.
+func StructureBowBreaks(
+	lib fragbag.StructureLibrary,
+	atoms []structure.Coords,
+	breaks []int,
+) (Bow, int) {
+	frags, skipped := StructureFragmentsBreaks(lib, atoms, breaks)
+	return frags.Bow(lib), skipped
+}
+
+// breaksMeta is recorded as the Data of a Bowed computed by a Bower given to
+// RecordSkippedWindows.
+type breaksMeta struct {
+	SkippedWindows int
+}
+
+func breaksData(skipped int) []byte {
+	bs, err := json.Marshal(breaksMeta{skipped})
+	if err != nil {
+		panic(fmt.Sprintf("BUG: Could not encode skipped windows: %s", err))
+	}
+	return bs
+}
+
+// skipCounter is implemented by the StructureBower values in this package
+// that compute BOWs from alpha-carbons. skippedBow is like StructureBow,
+// except the number of windows skipped is also returned.
+type skipCounter interface {
+	skippedBow(lib fragbag.StructureLibrary) (Bowed, int)
+}
+
+// skipCounters is like skipCounter, but for StructureBowers values. The
+// number of windows skipped is returned for every Bowed.
+type skipCounters interface {
+	skippedBows(lib fragbag.StructureLibrary) ([]Bowed, []int)
+}
+
+type skipsRecorder struct {
+	StructureBower
+}
+
+type skipsAllRecorder struct {
+	StructureBowers
+}
+
+// RecordSkippedWindows returns a StructureBower that computes the same BOW as
+// b, and records the number of windows skipped as JSON in the Data of the
+// Bowed computed. (See SkippedWindows.) The Bowers in this package leave the
+// Data empty unless they are wrapped this way.
+//
+// The number of windows skipped is only recorded when b is a Bower in this
+// package that computes BOWs from alpha-carbons (e.g., BowerFromChain) and
+// b does not set the Data itself. (BowerFromConfidentChain already records
+// skipped windows along with its options.)
+func RecordSkippedWindows(b StructureBower) StructureBower {
+	return skipsRecorder{b}
+}
+
+func (r skipsRecorder) StructureBow(lib fragbag.StructureLibrary) Bowed {
+	counter, ok := r.StructureBower.(skipCounter)
+	if !ok {
+		return r.StructureBower.StructureBow(lib)
+	}
+	b, skipped := counter.skippedBow(lib)
+	if b.Data == nil {
+		b.Data = breaksData(skipped)
+	}
+	return b
+}
+
+// RecordSkippedWindowsAll is like RecordSkippedWindows, except it wraps a
+// StructureBowers value such as BowerFromEntry. The number of windows skipped
+// is recorded for every Bowed computed.
+func RecordSkippedWindowsAll(bs StructureBowers) StructureBowers {
+	return skipsAllRecorder{bs}
+}
+
+func (r skipsAllRecorder) StructureBows(lib fragbag.StructureLibrary) []Bowed {
+	counters, ok := r.StructureBowers.(skipCounters)
+	if !ok {
+		return r.StructureBowers.StructureBows(lib)
+	}
+	bs, skipped := counters.skippedBows(lib)
+	for i := range bs {
+		if bs[i].Data == nil {
+			bs[i].Data = breaksData(skipped[i])
+		}
+	}
+	return bs
+}
+
+// SkippedWindows returns the number of windows that were skipped when the
+// BOW of b was computed, either because they span a chain break or because
+// they had low confidence (see ConfidenceOptions). It is recorded as JSON in
+// the Data of b by Bowers wrapped with RecordSkippedWindows and by
+// BowerFromConfidentChain.
+//
+// An error is returned if the Data of b doesn't record skipped windows.
+func SkippedWindows(b Bowed) (int, error) {
+	var meta struct{ SkippedWindows *int }
+	if err := json.Unmarshal(b.Data, &meta); err != nil ||
+		meta.SkippedWindows == nil {
+		return 0, fmt.Errorf("Bowed '%s' has no record of skipped windows.",
+			b.Id)
+	}
+	return *meta.SkippedWindows, nil
+}
+
+// structureBow is like StructureBow, except the number of windows skipped
+// is also returned.
+func structureBow(
+	lib fragbag.StructureLibrary,
+	atoms []structure.Coords,
+) (Bow, int) {
+	return StructureBowBreaks(lib, atoms,
+		ChainBreaks(atoms, nil, MaxCaDistance))
+}
+
+// modelAtoms returns the alpha-carbon atoms of every residue in a PDB model
+// along with the residue number of each atom. Residues without an
+// alpha-carbon are omitted.
+func modelAtoms(m *pdb.Model) ([]structure.Coords, []int) {
+	atoms := make([]structure.Coords, 0, len(m.Residues))
+	nums := make([]int, 0, len(m.Residues))
+	for _, r := range m.Residues {
+		for _, atom := range r.Atoms {
+			if atom.Name == "CA" {
+				atoms = append(atoms, atom.Coords)
+				nums = append(nums, r.SequenceNum)
+				break
+			}
+		}
+	}
+	return atoms, nums
+}
+
+// modelBow computes the BOW of a PDB model where chain breaks are detected
+// from both alpha-carbon distances and residue numbering.
+func modelBow(lib fragbag.StructureLibrary, m *pdb.Model) (Bow, int) {
+	atoms, nums := modelAtoms(m)
+	return StructureBowBreaks(lib, atoms, ChainBreaks(atoms, nums,
+		MaxCaDistance))
+}
+
+// chainBow computes the BOW of the first model of a PDB chain. (See
+// modelBow.)
+func chainBow(lib fragbag.StructureLibrary, c *pdb.Chain) (Bow, int) {
+	if len(c.Models) == 0 {
+		return structureBow(lib, c.CaAtoms())
+	}
+	return modelBow(lib, c.Models[0])
+}
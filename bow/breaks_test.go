@@ -0,0 +1,167 @@
+package bow
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/structure"
+)
+
+// gappedTrace is like caTrace, except every atom from `gap` on is moved
+// 10 Angstroms farther along the chain, as if residues were missing.
+func gappedTrace(gap int, frags ...int) []structure.Coords {
+	atoms := caTrace(frags...)
+	for i := gap; i < len(atoms); i++ {
+		atoms[i].X += 10
+	}
+	return atoms
+}
+
+func TestChainBreaks(t *testing.T) {
+	tests := []struct {
+		name  string
+		atoms []structure.Coords
+		nums  []int
+		want  []int
+	}{
+		{"none", caTrace(0, 0, 0, 0, 0), nil, []int{}},
+		{"distance", gappedTrace(3, 0, 0, 0, 0, 0), nil, []int{3}},
+		{"numbering", caTrace(0, 0, 0, 0, 0), []int{1, 2, 3, 5, 6}, []int{3}},
+		{"both", gappedTrace(1, 0, 0, 0, 0, 0), []int{1, 2, 3, 5, 6},
+			[]int{1, 3}},
+		{"insertion codes", caTrace(0, 0, 0, 0), []int{1, 2, 2, 3}, []int{}},
+		{"negative numbers", caTrace(0, 0, 0), []int{-1, 0, 1}, []int{}},
+		{"empty", nil, nil, []int{}},
+	}
+	for _, test := range tests {
+		got := ChainBreaks(test.atoms, test.nums, MaxCaDistance)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%s: expected breaks %v but got %v.",
+				test.name, test.want, got)
+		}
+	}
+}
+
+func TestStructureFragmentsBreaks(t *testing.T) {
+	lib := testStructureLibrary{}
+	atoms := caTrace(1, 2, 3, 4, 5, 6, 7, 8)
+
+	// A window of 3 atoms starting at i spans a break at b when
+	// i < b <= i+2. So windows that start or end right at a break are kept.
+	tests := []struct {
+		breaks  []int
+		want    Fragments
+		skipped int
+	}{
+		{nil, Fragments{1, 2, 3, 4, 5, 6}, 0},
+		{[]int{4}, Fragments{1, 2, -1, -1, 5, 6}, 2},
+		{[]int{2, 3}, Fragments{-1, -1, -1, 4, 5, 6}, 3},
+		{[]int{1}, Fragments{-1, 2, 3, 4, 5, 6}, 1},
+		{[]int{7}, Fragments{1, 2, 3, 4, 5, -1}, 1},
+		{[]int{0, 8}, Fragments{1, 2, 3, 4, 5, 6}, 0},
+	}
+	for _, test := range tests {
+		got, skipped := StructureFragmentsBreaks(lib, atoms, test.breaks)
+		if !reflect.DeepEqual(got, test.want) || skipped != test.skipped {
+			t.Fatalf("Breaks %v: expected %v (%d skipped) but got %v "+
+				"(%d skipped).", test.breaks, test.want, test.skipped,
+				got, skipped)
+		}
+	}
+
+	got, skipped := StructureFragmentsBreaks(lib, atoms[:2], nil)
+	if len(got) != 0 || skipped != 0 {
+		t.Fatalf("Expected no windows in a chain shorter than a fragment, "+
+			"but got %v.", got)
+	}
+}
+
+func TestStructureBowBreaks(t *testing.T) {
+	lib := testStructureLibrary{}
+
+	// Without breaks, every window is counted.
+	want := testBow(map[int]float32{1: 1, 2: 1, 3: 1, 4: 1})
+	got := StructureBow(lib, caTrace(1, 2, 3, 4, 0, 0))
+	if !got.Equal(want) {
+		t.Fatalf("Expected %s but got %s.", want, got)
+	}
+
+	// Windows 1 and 2 span the missing residues between atoms 2 and 3.
+	want = testBow(map[int]float32{1: 1, 4: 1})
+	got = StructureBow(lib, gappedTrace(3, 1, 2, 3, 4, 0, 0))
+	if !got.Equal(want) {
+		t.Fatalf("Expected %s but got %s.", want, got)
+	}
+}
+
+func TestBowerSkippedWindows(t *testing.T) {
+	lib := testStructureLibrary{}
+
+	// Residues are missing between the third and fourth residues.
+	e := &pdb.Entry{IdCode: "1ABC"}
+	testPdbChain(e, 'A', "", 1, 2, 3, 4, 5, 0, 0)
+	for _, r := range e.Chains[0].Models[0].Residues[3:] {
+		r.SequenceNum += 5
+	}
+
+	// The number of skipped windows is only recorded when asked for.
+	b := BowerFromChain(e.Chains[0]).StructureBow(lib)
+	want := testBow(map[int]float32{1: 1, 4: 1, 5: 1})
+	if !b.Bow.Equal(want) {
+		t.Fatalf("Expected %s but got %s.", want, b.Bow)
+	}
+	if b.Data != nil {
+		t.Fatalf("Expected no data but got '%s'.", b.Data)
+	}
+	b = RecordSkippedWindows(BowerFromChain(e.Chains[0])).StructureBow(lib)
+	if !b.Bow.Equal(want) {
+		t.Fatalf("Expected %s but got %s.", want, b.Bow)
+	}
+	if skipped, err := SkippedWindows(b); err != nil || skipped != 2 {
+		t.Fatalf("Expected 2 skipped windows but got %d (%v).", skipped, err)
+	}
+
+	model := BowerFromModel(e.Chains[0].Models[0])
+	b = RecordSkippedWindows(model).StructureBow(lib)
+	if skipped, err := SkippedWindows(b); err != nil || skipped != 2 {
+		t.Fatalf("Expected 2 skipped windows in the model but got %d (%v).",
+			skipped, err)
+	}
+
+	// Chain B has no breaks.
+	testPdbChain(e, 'B', "", 1, 2, 3, 4, 5, 0, 0)
+	tests := []struct {
+		policy EntryPolicy
+		want   []int
+	}{
+		{PerChain, []int{2, 0}},
+		{PerAssembly, []int{2}},
+		{PerEntity, []int{2, 0}},
+	}
+	for _, test := range tests {
+		bower := BowerFromEntry(e, test.policy)
+		plain := bower.StructureBows(lib)
+		bs := RecordSkippedWindowsAll(bower).StructureBows(lib)
+		if len(bs) != len(test.want) {
+			t.Fatalf("Policy '%s': expected %d BOWs but got %d.",
+				test.policy, len(test.want), len(bs))
+		}
+		for i, b := range bs {
+			skipped, err := SkippedWindows(b)
+			if err != nil || skipped != test.want[i] {
+				t.Fatalf("Policy '%s': expected %d skipped windows in '%s' "+
+					"but got %d (%v).", test.policy, test.want[i], b.Id,
+					skipped, err)
+			}
+			if plain[i].Data != nil || !plain[i].Bow.Equal(b.Bow) {
+				t.Fatalf("Policy '%s': recording skipped windows changed "+
+					"'%s'.", test.policy, b.Id)
+			}
+		}
+	}
+
+	if _, err := SkippedWindows(Bowed{Id: "1abcA"}); err == nil {
+		t.Fatalf("Expected an error for a Bowed without skipped windows.")
+	}
+}
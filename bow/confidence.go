@@ -0,0 +1,425 @@
+package bow
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/io/pdbx"
+	"github.com/TuftsBCB/structure"
+	"github.com/yunwilliamyu/esfragbag"
+)
+
+// ConfidenceMode determines what happens to a window of residues when any
+// of its residues has a confidence below the minimum.
+type ConfidenceMode int
+
+const (
+	// ConfidenceDrop skips windows with low confidence residues.
+	ConfidenceDrop ConfidenceMode = iota
+
+	// ConfidenceWeight counts windows with low confidence residues as a
+	// fraction of a fragment: the mean confidence of the window divided by
+	// the minimum confidence.
+	ConfidenceWeight
+)
+
+func (m ConfidenceMode) String() string {
+	switch m {
+	case ConfidenceDrop:
+		return "drop"
+	case ConfidenceWeight:
+		return "weight"
+	}
+	panic(fmt.Sprintf("Unrecognized confidence mode: %d", m))
+}
+
+// NewConfidenceMode returns the confidence mode corresponding to the name
+// given, which should be one of "drop" or "weight".
+func NewConfidenceMode(name string) (ConfidenceMode, error) {
+	for _, m := range []ConfidenceMode{ConfidenceDrop, ConfidenceWeight} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("Unrecognized confidence mode '%s'. Valid modes "+
+		"are 'drop' and 'weight'.", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m ConfidenceMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// ConfidenceOptions corresponds to the parameters of computing a BOW from
+// a structure with per-residue confidence values, like the pLDDT of an
+// AlphaFold model.
+type ConfidenceOptions struct {
+	// Min is the minimum confidence of a residue. A window with any residue
+	// below the minimum is handled according to Mode.
+	Min float64
+
+	// Mode specifies whether low confidence windows are dropped or
+	// down-weighted.
+	Mode ConfidenceMode
+}
+
+// ConfidenceDefault provides default settings for predicted structures.
+// Namely, windows with any residue that has a pLDDT below 70 are dropped.
+var ConfidenceDefault = ConfidenceOptions{
+	Min:  70,
+	Mode: ConfidenceDrop,
+}
+
+// confidenceMeta is recorded as the Data of a Bowed computed from a
+// structure with confidence values.
+type confidenceMeta struct {
+	MinConfidence  float64
+	Mode           ConfidenceMode
+	SkippedWindows int
+}
+
+func (opts ConfidenceOptions) meta(skipped int) []byte {
+	bs, err := json.Marshal(confidenceMeta{opts.Min, opts.Mode, skipped})
+	if err != nil {
+		panic(fmt.Sprintf("BUG: Could not encode confidence options: %s", err))
+	}
+	return bs
+}
+
+// ConfidenceBow is like StructureBowBreaks, except that every alpha-carbon
+// has a corresponding confidence value and windows are dropped or
+// down-weighted according to the options given. The number of windows
+// skipped (either because they span a chain break or because they were
+// dropped for low confidence) is also returned.
+//
+// ConfidenceBow will panic if `atoms` and `conf` have different lengths.
+func ConfidenceBow(
+	lib fragbag.StructureLibrary,
+	atoms []structure.Coords,
+	breaks []int,
+	conf []float64,
+	opts ConfidenceOptions,
+) (Bow, int) {
+	if len(atoms) != len(conf) {
+		panic(fmt.Sprintf("Got %d alpha-carbons but %d confidence values.",
+			len(atoms), len(conf)))
+	}
+
+	libSize := lib.FragmentSize()
+	frags, skipped := StructureFragmentsBreaks(lib, atoms, breaks)
+	b := NewBow(lib.Size())
+	for i, best := range frags {
+		if best < 0 {
+			continue
+		}
+
+		min, sum := conf[i], 0.0
+		for _, c := range conf[i : i+libSize] {
+			if c < min {
+				min = c
+			}
+			sum += c
+		}
+		w := float32(1)
+		if min < opts.Min {
+			if opts.Mode == ConfidenceDrop {
+				skipped++
+				continue
+			}
+			w = float32(sum / float64(libSize) / opts.Min)
+			if w > 1 {
+				w = 1
+			}
+		}
+		b.Freqs[best] += w
+	}
+	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
+		b = b.Weighted(wlib)
+	}
+	return b, skipped
+}
+
+type pdbConfidentChain struct {
+	pdbChainStructure
+	atoms []structure.Coords
+	nums  []int
+	conf  []float64
+	opts  ConfidenceOptions
+}
+
+// BowerFromConfidentChain provides an implementation of the StructureBower
+// interface for PDB chains with per-residue confidence values. The values
+// must correspond, in order, to the alpha-carbons of the chain's first model.
+// (ReadPDBConfidence reads them from the B-factor column of a PDB file.)
+//
+// The options and the number of skipped windows are recorded as JSON in the
+// Data of the Bowed computed.
+//
+// An error is returned if the number of confidence values does not match the
+// number of alpha-carbons.
+func BowerFromConfidentChain(
+	c *pdb.Chain,
+	conf []float64,
+	opts ConfidenceOptions,
+) (StructureBower, error) {
+	if len(c.Models) == 0 {
+		return nil, fmt.Errorf("Chain %c has no models.", c.Ident)
+	}
+	atoms, nums := modelAtoms(c.Models[0])
+	if len(atoms) != len(conf) {
+		return nil, fmt.Errorf("Chain %c has %d alpha-carbons but %d "+
+			"confidence values were given.", c.Ident, len(atoms), len(conf))
+	}
+	return pdbConfidentChain{pdbChainStructure{c}, atoms, nums, conf, opts}, nil
+}
+
+func (c pdbConfidentChain) StructureBow(lib fragbag.StructureLibrary) Bowed {
+	b, _ := c.skippedBow(lib)
+	return b
+}
+
+func (c pdbConfidentChain) skippedBow(
+	lib fragbag.StructureLibrary,
+) (Bowed, int) {
+	breaks := ChainBreaks(c.atoms, c.nums, MaxCaDistance)
+	b, skipped := ConfidenceBow(lib, c.atoms, breaks, c.conf, c.opts)
+	return Bowed{
+		Id:   c.id(),
+		Data: c.opts.meta(skipped),
+		Bow:  b,
+	}, skipped
+}
+
+type cifConfidentChain struct {
+	cifChainStructure
+	conf []float64
+	opts ConfidenceOptions
+}
+
+// BowerFromConfidentCifChain provides an implementation of the
+// StructureBower interface for chains in PDBx/mmCIF formatted files with
+// per-residue confidence values. The values must correspond, in order, to
+// the alpha-carbons of the chain's first model. (ReadCifConfidence reads them
+// from the B_iso_or_equiv column of a PDBx/mmCIF file.)
+//
+// The options and the number of skipped windows are recorded as JSON in the
+// Data of the Bowed computed.
+//
+// An error is returned if the number of confidence values does not match the
+// number of alpha-carbons.
+func BowerFromConfidentCifChain(
+	c *pdbx.Chain,
+	conf []float64,
+	opts ConfidenceOptions,
+) (StructureBower, error) {
+	if len(c.Models) == 0 {
+		return nil, fmt.Errorf("Chain %c has no models.", c.Id)
+	}
+	if n := len(c.Models[0].AlphaCarbons); n != len(conf) {
+		return nil, fmt.Errorf("Chain %c has %d alpha-carbons but %d "+
+			"confidence values were given.", c.Id, n, len(conf))
+	}
+	return cifConfidentChain{cifChainStructure{c}, conf, opts}, nil
+}
+
+func (c cifConfidentChain) StructureBow(lib fragbag.StructureLibrary) Bowed {
+	b, _ := c.skippedBow(lib)
+	return b
+}
+
+func (c cifConfidentChain) skippedBow(
+	lib fragbag.StructureLibrary,
+) (Bowed, int) {
+	atoms := c.Models[0].AlphaCarbons
+	breaks := ChainBreaks(atoms, nil, MaxCaDistance)
+	b, skipped := ConfidenceBow(lib, atoms, breaks, c.conf, c.opts)
+	return Bowed{
+		Id:   c.id(),
+		Data: c.opts.meta(skipped),
+		Bow:  b,
+	}, skipped
+}
+
+// ReadPDBConfidence reads the B-factor of every alpha-carbon in the first
+// model of a PDB formatted file, grouped by chain identifier and in the order
+// in which they appear. For predicted structures (e.g., from AlphaFold), the
+// B-factor column holds the per-residue confidence.
+//
+// Alpha-carbons are matched by the padded atom name " CA ", so that calcium
+// ions ("CA  ") are not mistaken for residues. HETATM records are only read
+// for modified residues of the polymer, which are those named by a MODRES
+// record. Only the first alternate location of each atom is read.
+func ReadPDBConfidence(r io.Reader) (map[byte][]float64, error) {
+	conf := make(map[byte][]float64)
+	modified := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "ENDMDL") {
+			break
+		}
+		if strings.HasPrefix(line, "MODRES") && len(line) >= 15 {
+			modified[line[12:15]] = true
+			continue
+		}
+		if !strings.HasPrefix(line, "ATOM  ") &&
+			!strings.HasPrefix(line, "HETATM") {
+			continue
+		}
+		if len(line) < 66 {
+			return nil, fmt.Errorf("Line %d: ATOM record is too short to "+
+				"have a B-factor.", lineno)
+		}
+		if line[12:16] != " CA " {
+			continue
+		}
+		if line[0] == 'H' && !modified[line[17:20]] {
+			continue
+		}
+		if alt := line[16]; alt != ' ' && alt != 'A' {
+			continue
+		}
+
+		bfactor, err := strconv.ParseFloat(strings.TrimSpace(line[60:66]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: Could not parse B-factor: %s",
+				lineno, err)
+		}
+		conf[line[21]] = append(conf[line[21]], bfactor)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// ReadCifConfidence reads the B_iso_or_equiv value of every alpha-carbon in
+// the first model of a PDBx/mmCIF formatted file, grouped by the (author)
+// chain identifier and in the order in which they appear. For predicted
+// structures (e.g., from AlphaFold), this column holds the per-residue
+// confidence.
+//
+// HETATM records are only read for residues of the polymer, which are those
+// with a label_seq_id. (Ions, ligands and waters have none.) Only the first
+// alternate location of each atom is read.
+func ReadCifConfidence(r io.Reader) (map[byte][]float64, error) {
+	conf := make(map[byte][]float64)
+	scanner := bufio.NewScanner(r)
+
+	var columns map[string]int
+	inLoop, inAtoms, firstModel := false, false, ""
+	column := func(fields []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := columns[name]; ok && i < len(fields) {
+				return fields[i]
+			}
+		}
+		return ""
+	}
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "loop_":
+			if inAtoms && len(conf) > 0 {
+				return conf, nil
+			}
+			inLoop, inAtoms = true, false
+			columns = make(map[string]int)
+			continue
+		case strings.HasPrefix(line, "_"):
+			if inLoop && strings.HasPrefix(line, "_atom_site.") {
+				inAtoms = true
+				name := strings.Fields(line)[0][len("_atom_site."):]
+				columns[name] = len(columns)
+				continue
+			}
+			if inAtoms && len(conf) > 0 {
+				return conf, nil
+			}
+			inLoop, inAtoms = false, false
+			continue
+		case line == "#" || len(line) == 0:
+			if inAtoms && len(conf) > 0 {
+				return conf, nil
+			}
+			continue
+		}
+		if !inAtoms {
+			continue
+		}
+
+		fields := cifFields(line)
+		if column(fields, "label_atom_id", "auth_atom_id") != "CA" {
+			continue
+		}
+		if column(fields, "group_PDB") == "HETATM" {
+			if seqId := column(fields, "label_seq_id"); seqId == "" ||
+				seqId == "." || seqId == "?" {
+				continue
+			}
+		}
+		if alt := column(fields, "label_alt_id"); alt != "" &&
+			alt != "." && alt != "?" && alt != "A" {
+			continue
+		}
+		if model := column(fields, "pdbx_PDB_model_num"); model != "" {
+			if firstModel == "" {
+				firstModel = model
+			} else if model != firstModel {
+				continue
+			}
+		}
+
+		chain := column(fields, "auth_asym_id", "label_asym_id")
+		if len(chain) == 0 {
+			return nil, fmt.Errorf("Line %d: No chain identifier found.",
+				lineno)
+		}
+		bfactor, err := strconv.ParseFloat(
+			column(fields, "B_iso_or_equiv"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: Could not parse B_iso_or_equiv: "+
+				"%s", lineno, err)
+		}
+		conf[chain[0]] = append(conf[chain[0]], bfactor)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// cifFields splits a row of a PDBx/mmCIF loop into its values. Values may be
+// quoted with single or double quotes.
+func cifFields(line string) []string {
+	fields := make([]string, 0, 20)
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '\'' || c == '"':
+			// A quote only ends a value when followed by whitespace.
+			end := i + 1
+			for end < len(line) &&
+				!(line[end] == c && (end+1 == len(line) ||
+					line[end+1] == ' ' || line[end+1] == '\t')) {
+				end++
+			}
+			fields = append(fields, line[i+1:end])
+			i = end + 1
+		default:
+			end := i
+			for end < len(line) && line[end] != ' ' && line[end] != '\t' {
+				end++
+			}
+			fields = append(fields, line[i:end])
+			i = end
+		}
+	}
+	return fields
+}
@@ -0,0 +1,177 @@
+package bow
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/TuftsBCB/io/pdb"
+)
+
+// pdbAtom returns an ATOM or HETATM record of a PDB file.
+func pdbAtom(record, name string, alt byte, res string, chain byte,
+	num int, bfactor float64) string {
+	return fmt.Sprintf("%-6s%5d %4s%c%3s %c%4d    %8.3f%8.3f%8.3f%6.2f%6.2f",
+		record, 1, name, alt, res, chain, num, 0.0, 0.0, 0.0, 1.0, bfactor)
+}
+
+func TestReadPDBConfidence(t *testing.T) {
+	lines := []string{
+		"HEADER    TEST",
+		"MODRES 1ABC MSE A    3  MET  SELENOMETHIONINE",
+		"MODEL        1",
+		pdbAtom("ATOM", " N  ", ' ', "MET", 'A', 1, 10),
+		pdbAtom("ATOM", " CA ", ' ', "MET", 'A', 1, 90),
+		pdbAtom("ATOM", " CA ", 'A', "SER", 'A', 2, 80),
+		pdbAtom("ATOM", " CA ", 'B', "SER", 'A', 2, 10),
+		pdbAtom("HETATM", " CA ", ' ', "MSE", 'A', 3, 70),
+		pdbAtom("ATOM", " CA ", ' ', "GLY", 'B', 1, 60),
+		pdbAtom("HETATM", "CA  ", ' ', " CA", 'A', 101, 20),
+		pdbAtom("HETATM", " CA ", ' ', "LIG", 'A', 102, 30),
+		"ENDMDL",
+		"MODEL        2",
+		pdbAtom("ATOM", " CA ", ' ', "MET", 'A', 1, 5),
+		"ENDMDL",
+	}
+	pdbFile := strings.Join(lines, "\n")
+	conf, err := ReadPDBConfidence(strings.NewReader(pdbFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[byte][]float64{'A': {90, 80, 70}, 'B': {60}}
+	if !reflect.DeepEqual(conf, want) {
+		t.Fatalf("Expected %v but got %v.", want, conf)
+	}
+
+	short := "ATOM      1  CA  MET A   1       0.000   0.000"
+	if _, err := ReadPDBConfidence(strings.NewReader(short)); err == nil {
+		t.Fatalf("Expected an error for an ATOM record without a B-factor.")
+	}
+}
+
+func TestReadCifConfidence(t *testing.T) {
+	cif := `data_1ABC
+#
+_entry.id 1ABC
+#
+loop_
+_atom_site.group_PDB
+_atom_site.id
+_atom_site.type_symbol
+_atom_site.label_atom_id
+_atom_site.label_alt_id
+_atom_site.label_comp_id
+_atom_site.label_asym_id
+_atom_site.label_seq_id
+_atom_site.B_iso_or_equiv
+_atom_site.auth_asym_id
+_atom_site.pdbx_PDB_model_num
+ATOM   1  N  N  . MET A 1 10.0 A 1
+ATOM   2  C  CA . MET A 1 90.0 A 1
+ATOM   3  C  CA A SER A 2 80.0 A 1
+ATOM   4  C  CA B SER A 2 10.0 A 1
+HETATM 5  C  CA . MSE A 3 70.0 A 1
+ATOM   6  C  'CA' . "GLY" A 4 65.0 A 1
+ATOM   7  C  CA . GLY B 1 60.0 B 1
+HETATM 8  CA CA . CA  C . 20.0 A 1
+HETATM 9  C  CA . LIG D . 30.0 A 1
+ATOM   10 C  CA . MET A 1 5.0 A 2
+#
+loop_
+_struct_conf.id
+HELX1
+#
+`
+	conf, err := ReadCifConfidence(strings.NewReader(cif))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[byte][]float64{'A': {90, 80, 70, 65}, 'B': {60}}
+	if !reflect.DeepEqual(conf, want) {
+		t.Fatalf("Expected %v but got %v.", want, conf)
+	}
+}
+
+func TestCifFields(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"ATOM 1 C CA", []string{"ATOM", "1", "C", "CA"}},
+		{"ATOM  'a b'\t\"c d\" e", []string{"ATOM", "a b", "c d", "e"}},
+		{"HETATM \"O5'\" 'it's'", []string{"HETATM", "O5'", "it's"}},
+		{"'C1''", []string{"C1'"}},
+	}
+	for _, test := range tests {
+		if got := cifFields(test.line); !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("Line %q: expected %q but got %q.",
+				test.line, test.want, got)
+		}
+	}
+}
+
+func TestConfidenceBow(t *testing.T) {
+	lib := testStructureLibrary{}
+	atoms := caTrace(1, 2, 3, 4, 0, 0)
+	conf := []float64{70, 70, 70, 70, 7, 70}
+
+	// The windows starting at atoms 2 and 3 have a residue below 70, with
+	// a mean confidence of 49.
+	opts := ConfidenceOptions{Min: 70, Mode: ConfidenceDrop}
+	b, skipped := ConfidenceBow(lib, atoms, nil, conf, opts)
+	assertBowNear(t, "drop", b, testBow(map[int]float32{1: 1, 2: 1}))
+	if skipped != 2 {
+		t.Fatalf("Expected 2 dropped windows but got %d.", skipped)
+	}
+
+	opts.Mode = ConfidenceWeight
+	b, skipped = ConfidenceBow(lib, atoms, nil, conf, opts)
+	assertBowNear(t, "weight", b,
+		testBow(map[int]float32{1: 1, 2: 1, 3: 0.7, 4: 0.7}))
+	if skipped != 0 {
+		t.Fatalf("Expected no skipped windows but got %d.", skipped)
+	}
+
+	// Windows spanning a break are skipped in either mode.
+	b, skipped = ConfidenceBow(lib, atoms, []int{1}, conf, opts)
+	assertBowNear(t, "weight with break", b,
+		testBow(map[int]float32{2: 1, 3: 0.7, 4: 0.7}))
+	if skipped != 1 {
+		t.Fatalf("Expected 1 skipped window but got %d.", skipped)
+	}
+}
+
+func TestConfidentChain(t *testing.T) {
+	lib := testStructureLibrary{}
+	e := &pdb.Entry{IdCode: "1ABC"}
+	testPdbChain(e, 'A', "", 1, 2, 3, 4, 0, 0)
+	c := e.Chains[0]
+
+	_, err := BowerFromConfidentChain(c, []float64{90}, ConfidenceDefault)
+	if err == nil {
+		t.Fatalf("Expected an error for too few confidence values.")
+	}
+
+	conf := []float64{90, 90, 90, 90, 50, 90}
+	bower, err := BowerFromConfidentChain(c, conf, ConfidenceDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bower.StructureBow(lib)
+	if b.Id != "1abcA" {
+		t.Fatalf("Expected ID '1abcA' but got '%s'.", b.Id)
+	}
+	assertBowNear(t, "chain", b.Bow, testBow(map[int]float32{1: 1, 2: 1}))
+	if skipped, err := SkippedWindows(b); err != nil || skipped != 2 {
+		t.Fatalf("Expected 2 skipped windows but got %d (%v).", skipped, err)
+	}
+	want := `{"MinConfidence":70,"Mode":"drop","SkippedWindows":2}`
+	if string(b.Data) != want {
+		t.Fatalf("Expected data %s but got %s.", want, b.Data)
+	}
+	b = RecordSkippedWindows(bower).StructureBow(lib)
+	if string(b.Data) != want {
+		t.Fatalf("Expected recorded data %s but got %s.", want, b.Data)
+	}
+}
@@ -4,9 +4,22 @@ definitions of common operations. These operations include computing the cosine
 or euclidean distance between two BOWs, comparing BOWs and producing BOWs from
 values of other types (like a PDB chain or a biological sequence).
 
+Since a BOW discards the order in which fragments appear, this package also
+provides an ordered representation (Fragments) along with an alignment of
+two such values (Align), scored by a fragment-to-fragment substitution matrix.
+The distances between two BOWs can be broken down into the contributions of
+each fragment with Explain.
+
 This package also includes special interoperable functions with the original
 FragBag implementation written by Rachel Kolodny. Namely, BOWs in the original
 implementation are encoded as strings (Bow.StringOldStyle writes them and
 NewOldStyleBow reads them).
+
+Bowed values can also be written and read as JSON Lines (JSONEncoder and
+JSONDecoder) or as tab separated values (TSVEncoder and TSVDecoder), which
+makes it possible to exchange BOWs with programs not written in Go.
+
+The projection subpackage maps BOWs into a space of much lower dimension,
+which can be used to quickly prefilter candidates before an exact search.
 */
 package bow
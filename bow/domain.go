@@ -0,0 +1,271 @@
+package bow
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/structure"
+	"github.com/yunwilliamyu/esfragbag"
+)
+
+// Segment is a contiguous range of residues in a single chain.
+type Segment struct {
+	// The chain identifier. A value of 0 corresponds to every chain in
+	// an entry.
+	Chain byte
+
+	// When All is true, the segment covers every residue in the chain and
+	// Start and End are ignored.
+	All bool
+
+	// The first and last residue numbers (inclusive) of the segment.
+	Start, End int
+}
+
+// Contains returns true if the residue with the given number in the given
+// chain is part of this segment.
+func (s Segment) Contains(chain byte, num int) bool {
+	if s.Chain != 0 && s.Chain != chain {
+		return false
+	}
+	return s.All || (num >= s.Start && num <= s.End)
+}
+
+// Domain corresponds to a structural domain (e.g., from SCOP, CATH or ECOD)
+// made up of one or more segments of a PDB entry. Discontinuous domains have
+// more than one segment.
+type Domain struct {
+	// The domain identifier. e.g., "d1ctfa_" or "1ctfA00".
+	Id string
+
+	// The lowercase PDB identifier of the entry containing the domain.
+	Entry string
+
+	Segments []Segment
+}
+
+// ReadDomains reads domain boundaries from a domain boundary file. Empty
+// lines and lines starting with '#' are ignored. Every other line should have
+// one of the two following forms, with columns separated by whitespace:
+//
+//	domain-id pdb-id segments [ignored columns ...]
+//	cath-domain-id segments
+//
+// The first form corresponds to SCOP's dir.cla files (and ECOD files with
+// the columns rearranged), where segments is a comma separated list of
+// "chain:start-end" or "chain:" (the whole chain) or "-" (the whole entry).
+// e.g., "A:1-100,A:150-200".
+//
+// The second form corresponds to CATH's domain boundary files, where the
+// PDB identifier and chain are taken from the first five characters of the
+// CATH domain identifier, and segments is a comma separated list of
+// "start-end". e.g., "1-100,150-200".
+//
+// Residue numbers may be negative and may have an insertion code, which is
+// ignored.
+func ReadDomains(r io.Reader) ([]Domain, error) {
+	domains := make([]Domain, 0, 1000)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var d Domain
+		var err error
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 3:
+			d.Id, d.Entry = fields[0], strings.ToLower(fields[1])
+			d.Segments, err = parseSegments(fields[2], 0)
+		case len(fields) == 2 && len(fields[0]) >= 5:
+			d.Id, d.Entry = fields[0], strings.ToLower(fields[0][0:4])
+			d.Segments, err = parseSegments(fields[1], fields[0][4])
+		default:
+			err = fmt.Errorf("Expected a domain identifier followed by " +
+				"segments.")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", lineno, err)
+		}
+		domains = append(domains, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return domains, nil
+}
+
+// DomainsByEntry groups domains by the entry containing them, preserving the
+// order of the domains in each entry. This is useful for passing only the
+// domains of a single entry to BowerFromDomains.
+func DomainsByEntry(domains []Domain) map[string][]Domain {
+	byEntry := make(map[string][]Domain)
+	for _, d := range domains {
+		byEntry[d.Entry] = append(byEntry[d.Entry], d)
+	}
+	return byEntry
+}
+
+// parseSegments parses a comma separated list of segments. If chain is not
+// 0, then the segments must not have chain identifiers.
+func parseSegments(s string, chain byte) ([]Segment, error) {
+	if s == "-" {
+		return []Segment{{Chain: chain, All: true}}, nil
+	}
+
+	pieces := strings.Split(s, ",")
+	segs := make([]Segment, len(pieces))
+	for i, piece := range pieces {
+		seg := Segment{Chain: chain}
+		if chain == 0 {
+			colon := strings.IndexByte(piece, ':')
+			if colon != 1 {
+				return nil, fmt.Errorf("Could not find chain identifier in "+
+					"segment '%s'.", piece)
+			}
+			seg.Chain, piece = piece[0], piece[2:]
+		}
+		if len(piece) == 0 {
+			seg.All = true
+			segs[i] = seg
+			continue
+		}
+
+		// Skip the first character so that negative start residues are
+		// not mistaken for the separator.
+		dash := strings.IndexByte(piece[1:], '-') + 1
+		if dash == 0 {
+			return nil, fmt.Errorf("Could not find residue range in "+
+				"segment '%s'.", piece)
+		}
+		var err error
+		if seg.Start, err = parseResidueNum(piece[:dash]); err != nil {
+			return nil, err
+		}
+		if seg.End, err = parseResidueNum(piece[dash+1:]); err != nil {
+			return nil, err
+		}
+		segs[i] = seg
+	}
+	return segs, nil
+}
+
+// parseResidueNum parses a residue number with an optional insertion code,
+// which is discarded.
+func parseResidueNum(s string) (int, error) {
+	s = strings.TrimRightFunc(s, func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	})
+	num, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse residue number '%s'.", s)
+	}
+	return num, nil
+}
+
+type pdbDomainStructure struct {
+	*pdb.Entry
+	Domain
+}
+
+// BowerFromDomain provides a reference implementation of the StructureBower
+// interface for a domain in a PDB entry. Only residues in the domain's
+// segments (in the first model of each chain) are used to compute the BOW,
+// and the Bowed has the domain's identifier.
+//
+// Windows that span a chain break or the boundary between two segments of a
+// discontinuous domain are skipped. (Their number can be recorded with
+// RecordSkippedWindows.)
+func BowerFromDomain(e *pdb.Entry, d Domain) StructureBower {
+	return pdbDomainStructure{e, d}
+}
+
+func (d pdbDomainStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
+	b, _ := d.skippedBow(lib)
+	return b
+}
+
+func (d pdbDomainStructure) skippedBow(
+	lib fragbag.StructureLibrary,
+) (Bowed, int) {
+	atoms := make([]structure.Coords, 0, 100)
+	breaks := make([]int, 0)
+	for _, seg := range d.Segments {
+		for _, c := range d.Chains {
+			if len(c.Models) == 0 || (seg.Chain != 0 && seg.Chain != c.Ident) {
+				continue
+			}
+
+			segAtoms, segNums := modelAtoms(c.Models[0])
+			start, end := 0, len(segAtoms)
+			if !seg.All {
+				for start < end && !seg.Contains(c.Ident, segNums[start]) {
+					start++
+				}
+				for end > start && !seg.Contains(c.Ident, segNums[end-1]) {
+					end--
+				}
+			}
+			if start == end {
+				continue
+			}
+			segAtoms, segNums = segAtoms[start:end], segNums[start:end]
+
+			offset := len(atoms)
+			if offset > 0 {
+				breaks = append(breaks, offset)
+			}
+			for _, b := range ChainBreaks(segAtoms, segNums, MaxCaDistance) {
+				breaks = append(breaks, offset+b)
+			}
+			atoms = append(atoms, segAtoms...)
+		}
+	}
+
+	b, skipped := StructureBowBreaks(lib, atoms, breaks)
+	return Bowed{Id: d.Id, Bow: b}, skipped
+}
+
+type pdbDomainsStructure struct {
+	*pdb.Entry
+	domains []Domain
+}
+
+// BowerFromDomains provides a reference implementation of the
+// StructureBowers interface that computes one BOW for every domain given
+// that is in the PDB entry. Domains in other entries are ignored.
+//
+// Every domain given is checked against the entry, so when computing the BOWs
+// of many entries from the output of ReadDomains (which usually contains the
+// domains of many entries), group the domains with DomainsByEntry and pass
+// each entry only its own domains.
+func BowerFromDomains(e *pdb.Entry, domains []Domain) StructureBowers {
+	return pdbDomainsStructure{e, domains}
+}
+
+func (e pdbDomainsStructure) StructureBows(
+	lib fragbag.StructureLibrary,
+) []Bowed {
+	bs, _ := e.skippedBows(lib)
+	return bs
+}
+
+func (e pdbDomainsStructure) skippedBows(
+	lib fragbag.StructureLibrary,
+) ([]Bowed, []int) {
+	bs, skipped := make([]Bowed, 0), make([]int, 0)
+	idCode := strings.ToLower(e.IdCode)
+	for _, d := range e.domains {
+		if d.Entry == idCode {
+			b, n := pdbDomainStructure{e.Entry, d}.skippedBow(lib)
+			bs, skipped = append(bs, b), append(skipped, n)
+		}
+	}
+	return bs, skipped
+}
@@ -0,0 +1,52 @@
+package bow
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadDomains(t *testing.T) {
+	input := `# A comment.
+d1ux8a_	1ux8	A:	a.1.1.1	113449
+d1dlwa1	1DLW	A:-5-45,A:60A-100	a.1.1.1	14982
+d1abc__	1abc	-	a.1.1.1	14983
+
+1cukA01	1-48,140-156
+`
+	expected := []Domain{
+		{"d1ux8a_", "1ux8", []Segment{{'A', true, 0, 0}}},
+		{"d1dlwa1", "1dlw", []Segment{{'A', false, -5, 45},
+			{'A', false, 60, 100}}},
+		{"d1abc__", "1abc", []Segment{{0, true, 0, 0}}},
+		{"1cukA01", "1cuk", []Segment{{'A', false, 1, 48},
+			{'A', false, 140, 156}}},
+	}
+
+	domains, err := ReadDomains(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Could not read domains: %s", err)
+	}
+	if !reflect.DeepEqual(domains, expected) {
+		t.Fatalf("Expected domains\n%v\nbut got\n%v", expected, domains)
+	}
+
+	if _, err := ReadDomains(strings.NewReader("d1abc_ 1abc A:1\n")); err == nil {
+		t.Fatalf("Expected an error for a segment without a range.")
+	}
+}
+
+func TestDomainsByEntry(t *testing.T) {
+	domains := []Domain{
+		{Id: "d1abca_", Entry: "1abc"},
+		{Id: "d2xyza_", Entry: "2xyz"},
+		{Id: "d1abcb_", Entry: "1abc"},
+	}
+	expected := map[string][]Domain{
+		"1abc": {domains[0], domains[2]},
+		"2xyz": {domains[1]},
+	}
+	if got := DomainsByEntry(domains); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected\n%v\nbut got\n%v", expected, got)
+	}
+}
@@ -0,0 +1,275 @@
+package bow
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file provides text encodings of Bowed values that can be read without
+// this package: JSON Lines (one JSON object per line) and tab separated
+// values. In both encodings, a BOW is stored sparsely. Namely, only fragments
+// with a non-zero frequency are written.
+
+// DataEncoding specifies how the arbitrary data of a Bowed value is
+// represented in a text encoding.
+type DataEncoding int
+
+const (
+	// DataBase64 encodes data with standard base64 encoding. Any data can be
+	// represented.
+	DataBase64 DataEncoding = iota
+
+	// DataUTF8 writes data as is. Data must be valid UTF-8. In the TSV
+	// encoding, data must also not contain tabs or new lines.
+	DataUTF8
+)
+
+func (enc DataEncoding) encode(data []byte) (string, error) {
+	switch enc {
+	case DataBase64:
+		return base64.StdEncoding.EncodeToString(data), nil
+	case DataUTF8:
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("Data is not valid UTF-8.")
+		}
+		return string(data), nil
+	}
+	panic(fmt.Sprintf("Unrecognized data encoding: %d", enc))
+}
+
+func (enc DataEncoding) decode(s string) ([]byte, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	switch enc {
+	case DataBase64:
+		return base64.StdEncoding.DecodeString(s)
+	case DataUTF8:
+		return []byte(s), nil
+	}
+	panic(fmt.Sprintf("Unrecognized data encoding: %d", enc))
+}
+
+// jsonBowed is the representation of a Bowed value in the JSON Lines
+// encoding. e.g.,
+//
+//	{"Id":"1ctfA","Data":"","Bow":{"12":1,"40":2}}
+type jsonBowed struct {
+	Id   string
+	Data string
+	Bow  map[string]float32
+}
+
+// JSONEncoder writes Bowed values as JSON Lines.
+type JSONEncoder struct {
+	w   io.Writer
+	enc DataEncoding
+}
+
+// NewJSONEncoder returns an encoder that writes to w with the given data
+// encoding.
+func NewJSONEncoder(w io.Writer, enc DataEncoding) *JSONEncoder {
+	return &JSONEncoder{w, enc}
+}
+
+// Encode writes a single Bowed value as a line of JSON.
+func (e *JSONEncoder) Encode(b Bowed) error {
+	data, err := e.enc.encode(b.Data)
+	if err != nil {
+		return fmt.Errorf("Could not encode '%s': %s", b.Id, err)
+	}
+	jb := jsonBowed{b.Id, data, make(map[string]float32)}
+	for i, f := range b.Bow.Freqs {
+		if f != 0 {
+			jb.Bow[strconv.Itoa(i)] = f
+		}
+	}
+
+	bs, err := json.Marshal(jb)
+	if err != nil {
+		return fmt.Errorf("Could not encode '%s': %s", b.Id, err)
+	}
+	if _, err := e.w.Write(append(bs, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+// JSONDecoder reads Bowed values from JSON Lines.
+type JSONDecoder struct {
+	dec  *json.Decoder
+	size int
+	enc  DataEncoding
+}
+
+// NewJSONDecoder returns a decoder that reads from r. The size given is the
+// number of fragments in the library used to compute the BOWs, and enc must
+// be the data encoding used when they were written.
+func NewJSONDecoder(r io.Reader, size int, enc DataEncoding) *JSONDecoder {
+	return &JSONDecoder{json.NewDecoder(r), size, enc}
+}
+
+// Decode reads the next Bowed value. When there are no more values, io.EOF
+// is returned.
+func (d *JSONDecoder) Decode() (Bowed, error) {
+	var jb jsonBowed
+	if err := d.dec.Decode(&jb); err != nil {
+		return Bowed{}, err
+	}
+
+	data, err := d.enc.decode(jb.Data)
+	if err != nil {
+		return Bowed{}, fmt.Errorf("Could not decode data of '%s': %s",
+			jb.Id, err)
+	}
+	b := NewBow(d.size)
+	for key, f := range jb.Bow {
+		fragNum, err := parseFragNum(key, d.size)
+		if err != nil {
+			return Bowed{}, fmt.Errorf("Could not decode BOW of '%s': %s",
+				jb.Id, err)
+		}
+		b.Freqs[fragNum] = f
+	}
+	return Bowed{Id: jb.Id, Data: data, Bow: b}, nil
+}
+
+// TSVEncoder writes Bowed values as tab separated values. Each line has three
+// columns: the identifier, the data and a comma separated list of
+// "fragment:frequency" pairs. e.g.,
+//
+//	1ctfA		12:1,40:2
+type TSVEncoder struct {
+	w   *bufio.Writer
+	enc DataEncoding
+}
+
+// NewTSVEncoder returns an encoder that writes to w with the given data
+// encoding.
+//
+// When you're finished encoding values, you must call Flush.
+func NewTSVEncoder(w io.Writer, enc DataEncoding) *TSVEncoder {
+	return &TSVEncoder{bufio.NewWriter(w), enc}
+}
+
+// Encode writes a single Bowed value as a line of tab separated values.
+// Identifiers must not contain tabs or new lines.
+func (e *TSVEncoder) Encode(b Bowed) error {
+	if strings.ContainsAny(b.Id, "\t\n") {
+		return fmt.Errorf("Identifier '%s' contains a tab or a new line.",
+			b.Id)
+	}
+	data, err := e.enc.encode(b.Data)
+	if err != nil {
+		return fmt.Errorf("Could not encode '%s': %s", b.Id, err)
+	}
+	if strings.ContainsAny(data, "\t\n") {
+		return fmt.Errorf("Data of '%s' contains a tab or a new line.", b.Id)
+	}
+
+	pieces := make([]string, 0, 50)
+	for i, f := range b.Bow.Freqs {
+		if f != 0 {
+			pieces = append(pieces, fmt.Sprintf("%d:%s",
+				i, strconv.FormatFloat(float64(f), 'g', -1, 32)))
+		}
+	}
+	_, err = fmt.Fprintf(e.w, "%s\t%s\t%s\n",
+		b.Id, data, strings.Join(pieces, ","))
+	return err
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (e *TSVEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+// TSVDecoder reads Bowed values from tab separated values.
+type TSVDecoder struct {
+	scanner *bufio.Scanner
+	size    int
+	enc     DataEncoding
+	lineno  int
+}
+
+// NewTSVDecoder returns a decoder that reads from r. The size given is the
+// number of fragments in the library used to compute the BOWs, and enc must
+// be the data encoding used when they were written.
+func NewTSVDecoder(r io.Reader, size int, enc DataEncoding) *TSVDecoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 1<<16), 1<<26)
+	return &TSVDecoder{scanner, size, enc, 0}
+}
+
+// Decode reads the next Bowed value. When there are no more values, io.EOF
+// is returned. Empty lines are skipped.
+func (d *TSVDecoder) Decode() (Bowed, error) {
+	for d.scanner.Scan() {
+		d.lineno++
+		line := d.scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+		b, err := d.decodeLine(line)
+		if err != nil {
+			return Bowed{}, fmt.Errorf("Line %d: %s", d.lineno, err)
+		}
+		return b, nil
+	}
+	if err := d.scanner.Err(); err != nil {
+		return Bowed{}, err
+	}
+	return Bowed{}, io.EOF
+}
+
+func (d *TSVDecoder) decodeLine(line string) (Bowed, error) {
+	cols := strings.Split(line, "\t")
+	if len(cols) != 3 {
+		return Bowed{}, fmt.Errorf("Expected 3 columns but found %d.",
+			len(cols))
+	}
+	data, err := d.enc.decode(cols[1])
+	if err != nil {
+		return Bowed{}, fmt.Errorf("Could not decode data: %s", err)
+	}
+
+	b := NewBow(d.size)
+	if len(cols[2]) > 0 {
+		for _, pair := range strings.Split(cols[2], ",") {
+			colon := strings.IndexByte(pair, ':')
+			if colon < 0 {
+				return Bowed{}, fmt.Errorf("Expected 'fragment:frequency' "+
+					"but got '%s'.", pair)
+			}
+			fragNum, err := parseFragNum(pair[:colon], d.size)
+			if err != nil {
+				return Bowed{}, err
+			}
+			f, err := strconv.ParseFloat(pair[colon+1:], 32)
+			if err != nil {
+				return Bowed{}, fmt.Errorf("Could not parse frequency "+
+					"'%s'.", pair[colon+1:])
+			}
+			b.Freqs[fragNum] = float32(f)
+		}
+	}
+	return Bowed{Id: cols[0], Data: data, Bow: b}, nil
+}
+
+func parseFragNum(s string, size int) (int, error) {
+	fragNum, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse fragment number '%s'.", s)
+	}
+	if fragNum < 0 || fragNum >= size {
+		return 0, fmt.Errorf("The fragment number '%d' is outside the "+
+			"allowed range [0, %d).", fragNum, size)
+	}
+	return fragNum, nil
+}
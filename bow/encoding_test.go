@@ -0,0 +1,70 @@
+package bow
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+var encodingTests = []Bowed{
+	{"1ctfA", nil, newBowMap(10, map[int]float32{0: 1, 9: 2.5})},
+	{"d1dlwa1", []byte("MSLFEQLGGQAAV"), newBowMap(10, map[int]float32{})},
+	{"binary", []byte{0, '\t', '\n', 255}, newBowMap(10, map[int]float32{
+		3: 0.1, 4: 1e-7,
+	})},
+}
+
+func TestJSONEncoding(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewJSONEncoder(buf, DataBase64)
+	for _, b := range encodingTests {
+		if err := enc.Encode(b); err != nil {
+			t.Fatalf("Could not encode '%s': %s", b.Id, err)
+		}
+	}
+
+	dec := NewJSONDecoder(buf, 10, DataBase64)
+	for _, expected := range encodingTests {
+		b, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("Could not decode '%s': %s", expected.Id, err)
+		}
+		if !reflect.DeepEqual(b, expected) {
+			t.Fatalf("Expected %v but got %v.", expected, b)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("Expected io.EOF but got %v.", err)
+	}
+}
+
+func TestTSVEncoding(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewTSVEncoder(buf, DataUTF8)
+	for _, b := range encodingTests[0:2] {
+		if err := enc.Encode(b); err != nil {
+			t.Fatalf("Could not encode '%s': %s", b.Id, err)
+		}
+	}
+	if err := enc.Encode(encodingTests[2]); err == nil {
+		t.Fatalf("Expected an error encoding binary data as UTF-8.")
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewTSVDecoder(buf, 10, DataUTF8)
+	for _, expected := range encodingTests[0:2] {
+		b, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("Could not decode '%s': %s", expected.Id, err)
+		}
+		if !reflect.DeepEqual(b, expected) {
+			t.Fatalf("Expected %v but got %v.", expected, b)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("Expected io.EOF but got %v.", err)
+	}
+}
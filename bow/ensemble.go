@@ -0,0 +1,214 @@
+package bow
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/io/pdbx"
+	"github.com/yunwilliamyu/esfragbag"
+)
+
+// EnsemblePolicy determines how BOWs are computed for a chain with more than
+// one model, as found in NMR and cryo-EM ensembles.
+type EnsemblePolicy int
+
+const (
+	// PerModel computes one BOW for every model of a chain. Each Bowed has
+	// an identifier made of the chain's identifier and the model's number.
+	// e.g., "1ctfA1".
+	PerModel EnsemblePolicy = iota
+
+	// EnsembleMean computes a single BOW for a chain where the frequency of
+	// each fragment is the mean of its frequencies across all models.
+	EnsembleMean
+
+	// EnsembleMin computes a single BOW for a chain where the frequency of
+	// each fragment is the minimum of its frequencies across all models.
+	EnsembleMin
+
+	// EnsembleMax computes a single BOW for a chain where the frequency of
+	// each fragment is the maximum of its frequencies across all models.
+	EnsembleMax
+)
+
+func (p EnsemblePolicy) String() string {
+	switch p {
+	case PerModel:
+		return "model"
+	case EnsembleMean:
+		return "mean"
+	case EnsembleMin:
+		return "min"
+	case EnsembleMax:
+		return "max"
+	}
+	panic(fmt.Sprintf("Unrecognized ensemble policy: %d", p))
+}
+
+// NewEnsemblePolicy returns the ensemble policy corresponding to the name
+// given, which should be one of "model", "mean", "min" or "max".
+func NewEnsemblePolicy(name string) (EnsemblePolicy, error) {
+	all := []EnsemblePolicy{PerModel, EnsembleMean, EnsembleMin, EnsembleMax}
+	for _, p := range all {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("Unrecognized ensemble policy '%s'. Valid policies "+
+		"are 'model', 'mean', 'min' and 'max'.", name)
+}
+
+// combine reduces the BOWs of every model in an ensemble to a single BOW
+// according to the policy. An empty ensemble results in a BOW with all
+// frequencies set to 0.
+//
+// combine will panic if the policy is PerModel.
+func (p EnsemblePolicy) combine(size int, bows []Bow) Bow {
+	combined := NewBow(size)
+	if len(bows) == 0 {
+		return combined
+	}
+	if p == EnsembleMean {
+		return Mean(bows)
+	}
+	for i := 0; i < size; i++ {
+		f := bows[0].Freqs[i]
+		for _, b := range bows[1:] {
+			switch p {
+			case EnsembleMin:
+				if b.Freqs[i] < f {
+					f = b.Freqs[i]
+				}
+			case EnsembleMax:
+				if b.Freqs[i] > f {
+					f = b.Freqs[i]
+				}
+			default:
+				panic(fmt.Sprintf("Cannot combine BOWs with policy '%s'.", p))
+			}
+		}
+		combined.Freqs[i] = f
+	}
+	return combined
+}
+
+type pdbEnsembleStructure struct {
+	*pdb.Chain
+	policy EnsemblePolicy
+}
+
+// BowerFromEnsemble provides a reference implementation of the
+// StructureBowers interface for all models of a PDB chain. The policy given
+// determines whether a BOW is computed for each model or whether the BOWs of
+// all models are combined into one.
+func BowerFromEnsemble(c *pdb.Chain, policy EnsemblePolicy) StructureBowers {
+	return pdbEnsembleStructure{c, policy}
+}
+
+func (c pdbEnsembleStructure) StructureBows(
+	lib fragbag.StructureLibrary,
+) []Bowed {
+	bs, _ := c.skippedBows(lib)
+	return bs
+}
+
+func (c pdbEnsembleStructure) skippedBows(
+	lib fragbag.StructureLibrary,
+) ([]Bowed, []int) {
+	bs, skipped := make([]Bowed, len(c.Models)), make([]int, len(c.Models))
+	for i, m := range c.Models {
+		bs[i], skipped[i] = pdbModelStructure{m}.skippedBow(lib)
+	}
+	if c.policy == PerModel {
+		return bs, skipped
+	}
+	return []Bowed{{
+		Id:  pdbChainStructure{c.Chain}.id(),
+		Bow: c.policy.combine(lib.Size(), bowsOf(bs)),
+	}}, []int{sum(skipped)}
+}
+
+type cifModelStructure struct {
+	*pdbx.Model
+}
+
+// BowerFromCifModel provides a reference implementation of the
+// StructureBower interface for models in PDBx/mmCIF formatted files. Windows
+// that span a chain break are skipped. (Their number can be recorded with
+// RecordSkippedWindows.)
+func BowerFromCifModel(m *pdbx.Model) StructureBower {
+	return cifModelStructure{m}
+}
+
+func (m cifModelStructure) id() string {
+	return fmt.Sprintf("%s%c%d",
+		strings.ToLower(m.Chain.Entity.Entry.Id), m.Chain.Id, m.Num)
+}
+
+func (m cifModelStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
+	b, _ := m.skippedBow(lib)
+	return b
+}
+
+func (m cifModelStructure) skippedBow(
+	lib fragbag.StructureLibrary,
+) (Bowed, int) {
+	b, skipped := structureBow(lib, m.AlphaCarbons)
+	return Bowed{Id: m.id(), Bow: b}, skipped
+}
+
+type cifEnsembleStructure struct {
+	*pdbx.Chain
+	policy EnsemblePolicy
+}
+
+// BowerFromCifEnsemble provides a reference implementation of the
+// StructureBowers interface for all models of a chain in a PDBx/mmCIF
+// formatted file. The policy given determines whether a BOW is computed for
+// each model or whether the BOWs of all models are combined into one.
+func BowerFromCifEnsemble(
+	c *pdbx.Chain,
+	policy EnsemblePolicy,
+) StructureBowers {
+	return cifEnsembleStructure{c, policy}
+}
+
+func (c cifEnsembleStructure) StructureBows(
+	lib fragbag.StructureLibrary,
+) []Bowed {
+	bs, _ := c.skippedBows(lib)
+	return bs
+}
+
+func (c cifEnsembleStructure) skippedBows(
+	lib fragbag.StructureLibrary,
+) ([]Bowed, []int) {
+	bs, skipped := make([]Bowed, len(c.Models)), make([]int, len(c.Models))
+	for i, m := range c.Models {
+		bs[i], skipped[i] = cifModelStructure{m}.skippedBow(lib)
+	}
+	if c.policy == PerModel {
+		return bs, skipped
+	}
+	return []Bowed{{
+		Id:  cifChainStructure{c.Chain}.id(),
+		Bow: c.policy.combine(lib.Size(), bowsOf(bs)),
+	}}, []int{sum(skipped)}
+}
+
+func bowsOf(bs []Bowed) []Bow {
+	bows := make([]Bow, len(bs))
+	for i := range bs {
+		bows[i] = bs[i].Bow
+	}
+	return bows
+}
+
+func sum(xs []int) int {
+	total := 0
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
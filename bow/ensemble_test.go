@@ -0,0 +1,140 @@
+package bow
+
+import (
+	"math"
+	"testing"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/io/pdbx"
+)
+
+// ensembleModels are the fragments of the three models of an ensemble,
+// ensembleModelBows are their BOWs and ensembleBows are the BOWs expected for
+// each policy that combines them.
+var (
+	ensembleModels = [][]int{
+		{1, 1, 2, 0, 0},
+		{1, 2, 2, 0, 0},
+		{1, 2, 3, 0, 0},
+	}
+	ensembleModelBows = []Bow{
+		testBow(map[int]float32{1: 2, 2: 1}),
+		testBow(map[int]float32{1: 1, 2: 2}),
+		testBow(map[int]float32{1: 1, 2: 1, 3: 1}),
+	}
+	ensembleBows = map[EnsemblePolicy]Bow{
+		EnsembleMean: testBow(map[int]float32{1: 4.0 / 3, 2: 4.0 / 3, 3: 1.0 / 3}),
+		EnsembleMin:  testBow(map[int]float32{1: 1, 2: 1}),
+		EnsembleMax:  testBow(map[int]float32{1: 2, 2: 2, 3: 1}),
+	}
+)
+
+func TestCifEnsemble(t *testing.T) {
+	lib := testStructureLibrary{}
+	e := &pdbx.Entry{Id: "1ABC", Entities: make(map[byte]*pdbx.Entity)}
+	c := testCifChain(testCifEntity(e, 1), 'A', ensembleModels...)
+
+	models := BowerFromCifEnsemble(c, PerModel).StructureBows(lib)
+	if len(models) != len(ensembleModels) {
+		t.Fatalf("Expected %d models but got %d.",
+			len(ensembleModels), len(models))
+	}
+	for i, b := range models {
+		if id := "1abcA" + string('1'+byte(i)); b.Id != id {
+			t.Fatalf("Expected model ID '%s' but got '%s'.", id, b.Id)
+		}
+		if !b.Bow.Equal(ensembleModelBows[i]) {
+			t.Fatalf("Model %d: expected %s but got %s.",
+				i+1, ensembleModelBows[i], b.Bow)
+		}
+		single := BowerFromCifModel(c.Models[i]).StructureBow(lib)
+		if single.Id != b.Id || !single.Bow.Equal(b.Bow) {
+			t.Fatalf("Model %d differs from its ensemble BOW.", i+1)
+		}
+	}
+
+	for policy, want := range ensembleBows {
+		bs := BowerFromCifEnsemble(c, policy).StructureBows(lib)
+		if len(bs) != 1 || bs[0].Id != "1abcA" {
+			t.Fatalf("Policy '%s' returned %v.", policy, bs)
+		}
+		assertBowNear(t, policy.String(), bs[0].Bow, want)
+	}
+
+	// A chain's BOW is the BOW of its first model, as it is for PDB chains.
+	// Averaging is only done by an ensemble Bower.
+	chain := BowerFromCifChain(c).StructureBow(lib)
+	if chain.Id != "1abcA" || !chain.Bow.Equal(ensembleModelBows[0]) {
+		t.Fatalf("Expected the BOW %s of the first model but got %s.",
+			ensembleModelBows[0], chain.Bow)
+	}
+}
+
+func TestPdbEnsemble(t *testing.T) {
+	lib := testStructureLibrary{}
+	e := &pdb.Entry{IdCode: "1ABC"}
+	testPdbChain(e, 'A', "MKVLA", ensembleModels[0]...)
+	c := e.Chains[0]
+	for i, frags := range ensembleModels[1:] {
+		tmp := &pdb.Entry{IdCode: "1ABC"}
+		testPdbChain(tmp, 'A', "MKVLA", frags...)
+		m := tmp.Chains[0].Models[0]
+		m.Entry, m.Chain, m.Num = e, c, i+2
+		c.Models = append(c.Models, m)
+	}
+
+	models := BowerFromEnsemble(c, PerModel).StructureBows(lib)
+	if len(models) != len(ensembleModels) {
+		t.Fatalf("Expected %d models but got %d.",
+			len(ensembleModels), len(models))
+	}
+	for i, b := range models {
+		if id := "1abcA" + string('1'+byte(i)); b.Id != id {
+			t.Fatalf("Expected model ID '%s' but got '%s'.", id, b.Id)
+		}
+		if !b.Bow.Equal(ensembleModelBows[i]) {
+			t.Fatalf("Model %d: expected %s but got %s.",
+				i+1, ensembleModelBows[i], b.Bow)
+		}
+	}
+	for policy, want := range ensembleBows {
+		bs := BowerFromEnsemble(c, policy).StructureBows(lib)
+		if len(bs) != 1 || bs[0].Id != "1abcA" {
+			t.Fatalf("Policy '%s' returned %v.", policy, bs)
+		}
+		assertBowNear(t, policy.String(), bs[0].Bow, want)
+	}
+
+	// As for mmCIF chains, a chain's BOW is the BOW of its first model.
+	chain := BowerFromChain(c).StructureBow(lib)
+	if !chain.Bow.Equal(ensembleModelBows[0]) {
+		t.Fatalf("Expected the BOW %s of the first model but got %s.",
+			ensembleModelBows[0], chain.Bow)
+	}
+}
+
+func TestEnsemblePolicyNames(t *testing.T) {
+	all := []EnsemblePolicy{PerModel, EnsembleMean, EnsembleMin, EnsembleMax}
+	for _, p := range all {
+		if got, err := NewEnsemblePolicy(p.String()); err != nil || got != p {
+			t.Fatalf("Policy '%s' round tripped to %d (%v).", p, got, err)
+		}
+	}
+	if _, err := NewEnsemblePolicy("median"); err == nil {
+		t.Fatalf("Expected an error for an unknown policy.")
+	}
+}
+
+// assertBowNear fails the test if any frequency of got differs from the
+// frequency in want by more than a rounding error.
+func assertBowNear(t *testing.T, name string, got, want Bow) {
+	if got.Len() != want.Len() {
+		t.Fatalf("%s: expected a BOW of size %d but got %d.",
+			name, want.Len(), got.Len())
+	}
+	for i := range got.Freqs {
+		if math.Abs(float64(got.Freqs[i]-want.Freqs[i])) > 1e-6 {
+			t.Fatalf("%s: expected %s but got %s.", name, want, got)
+		}
+	}
+}
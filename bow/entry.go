@@ -0,0 +1,241 @@
+package bow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/io/pdbx"
+	"github.com/TuftsBCB/seq"
+	"github.com/yunwilliamyu/esfragbag"
+)
+
+// EntryPolicy determines how BOWs are computed for an entry with more than
+// one chain. The policy also determines the identifiers of the Bowed values
+// produced.
+type EntryPolicy int
+
+const (
+	// PerChain computes one BOW for every chain in an entry. Each Bowed has
+	// an identifier made of the entry's identifier and the chain's
+	// identifier. e.g., "1ctfA".
+	PerChain EntryPolicy = iota
+
+	// PerAssembly computes a single BOW for the entire entry by summing
+	// the BOWs of every chain. The Bowed has the entry's identifier.
+	// e.g., "1ctf".
+	PerAssembly
+
+	// PerEntity computes one BOW for every unique entity in an entry, so
+	// that identical chains (like those in a homo-oligomer) are only counted
+	// once. Each Bowed has the identifier of the first chain of its entity.
+	// e.g., "1ctfA".
+	//
+	// For PDB entries, which do not record entities, chains with identical
+	// sequences are considered to belong to the same entity.
+	PerEntity
+)
+
+func (p EntryPolicy) String() string {
+	switch p {
+	case PerChain:
+		return "chain"
+	case PerAssembly:
+		return "assembly"
+	case PerEntity:
+		return "entity"
+	}
+	panic(fmt.Sprintf("Unrecognized entry policy: %d", p))
+}
+
+// NewEntryPolicy returns the entry policy corresponding to the name given,
+// which should be one of "chain", "assembly" or "entity".
+func NewEntryPolicy(name string) (EntryPolicy, error) {
+	for _, p := range []EntryPolicy{PerChain, PerAssembly, PerEntity} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("Unrecognized entry policy '%s'. Valid policies "+
+		"are 'chain', 'assembly' and 'entity'.", name)
+}
+
+// StructureBowers corresponds to values that can provide one or more BOWs
+// given a structure fragment library. e.g., An entry with many chains.
+type StructureBowers interface {
+	// Computes bags-of-words given a structure fragment library.
+	// The number of bags-of-words returned and their identifiers are
+	// determined by the implementation.
+	StructureBows(lib fragbag.StructureLibrary) []Bowed
+}
+
+type pdbEntryStructure struct {
+	*pdb.Entry
+	policy EntryPolicy
+}
+
+// BowerFromEntry provides a reference implementation of the StructureBowers
+// interface for PDB entries. The policy given determines whether BOWs are
+// computed per chain, per entity or for the entire entry. Chain breaks are
+// handled as in BowerFromChain. (The number of windows skipped for the entire
+// entry is summed over its chains.)
+func BowerFromEntry(e *pdb.Entry, policy EntryPolicy) StructureBowers {
+	return pdbEntryStructure{e, policy}
+}
+
+func (e pdbEntryStructure) chainId(c *pdb.Chain) string {
+	return fmt.Sprintf("%s%c", strings.ToLower(e.IdCode), c.Ident)
+}
+
+func (e pdbEntryStructure) StructureBows(
+	lib fragbag.StructureLibrary,
+) []Bowed {
+	bs, _ := e.skippedBows(lib)
+	return bs
+}
+
+func (e pdbEntryStructure) skippedBows(
+	lib fragbag.StructureLibrary,
+) ([]Bowed, []int) {
+	switch e.policy {
+	case PerChain:
+		bs, skipped := make([]Bowed, len(e.Chains)), make([]int, len(e.Chains))
+		for i, c := range e.Chains {
+			var b Bow
+			b, skipped[i] = chainBow(lib, c)
+			bs[i] = Bowed{Id: e.chainId(c), Bow: b}
+		}
+		return bs, skipped
+	case PerAssembly:
+		sum, skipped := NewBow(lib.Size()), 0
+		for _, c := range e.Chains {
+			b, n := chainBow(lib, c)
+			sum, skipped = sum.Add(b), skipped+n
+		}
+		return []Bowed{{
+			Id:  strings.ToLower(e.IdCode),
+			Bow: sum,
+		}}, []int{skipped}
+	case PerEntity:
+		bs := make([]Bowed, 0, len(e.Chains))
+		skipped := make([]int, 0, len(e.Chains))
+		seen := make(map[string]bool, len(e.Chains))
+		for _, c := range e.Chains {
+			// Chains without a sequence cannot be compared, so they are
+			// always kept.
+			if len(c.Sequence) > 0 {
+				key := residuesKey(c.Sequence)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			b, n := chainBow(lib, c)
+			bs = append(bs, Bowed{Id: e.chainId(c), Bow: b})
+			skipped = append(skipped, n)
+		}
+		return bs, skipped
+	}
+	panic(fmt.Sprintf("Unrecognized entry policy: %d", e.policy))
+}
+
+func residuesKey(rs []seq.Residue) string {
+	key := make([]byte, len(rs))
+	for i, r := range rs {
+		key[i] = byte(r)
+	}
+	return string(key)
+}
+
+type cifEntryStructure struct {
+	*pdbx.Entry
+	policy EntryPolicy
+}
+
+// BowerFromCifEntry provides a reference implementation of the
+// StructureBowers interface for entries in PDBx/mmCIF formatted files.
+// The policy given determines whether BOWs are computed per chain, per
+// entity or for the entire entry. Chains are handled as in BowerFromCifChain.
+func BowerFromCifEntry(e *pdbx.Entry, policy EntryPolicy) StructureBowers {
+	return cifEntryStructure{e, policy}
+}
+
+// entities returns the chains of every entity in the entry. Both entities
+// and chains are sorted by their identifiers so that the output is
+// deterministic. Chains without any models have no coordinates and are
+// omitted.
+func (e cifEntryStructure) entities() [][]*pdbx.Chain {
+	entIds := make([]int, 0, len(e.Entities))
+	for id := range e.Entities {
+		entIds = append(entIds, int(id))
+	}
+	sort.Ints(entIds)
+
+	entities := make([][]*pdbx.Chain, 0, len(entIds))
+	for _, entId := range entIds {
+		ent := e.Entities[byte(entId)]
+		chainIds := make([]int, 0, len(ent.Chains))
+		for id := range ent.Chains {
+			chainIds = append(chainIds, int(id))
+		}
+		sort.Ints(chainIds)
+
+		chains := make([]*pdbx.Chain, 0, len(chainIds))
+		for _, chainId := range chainIds {
+			if c := ent.Chains[byte(chainId)]; len(c.Models) > 0 {
+				chains = append(chains, c)
+			}
+		}
+		entities = append(entities, chains)
+	}
+	return entities
+}
+
+func (e cifEntryStructure) StructureBows(
+	lib fragbag.StructureLibrary,
+) []Bowed {
+	bs, _ := e.skippedBows(lib)
+	return bs
+}
+
+func (e cifEntryStructure) skippedBows(
+	lib fragbag.StructureLibrary,
+) ([]Bowed, []int) {
+	entities := e.entities()
+	var bs []Bowed
+	var skipped []int
+	add := func(c *pdbx.Chain) {
+		b, n := cifChainStructure{c}.skippedBow(lib)
+		bs, skipped = append(bs, b), append(skipped, n)
+	}
+	switch e.policy {
+	case PerChain:
+		for _, chains := range entities {
+			for _, c := range chains {
+				add(c)
+			}
+		}
+		return bs, skipped
+	case PerAssembly:
+		sum, total := NewBow(lib.Size()), 0
+		for _, chains := range entities {
+			for _, c := range chains {
+				b, n := cifChainBow(lib, c)
+				sum, total = sum.Add(b), total+n
+			}
+		}
+		return []Bowed{{
+			Id:  strings.ToLower(e.Id),
+			Bow: sum,
+		}}, []int{total}
+	case PerEntity:
+		for _, chains := range entities {
+			if len(chains) > 0 {
+				add(chains[0])
+			}
+		}
+		return bs, skipped
+	}
+	panic(fmt.Sprintf("Unrecognized entry policy: %d", e.policy))
+}
@@ -0,0 +1,183 @@
+package bow
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/io/pdbx"
+	"github.com/TuftsBCB/seq"
+	"github.com/TuftsBCB/structure"
+	"github.com/yunwilliamyu/esfragbag"
+)
+
+// testStructureLibrary is a structure library with fragments of 3 residues,
+// whose best fragment for a window is read from the Z coordinate of the
+// window's first atom. (See caTrace.)
+type testStructureLibrary struct{}
+
+const testLibSize = 10
+
+func (lib testStructureLibrary) Name() string                   { return "test" }
+func (lib testStructureLibrary) Size() int                      { return testLibSize }
+func (lib testStructureLibrary) FragmentSize() int              { return 3 }
+func (lib testStructureLibrary) Tag() string                    { return "test" }
+func (lib testStructureLibrary) SubLibrary() fragbag.Library    { return nil }
+func (lib testStructureLibrary) String() string                 { return "test" }
+func (lib testStructureLibrary) FragmentString(i int) string    { return "" }
+func (lib testStructureLibrary) Fragment(i int) interface{}     { return nil }
+func (lib testStructureLibrary) Atoms(i int) []structure.Coords { return nil }
+
+func (lib testStructureLibrary) BestStructureFragment(
+	atoms []structure.Coords,
+) int {
+	if len(atoms) != lib.FragmentSize() {
+		panic(fmt.Sprintf("Got a window of %d atoms.", len(atoms)))
+	}
+	frag := int(atoms[0].Z*1000 + 0.5)
+	if frag >= testLibSize {
+		return -1
+	}
+	return frag
+}
+
+// caTrace returns alpha-carbons along a straight line, 3.8 Angstroms apart,
+// such that the best fragment of the window starting at atom i is frags[i].
+// The fragment is encoded as a small offset in the Z coordinate, which
+// doesn't change the distance between atoms noticeably.
+func caTrace(frags ...int) []structure.Coords {
+	atoms := make([]structure.Coords, len(frags))
+	for i, frag := range frags {
+		atoms[i] = structure.Coords{X: 3.8 * float64(i), Z: float64(frag) / 1000}
+	}
+	return atoms
+}
+
+// testBow returns a BOW of the test library with the frequencies given.
+func testBow(freqs map[int]float32) Bow {
+	return newBowMap(testLibSize, freqs)
+}
+
+// testPdbChain adds a chain with a single model to a PDB entry. The residues
+// are numbered from 1.
+func testPdbChain(e *pdb.Entry, ident byte, sequence string, frags ...int) {
+	c := &pdb.Chain{Entry: e, Ident: ident}
+	for _, r := range sequence {
+		c.Sequence = append(c.Sequence, seq.Residue(r))
+	}
+	m := &pdb.Model{Entry: e, Chain: c, Num: 1}
+	for i, atom := range caTrace(frags...) {
+		m.Residues = append(m.Residues, &pdb.Residue{
+			SequenceNum: i + 1,
+			Atoms:       []pdb.Atom{{Name: "CA", Coords: atom}},
+		})
+	}
+	c.Models = []*pdb.Model{m}
+	e.Chains = append(e.Chains, c)
+}
+
+// testCifChain adds a chain to an entity of a PDBx/mmCIF entry, with a model
+// for every list of fragments given.
+func testCifChain(ent *pdbx.Entity, id byte, models ...[]int) *pdbx.Chain {
+	c := &pdbx.Chain{Entry: ent.Entry, Entity: ent, Id: id}
+	for i, frags := range models {
+		c.Models = append(c.Models, &pdbx.Model{
+			Entry:        ent.Entry,
+			Chain:        c,
+			Num:          i + 1,
+			AlphaCarbons: caTrace(frags...),
+		})
+	}
+	ent.Chains[id] = c
+	return c
+}
+
+func testCifEntity(e *pdbx.Entry, id byte) *pdbx.Entity {
+	ent := &pdbx.Entity{Entry: e, Id: id, Chains: make(map[byte]*pdbx.Chain)}
+	e.Entities[id] = ent
+	return ent
+}
+
+func TestEntryPolicies(t *testing.T) {
+	lib := testStructureLibrary{}
+
+	// Chains A and B are copies of the same entity.
+	e := &pdb.Entry{IdCode: "1ABC"}
+	testPdbChain(e, 'A', "MKV", 1, 2, 0, 0)
+	testPdbChain(e, 'B', "MKV", 1, 2, 0, 0)
+	testPdbChain(e, 'C', "GGG", 3, 3, 3, 0, 0)
+
+	chainA := testBow(map[int]float32{1: 1, 2: 1})
+	chainC := testBow(map[int]float32{3: 3})
+	tests := []struct {
+		policy EntryPolicy
+		want   []Bowed
+	}{
+		{PerChain, []Bowed{
+			{Id: "1abcA", Bow: chainA},
+			{Id: "1abcB", Bow: chainA},
+			{Id: "1abcC", Bow: chainC},
+		}},
+		{PerAssembly, []Bowed{
+			{Id: "1abc", Bow: testBow(map[int]float32{1: 2, 2: 2, 3: 3})},
+		}},
+		{PerEntity, []Bowed{
+			{Id: "1abcA", Bow: chainA},
+			{Id: "1abcC", Bow: chainC},
+		}},
+	}
+	for _, test := range tests {
+		got := BowerFromEntry(e, test.policy).StructureBows(lib)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("PDB entry with policy '%s': expected\n%v\nbut got\n%v",
+				test.policy, test.want, got)
+		}
+	}
+}
+
+func TestCifEntryPolicies(t *testing.T) {
+	lib := testStructureLibrary{}
+
+	// Chains A and B belong to entity 1, and C to entity 2. Chain D of
+	// entity 2 has no coordinates, and neither does entity 3.
+	e := &pdbx.Entry{Id: "1ABC", Entities: make(map[byte]*pdbx.Entity)}
+	ent1, ent2 := testCifEntity(e, 1), testCifEntity(e, 2)
+	testCifChain(ent1, 'B', []int{1, 2, 0, 0})
+	testCifChain(ent1, 'A', []int{1, 2, 0, 0})
+	testCifChain(ent2, 'D')
+	testCifChain(ent2, 'C', []int{3, 3, 3, 0, 0})
+	testCifChain(testCifEntity(e, 3), 'E')
+
+	chainA := testBow(map[int]float32{1: 1, 2: 1})
+	chainC := testBow(map[int]float32{3: 3})
+	tests := []struct {
+		policy EntryPolicy
+		want   []Bowed
+	}{
+		{PerChain, []Bowed{
+			{Id: "1abcA", Bow: chainA},
+			{Id: "1abcB", Bow: chainA},
+			{Id: "1abcC", Bow: chainC},
+		}},
+		{PerAssembly, []Bowed{
+			{Id: "1abc", Bow: testBow(map[int]float32{1: 2, 2: 2, 3: 3})},
+		}},
+		{PerEntity, []Bowed{
+			{Id: "1abcA", Bow: chainA},
+			{Id: "1abcC", Bow: chainC},
+		}},
+	}
+	for _, test := range tests {
+		got := BowerFromCifEntry(e, test.policy).StructureBows(lib)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("mmCIF entry with policy '%s': expected\n%v\nbut got\n%v",
+				test.policy, test.want, got)
+		}
+	}
+
+	empty := BowerFromCifChain(ent2.Chains['D']).StructureBow(lib)
+	if !empty.Bow.Equal(NewBow(testLibSize)) {
+		t.Fatalf("Chain without models has BOW %s.", empty.Bow)
+	}
+}
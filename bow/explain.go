@@ -0,0 +1,161 @@
+package bow
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/yunwilliamyu/esfragbag"
+)
+
+// Contribution describes how much a single fragment contributes to the
+// distances between two BOWs.
+type Contribution struct {
+	// The fragment number.
+	Fragment int
+
+	// The frequency of the fragment in the query and target BOWs.
+	Query, Target float32
+
+	// Cosine is the fragment's share of the cosine similarity between the
+	// two BOWs. i.e., q_i * t_i / (|q| |t|). The sum of Cosine over every
+	// fragment is equal to 1 minus the cosine distance.
+	Cosine float64
+
+	// Euclid is the fragment's share of the squared Euclidean distance
+	// between the two BOWs. i.e., (q_i - t_i)^2. The square root of the sum
+	// of Euclid over every fragment is equal to the Euclidean distance.
+	Euclid float64
+}
+
+// Explanation breaks down the distances between a query and a target BOW
+// into per-fragment contributions.
+type Explanation struct {
+	Query, Target Bowed
+
+	// The distances between the query and the target.
+	Cosine, Euclid float64
+
+	// A contribution for every fragment that occurs in either the query or
+	// the target, in order of fragment number.
+	Fragments []Contribution
+}
+
+// Explain computes the per-fragment contributions to the cosine and
+// Euclidean distances between the query and target given.
+//
+// Explain will panic if the two BOWs have differing lengths.
+func Explain(query, target Bowed) Explanation {
+	q, t := query.Bow, target.Bow
+	if q.Len() != t.Len() {
+		panic("Cannot explain the distance between Bows with differing " +
+			"lengths")
+	}
+
+	mags := q.Magnitude() * t.Magnitude()
+	ex := Explanation{
+		Query:  query,
+		Target: target,
+		Cosine: q.Cosine(t),
+		Euclid: q.Euclid(t),
+	}
+	for i := 0; i < q.Len(); i++ {
+		qf, tf := q.Freqs[i], t.Freqs[i]
+		if qf == 0 && tf == 0 {
+			continue
+		}
+		c := Contribution{
+			Fragment: i,
+			Query:    qf,
+			Target:   tf,
+			Euclid:   float64((qf - tf) * (qf - tf)),
+		}
+		if mags > 0 {
+			c.Cosine = float64(qf*tf) / mags
+		}
+		ex.Fragments = append(ex.Fragments, c)
+	}
+	return ex
+}
+
+// Similar returns at most n fragments sorted by decreasing contribution to
+// the cosine similarity. Fragments that contribute nothing are omitted.
+// If n is negative, all such fragments are returned.
+func (ex Explanation) Similar(n int) []Contribution {
+	return ex.top(n, func(c Contribution) float64 { return c.Cosine })
+}
+
+// Different returns at most n fragments sorted by decreasing contribution to
+// the Euclidean distance. Fragments that contribute nothing are omitted.
+// If n is negative, all such fragments are returned.
+func (ex Explanation) Different(n int) []Contribution {
+	return ex.top(n, func(c Contribution) float64 { return c.Euclid })
+}
+
+func (ex Explanation) top(n int, key func(c Contribution) float64) []Contribution {
+	cs := make([]Contribution, 0, len(ex.Fragments))
+	for _, c := range ex.Fragments {
+		if key(c) > 0 {
+			cs = append(cs, c)
+		}
+	}
+	sort.SliceStable(cs, func(i, j int) bool {
+		return key(cs[i]) > key(cs[j])
+	})
+	if n >= 0 && n < len(cs) {
+		cs = cs[:n]
+	}
+	return cs
+}
+
+// Report writes a human readable report of the n fragments that contribute
+// most to the similarity between the query and the target, followed by the n
+// fragments that contribute most to their difference.
+//
+// If lib is not nil, the representation of each fragment given by
+// FragmentString is written below the fragment.
+func (ex Explanation) Report(w io.Writer, lib fragbag.Library, n int) error {
+	pf := func(format string, v ...interface{}) error {
+		_, err := fmt.Fprintf(w, format, v...)
+		return err
+	}
+	sqEuclid := ex.Euclid * ex.Euclid
+	section := func(title string, cs []Contribution) error {
+		if err := pf("%s\n", title); err != nil {
+			return err
+		}
+		if err := pf("%8s %10s %10s %10s %10s\n",
+			"fragment", "query", "target", "cosine", "euclid"); err != nil {
+			return err
+		}
+		for _, c := range cs {
+			euclidShare := 0.0
+			if sqEuclid > 0 {
+				euclidShare = c.Euclid / sqEuclid
+			}
+			err := pf("%8d %10.4f %10.4f %9.2f%% %9.2f%%\n",
+				c.Fragment, c.Query, c.Target,
+				100*c.Cosine/math.Max(1-ex.Cosine, 1e-12), 100*euclidShare)
+			if err != nil {
+				return err
+			}
+			if lib != nil {
+				if err := pf("%s\n", lib.FragmentString(c.Fragment)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err := pf("Query: %s, Target: %s, Cosine: %0.4f, Euclid: %0.4f\n",
+		ex.Query.Id, ex.Target.Id, ex.Cosine, ex.Euclid)
+	if err != nil {
+		return err
+	}
+	if err := section("Fragments driving similarity:", ex.Similar(n)); err != nil {
+		return err
+	}
+	return section("Fragments driving difference:", ex.Different(n))
+}
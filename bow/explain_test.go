@@ -0,0 +1,37 @@
+package bow
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	query := Bowed{Id: "q", Bow: newBowMap(5, map[int]float32{0: 3, 1: 1, 3: 2})}
+	target := Bowed{Id: "t", Bow: newBowMap(5, map[int]float32{0: 2, 2: 4, 3: 2})}
+	ex := Explain(query, target)
+
+	if len(ex.Fragments) != 4 {
+		t.Fatalf("Expected 4 contributing fragments but got %d.",
+			len(ex.Fragments))
+	}
+	var cos, euclid float64
+	for _, c := range ex.Fragments {
+		cos += c.Cosine
+		euclid += c.Euclid
+	}
+	if math.Abs((1-cos)-ex.Cosine) > 1e-6 {
+		t.Fatalf("Cosine contributions sum to %f, but the cosine distance "+
+			"is %f.", 1-cos, ex.Cosine)
+	}
+	if math.Abs(math.Sqrt(euclid)-ex.Euclid) > 1e-6 {
+		t.Fatalf("Euclid contributions sum to %f, but the Euclidean "+
+			"distance is %f.", math.Sqrt(euclid), ex.Euclid)
+	}
+
+	if sim := ex.Similar(1); len(sim) != 1 || sim[0].Fragment != 0 {
+		t.Fatalf("Expected fragment 0 to drive similarity but got %v.", sim)
+	}
+	if diff := ex.Different(1); len(diff) != 1 || diff[0].Fragment != 2 {
+		t.Fatalf("Expected fragment 2 to drive difference but got %v.", diff)
+	}
+}
@@ -0,0 +1,64 @@
+package bow
+
+import (
+	"github.com/TuftsBCB/seq"
+	"github.com/TuftsBCB/structure"
+	"github.com/yunwilliamyu/esfragbag"
+)
+
+// Fragments is an ordered list of fragment numbers computed by sliding a
+// window the size of a fragment along a chain or a sequence. The i'th
+// element corresponds to the window starting at the i'th residue. Windows
+// for which no good fragment could be found are recorded as `-1`.
+//
+// Unlike a Bow, a Fragments value preserves the order in which fragments
+// appear, which makes it suitable for alignment. (See Align.)
+type Fragments []int
+
+// StructureFragments computes the ordered list of best matching fragments
+// given a structure fragment library and a list of alpha-carbon atoms.
+//
+// Windows that span a chain break (see ChainBreaks and MaxCaDistance) are
+// recorded as `-1`.
+func StructureFragments(
+	lib fragbag.StructureLibrary,
+	atoms []structure.Coords,
+) Fragments {
+	breaks := ChainBreaks(atoms, nil, MaxCaDistance)
+	frags, _ := StructureFragmentsBreaks(lib, atoms, breaks)
+	return frags
+}
+
+// SequenceFragments computes the ordered list of best matching fragments
+// given a sequence fragment library and a query sequence.
+func SequenceFragments(lib fragbag.SequenceLibrary, s seq.Sequence) Fragments {
+	libSize := lib.FragmentSize()
+	uplimit := s.Len() - libSize
+	if uplimit < 0 {
+		return Fragments{}
+	}
+
+	frags := make(Fragments, uplimit+1)
+	for i := 0; i <= uplimit; i++ {
+		frags[i] = lib.BestSequenceFragment(s.Slice(i, i+libSize))
+	}
+	return frags
+}
+
+// Bow counts the fragments in the list and returns them as a bag-of-words.
+// Windows without a fragment (`-1`) are ignored.
+//
+// If the lib given is a weighted library, then the Bow returned will also
+// be weighted.
+func (fs Fragments) Bow(lib fragbag.Library) Bow {
+	b := NewBow(lib.Size())
+	for _, best := range fs {
+		if best > -1 {
+			b.Freqs[best] += 1
+		}
+	}
+	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
+		b = b.Weighted(wlib)
+	}
+	return b
+}
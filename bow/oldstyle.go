@@ -1,8 +1,10 @@
 package bow
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 )
@@ -172,3 +174,102 @@ func NewOldStyleBow(size int, oldschool string) (Bow, error) {
 	}
 	return bow, nil
 }
+
+// OldStyleError corresponds to a malformed line in a file of old-style
+// bag-of-words vectors. Reading may continue after an OldStyleError.
+type OldStyleError struct {
+	Line int
+	Err  error
+}
+
+func (err *OldStyleError) Error() string {
+	return fmt.Sprintf("Line %d: %s", err.Line, err.Err)
+}
+
+// oldStyleMaxLine is the length in bytes of the longest line that an
+// OldStyleReader will read.
+const oldStyleMaxLine = 1 << 26
+
+// OldStyleReader reads Bowed values from the output of the original Fragbag
+// program, where every line contains an identifier followed by whitespace
+// and an old-style bag-of-words vector. (See NewOldStyleBow.) A line with
+// only an identifier corresponds to an empty bag-of-words. Empty lines are
+// skipped.
+type OldStyleReader struct {
+	r       *bufio.Reader
+	size    int
+	lineno  int
+	line    []byte
+	maxLine int
+}
+
+// NewOldStyleReader returns a reader that reads from r. The size given is the
+// number of fragments in the library used to compute the BOWs.
+func NewOldStyleReader(r io.Reader, size int) *OldStyleReader {
+	return &OldStyleReader{
+		r:       bufio.NewReaderSize(r, 1<<16),
+		size:    size,
+		maxLine: oldStyleMaxLine,
+	}
+}
+
+// Read returns the next Bowed value. When there are no more values, io.EOF is
+// returned.
+//
+// If a line is malformed or is longer than 64 MiB, an error with type
+// *OldStyleError is returned. Subsequent calls to Read will continue with the
+// next line.
+func (r *OldStyleReader) Read() (Bowed, error) {
+	for {
+		line, tooLong, err := r.readLine()
+		if err != nil {
+			return Bowed{}, err
+		}
+		r.lineno++
+		if tooLong {
+			return Bowed{}, &OldStyleError{r.lineno, fmt.Errorf(
+				"The line is longer than %d bytes.", r.maxLine)}
+		}
+
+		fields := strings.Fields(string(line))
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			return Bowed{Id: fields[0], Bow: NewBow(r.size)}, nil
+		case 2:
+			b, err := NewOldStyleBow(r.size, fields[1])
+			if err != nil {
+				return Bowed{}, &OldStyleError{r.lineno, err}
+			}
+			return Bowed{Id: fields[0], Bow: b}, nil
+		default:
+			return Bowed{}, &OldStyleError{r.lineno, fmt.Errorf(
+				"Expected an identifier and a BOW but found %d fields.",
+				len(fields))}
+		}
+	}
+}
+
+// readLine returns the next line without its line ending. If the line is
+// longer than maxLine bytes, the rest of it is discarded and tooLong is true.
+// io.EOF is returned when there are no more lines.
+func (r *OldStyleReader) readLine() (line []byte, tooLong bool, err error) {
+	r.line = r.line[:0]
+	for {
+		part, isPrefix, err := r.r.ReadLine()
+		if err != nil {
+			return nil, false, err
+		}
+		if !tooLong {
+			if len(r.line)+len(part) > r.maxLine {
+				r.line, tooLong = r.line[:0], true
+			} else {
+				r.line = append(r.line, part...)
+			}
+		}
+		if !isPrefix {
+			return r.line, tooLong, nil
+		}
+	}
+}
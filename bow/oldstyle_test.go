@@ -2,11 +2,14 @@ package bow
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
-	"github.com/TuftsBCB/fragbag"
+	"github.com/yunwilliamyu/esfragbag"
 )
 
 var (
@@ -89,3 +92,113 @@ func TestOldStyleWrite(t *testing.T) {
 		}
 	}
 }
+
+func TestOldStyleReader(t *testing.T) {
+	// A read is either a Bowed or an error on a line.
+	type read struct {
+		id      string
+		freqs   map[int]float32
+		errLine int
+	}
+	bowed := func(id string, freqs map[int]float32) read {
+		if freqs == nil {
+			freqs = map[int]float32{}
+		}
+		return read{id: id, freqs: freqs}
+	}
+	malformed := func(line int) read {
+		return read{errLine: line}
+	}
+
+	// Lines are longer than the buffer of the underlying reader. The longest
+	// line allowed is set to the length of fits.
+	maxLine := 1 << 17
+	fits := "1abcF " + strings.Repeat("a", maxLine-6)
+	long := fits + "a"
+	tests := []struct {
+		name  string
+		input string
+		want  []read
+	}{
+		{"well formed", "1abcA aab#52#\n1abcB\tZ\n", []read{
+			bowed("1abcA", map[int]float32{0: 2, 1: 1, 52: 1}),
+			bowed("1abcB", map[int]float32{51: 1}),
+		}},
+		{"bad characters", "1abcA aa!a\n1abcB b\n", []read{
+			malformed(1),
+			bowed("1abcB", map[int]float32{1: 1}),
+		}},
+		{"out of range", "1abcA #60#\n1abcB #51#\n1abcC #59#\n", []read{
+			malformed(1),
+			malformed(2),
+			bowed("1abcC", map[int]float32{59: 1}),
+		}},
+		{"too many fields", "1abcA a b\n1abcB a b c\n1abcC c\n", []read{
+			malformed(1),
+			malformed(2),
+			bowed("1abcC", map[int]float32{2: 1}),
+		}},
+		{"identifier only", "1abcA\n  1abcB  \n", []read{
+			bowed("1abcA", nil),
+			bowed("1abcB", nil),
+		}},
+		{"blank lines", "\n \t \n1abcA a\n\n\n1abcB b", []read{
+			bowed("1abcA", map[int]float32{0: 1}),
+			bowed("1abcB", map[int]float32{1: 1}),
+		}},
+		{"line after skipped lines", "\n\n1abcA a b\n\n1abcB !\n", []read{
+			malformed(3),
+			malformed(5),
+		}},
+		{"continue after errors", "1abcA !\n1abcB a\n1abcC a b\n1abcD b\n",
+			[]read{
+				malformed(1),
+				bowed("1abcB", map[int]float32{0: 1}),
+				malformed(3),
+				bowed("1abcD", map[int]float32{1: 1}),
+			}},
+		{"line endings", "1abcA a\r\n1abcB b\r\n", []read{
+			bowed("1abcA", map[int]float32{0: 1}),
+			bowed("1abcB", map[int]float32{1: 1}),
+		}},
+		{"long lines", long + "\n1abcA a\n" + fits + "\n" + long, []read{
+			malformed(1),
+			bowed("1abcA", map[int]float32{0: 1}),
+			bowed("1abcF", map[int]float32{0: float32(maxLine - 6)}),
+			malformed(4),
+		}},
+		{"empty", "", nil},
+	}
+	for _, test := range tests {
+		r := NewOldStyleReader(strings.NewReader(test.input), 60)
+		r.maxLine = maxLine
+
+		var got []read
+		for {
+			b, err := r.Read()
+			if err == io.EOF {
+				break
+			} else if oerr, ok := err.(*OldStyleError); ok {
+				got = append(got, malformed(oerr.Line))
+				continue
+			} else if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			freqs := make(map[int]float32)
+			for i, f := range b.Bow.Freqs {
+				if f != 0 {
+					freqs[i] = f
+				}
+			}
+			if len(b.Bow.Freqs) != 60 {
+				t.Fatalf("%s: expected a BOW with 60 fragments but got %d.",
+					test.name, len(b.Bow.Freqs))
+			}
+			got = append(got, read{id: b.Id, freqs: freqs})
+		}
+		if !reflect.DeepEqual(test.want, got) {
+			t.Fatalf("%s: expected\n%v\nbut got\n%v",
+				test.name, test.want, got)
+		}
+	}
+}
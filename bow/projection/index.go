@@ -0,0 +1,145 @@
+package projection
+
+import (
+	"container/heap"
+	"fmt"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+	"github.com/yunwilliamyu/esfragbag/bowdb"
+)
+
+// Index holds the projection of every entry in a BOW database, and uses them
+// to prefilter candidates for an exact search.
+type Index struct {
+	// The projection applied to every entry.
+	Proj *Projection
+
+	// The entries of the database, in the same order as they were read. For
+	// a database with a quantized encoding, Entries is nil and the entries
+	// are kept quantized in QuantizedEntries instead.
+	Entries          []bow.Bowed
+	QuantizedEntries []bow.QuantizedBowed
+
+	vectors []float32 // Projected entries, Dims() values per entry.
+}
+
+// NewIndex projects every entry in the database given. The projection must
+// have the same size as the database's fragment library.
+//
+// All entries in the database are read into memory if they haven't been
+// already. Entries of a database with a quantized encoding are kept
+// quantized, and are only expanded one at a time to be projected.
+func NewIndex(p *Projection, db *bowdb.DB) (*Index, error) {
+	if p.Size != db.Lib.Size() {
+		return nil, fmt.Errorf("Projection has size %d but the fragment "+
+			"library '%s' has size %d.", p.Size, db.Lib.Name(), db.Lib.Size())
+	}
+
+	k := p.Dims()
+	idx := &Index{Proj: p}
+	if db.Encoding == bow.EncodingFloat32 {
+		if db.Entries == nil {
+			if _, err := db.ReadAll(); err != nil {
+				return nil, err
+			}
+		}
+		idx.Entries = db.Entries
+		idx.vectors = make([]float32, k*len(idx.Entries))
+		for i, e := range idx.Entries {
+			p.ApplyInto(idx.vectors[i*k:(i+1)*k], e.Bow)
+		}
+		return idx, nil
+	}
+
+	if db.QuantizedEntries == nil {
+		if _, err := db.ReadAllQuantized(); err != nil {
+			return nil, err
+		}
+	}
+	idx.QuantizedEntries = db.QuantizedEntries
+	idx.vectors = make([]float32, k*len(idx.QuantizedEntries))
+	b := bow.NewBow(p.Size)
+	for i, q := range idx.QuantizedEntries {
+		q.Bow.BowInto(b)
+		p.ApplyInto(idx.vectors[i*k:(i+1)*k], b)
+	}
+	return idx, nil
+}
+
+// Len returns the number of entries in the index.
+func (idx *Index) Len() int {
+	if idx.QuantizedEntries != nil {
+		return len(idx.QuantizedEntries)
+	}
+	return len(idx.Entries)
+}
+
+// entry returns the i'th entry, expanding it if it is quantized.
+func (idx *Index) entry(i int) bow.Bowed {
+	if idx.QuantizedEntries != nil {
+		return idx.QuantizedEntries[i].Bowed()
+	}
+	return idx.Entries[i]
+}
+
+// Search returns the results of an exact search (see bowdb.SearchEntries)
+// over the given number of candidates whose projected vectors are closest to
+// the projected query.
+//
+// The results are approximate: a true hit is missed when its projection is
+// not among the closest candidates. Increasing the number of candidates
+// trades speed for recall. Since candidates are always the closest entries
+// in the projected space, only searches with ascending order are
+// approximated well.
+func (idx *Index) Search(
+	opts bowdb.SearchOptions,
+	query bow.Bowed,
+	candidates int,
+) []bowdb.SearchResult {
+	k := idx.Proj.Dims()
+	qv := idx.Proj.Apply(query.Bow)
+
+	h := &candidateHeap{}
+	for i := 0; i < idx.Len(); i++ {
+		v := idx.vectors[i*k : (i+1)*k]
+		var dist float32
+		for j := range v {
+			d := v[j] - qv[j]
+			dist += d * d
+		}
+		if h.Len() < candidates {
+			heap.Push(h, candidate{i, dist})
+		} else if h.Len() > 0 && dist < (*h)[0].dist {
+			(*h)[0] = candidate{i, dist}
+			heap.Fix(h, 0)
+		}
+	}
+
+	cands := make([]bow.Bowed, h.Len())
+	for i, c := range *h {
+		cands[i] = idx.entry(c.index)
+	}
+	return bowdb.SearchEntries(opts, query, cands)
+}
+
+// candidate is an entry index with its distance in the projected space.
+type candidate struct {
+	index int
+	dist  float32
+}
+
+// candidateHeap is a max-heap of candidates by distance, so that the worst
+// candidate is always at the top.
+type candidateHeap []candidate
+
+func (h candidateHeap) Len() int            { return len(h) }
+func (h candidateHeap) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h candidateHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *candidateHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+
+func (h *candidateHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,97 @@
+package projection
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+	"github.com/yunwilliamyu/esfragbag/bowdb"
+	"github.com/yunwilliamyu/esfragbag/internal/fragtest"
+)
+
+// testDB creates a BOW database with the encoding given in a temporary
+// directory, which is removed with the returned function. It skips the test
+// if FRAGLIB_PATH isn't set.
+func testDB(
+	t *testing.T,
+	enc bow.Encoding,
+	entries []bow.Bowed,
+) (*bowdb.DB, func()) {
+	lib := fragtest.Library(t)
+	dir, err := ioutil.TempDir("", "projection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(dir, "test.bowdb")
+	db, err := bowdb.CreateEncoded(lib, dbPath, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		db.Add(e)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = bowdb.Open(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestIndexQuantized(t *testing.T) {
+	const size = 400
+	rng := rand.New(rand.NewSource(3))
+	entries := make([]bow.Bowed, 300)
+	for i := range entries {
+		b := bow.NewBow(size)
+		for j := 0; j < 20; j++ {
+			b.Freqs[rng.Intn(size)] += float32(1 + rng.Intn(3))
+		}
+		entries[i] = bow.Bowed{Id: fmt.Sprintf("entry%d", i), Bow: b}
+	}
+
+	db32, done32 := testDB(t, bow.EncodingFloat32, entries)
+	defer done32()
+	db16, done16 := testDB(t, bow.EncodingUint16, entries)
+	defer done16()
+
+	p := NewGaussian(size, 8, true, 1)
+	idx32, err := NewIndex(p, db32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx16, err := NewIndex(p, db16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx16.Entries != nil || idx16.Len() != len(entries) {
+		t.Fatalf("Quantized index has %d entries and expanded %d.",
+			idx16.Len(), len(idx16.Entries))
+	}
+	if db16.Entries != nil {
+		t.Fatalf("Indexing a quantized database expanded its entries.")
+	}
+	if !reflect.DeepEqual(idx32.vectors, idx16.vectors) {
+		t.Fatalf("Projections of quantized entries differ.")
+	}
+
+	opts := bowdb.SearchDefault
+	opts.Limit = 10
+	for _, query := range entries[:10] {
+		want := idx32.Search(opts, query, 50)
+		got := idx16.Search(opts, query, 50)
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("Search of the quantized index differs:\n%v\n%v",
+				want, got)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+// Package projection fits and applies linear projections of BOW vectors into
+// a space of much lower dimension. Distances between projected vectors
+// approximate distances between the original vectors, which makes them
+// suitable for a cheap prefilter before an exact search over a small set of
+// candidates. (A BOW for a library of 400 fragments is 1600 bytes, so a scan
+// over every BOW in a large database is bound by memory bandwidth.)
+//
+// Two kinds of projections are supported: PCA, which is fit to a sample of
+// BOWs with randomized subspace iteration, and random Gaussian projection,
+// which requires no fitting at all.
+package projection
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// Method names the technique used to build a projection.
+type Method string
+
+const (
+	// MethodPCA projects onto the principal components of a sample of BOWs.
+	MethodPCA Method = "pca"
+
+	// MethodGaussian projects onto random vectors drawn from a Gaussian.
+	MethodGaussian Method = "gaussian"
+)
+
+// Projection is a linear map from BOWs of a particular size to vectors of
+// size K, where K is the number of components.
+//
+// A projection is applied by (optionally) normalizing a BOW to unit length,
+// subtracting Mean and computing the dot product with each component.
+type Projection struct {
+	// The technique used to build this projection.
+	Method Method
+
+	// The size of the BOWs that can be projected. This is always equivalent
+	// to the size of the fragment library used to compute them.
+	Size int
+
+	// When true, BOWs are scaled to unit length before being projected.
+	// Euclidean distance between normalized vectors is a monotonic function
+	// of cosine distance, so this should be set when projected vectors are
+	// used to approximate cosine distance.
+	Normalize bool
+
+	// The mean of the sample of BOWs used to fit this projection. It is
+	// all zeros for a Gaussian projection.
+	Mean []float32
+
+	// K vectors, each of length Size, that BOWs are projected onto.
+	Components [][]float32
+}
+
+// PCAOptions specifies the parameters for fitting a PCA projection.
+type PCAOptions struct {
+	// Iterations is the number of power iterations performed. More
+	// iterations give more accurate components when the spectrum of the
+	// sample decays slowly.
+	Iterations int
+
+	// Oversample is the number of extra random vectors used beyond the
+	// number of components requested. Extra vectors improve the accuracy
+	// of the smallest components returned.
+	Oversample int
+
+	// Normalize sets the Normalize field of the fitted projection.
+	Normalize bool
+
+	// Seed seeds the random starting subspace.
+	Seed int64
+}
+
+// PCADefault provides reasonable defaults for fitting a PCA projection that
+// approximates cosine distance.
+var PCADefault = PCAOptions{
+	Iterations: 4,
+	Oversample: 10,
+	Normalize:  true,
+	Seed:       1,
+}
+
+// Dims returns the number of components of this projection, which is the
+// size of every projected vector.
+func (p *Projection) Dims() int {
+	return len(p.Components)
+}
+
+// Apply projects the BOW given. The BOW must have the same size as the
+// projection.
+func (p *Projection) Apply(b bow.Bow) []float32 {
+	v := make([]float32, p.Dims())
+	p.ApplyInto(v, b)
+	return v
+}
+
+// ApplyInto is like Apply, except the projected vector is written to dst,
+// which must have length equal to Dims.
+func (p *Projection) ApplyInto(dst []float32, b bow.Bow) {
+	if b.Len() != p.Size {
+		panic(fmt.Sprintf("Cannot project a BOW of size %d with a "+
+			"projection of size %d.", b.Len(), p.Size))
+	}
+	x := p.center(b)
+	for i, c := range p.Components {
+		dst[i] = dot32(c, x)
+	}
+}
+
+// center returns the frequencies of b with normalization and mean
+// subtraction applied.
+func (p *Projection) center(b bow.Bow) []float32 {
+	scale := float32(1)
+	if p.Normalize {
+		if mag := b.Magnitude(); mag > 0 {
+			scale = float32(1 / mag)
+		}
+	}
+	x := make([]float32, p.Size)
+	for i, f := range b.Freqs {
+		x[i] = f*scale - p.Mean[i]
+	}
+	return x
+}
+
+// NewGaussian returns a random projection from BOWs of the given size to
+// vectors of size k. Each entry of each component is drawn from a normal
+// distribution with mean 0 and variance 1/k, so that Euclidean distances are
+// preserved in expectation.
+func NewGaussian(size, k int, normalize bool, seed int64) *Projection {
+	rng := rand.New(rand.NewSource(seed))
+	stddev := 1 / math.Sqrt(float64(k))
+	p := &Projection{
+		Method:     MethodGaussian,
+		Size:       size,
+		Normalize:  normalize,
+		Mean:       make([]float32, size),
+		Components: make([][]float32, k),
+	}
+	for i := range p.Components {
+		p.Components[i] = make([]float32, size)
+		for j := range p.Components[i] {
+			p.Components[i][j] = float32(rng.NormFloat64() * stddev)
+		}
+	}
+	return p
+}
+
+// FitPCA returns a projection onto the first k principal components of the
+// sample of BOWs given.
+//
+// The components are computed with randomized subspace iteration (also known
+// as randomized SVD): a random subspace of dimension k+Oversample is
+// repeatedly multiplied by the sample covariance matrix and orthonormalized,
+// and the top k eigenvectors of the covariance matrix restricted to that
+// subspace are returned, ordered by decreasing variance. The covariance
+// matrix is never formed explicitly, so each iteration is a single pass over
+// the sample.
+//
+// FitPCA panics if the sample is empty, if the BOWs have differing lengths or
+// if k is not in the range [1, N], where N is the size of each BOW.
+func FitPCA(bows []bow.Bow, k int, opts PCAOptions) *Projection {
+	if len(bows) == 0 {
+		panic("Cannot fit a PCA projection to an empty set of BOWs.")
+	}
+	size := bows[0].Len()
+	if k < 1 || k > size {
+		panic(fmt.Sprintf("Cannot fit %d components to BOWs of size %d.",
+			k, size))
+	}
+
+	p := &Projection{
+		Method:    MethodPCA,
+		Size:      size,
+		Normalize: opts.Normalize,
+		Mean:      make([]float32, size),
+	}
+
+	// Compute the mean with the normalization already applied, then center
+	// the sample.
+	xs := make([][]float32, len(bows))
+	for i, b := range bows {
+		if b.Len() != size {
+			panic("Cannot fit a PCA projection to BOWs with differing lengths.")
+		}
+		xs[i] = p.center(b)
+	}
+	mean := make([]float64, size)
+	for _, x := range xs {
+		for j, f := range x {
+			mean[j] += float64(f)
+		}
+	}
+	for j := range mean {
+		p.Mean[j] = float32(mean[j] / float64(len(xs)))
+	}
+	for _, x := range xs {
+		for j := range x {
+			x[j] -= p.Mean[j]
+		}
+	}
+
+	l := k + opts.Oversample
+	if l > size {
+		l = size
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	q := make([][]float64, l)
+	for i := range q {
+		q[i] = make([]float64, size)
+		for j := range q[i] {
+			q[i][j] = rng.NormFloat64()
+		}
+	}
+	orthonormalize(q)
+	for it := 0; it < opts.Iterations; it++ {
+		q = covMul(xs, q)
+		orthonormalize(q)
+	}
+
+	// Rayleigh-Ritz: find the eigenvectors of the covariance matrix
+	// restricted to the subspace spanned by q.
+	cq := covMul(xs, q)
+	small := make([][]float64, l)
+	for i := range small {
+		small[i] = make([]float64, l)
+		for j := range small[i] {
+			small[i][j] = dot64(q[i], cq[j])
+		}
+	}
+	_, vecs := symEigen(small)
+
+	p.Components = make([][]float32, k)
+	for c := 0; c < k; c++ {
+		comp := make([]float32, size)
+		for i := 0; i < l; i++ {
+			w := vecs[i][c]
+			for j := range comp {
+				comp[j] += float32(w * q[i][j])
+			}
+		}
+		p.Components[c] = comp
+	}
+	return p
+}
+
+// covMul returns the product of the (unscaled) covariance matrix of the
+// centered sample xs with each vector in vs.
+func covMul(xs [][]float32, vs [][]float64) [][]float64 {
+	out := make([][]float64, len(vs))
+	for i := range out {
+		out[i] = make([]float64, len(vs[i]))
+	}
+	for _, x := range xs {
+		for i, v := range vs {
+			var d float64
+			for j, f := range x {
+				d += float64(f) * v[j]
+			}
+			if d == 0 {
+				continue
+			}
+			o := out[i]
+			for j, f := range x {
+				o[j] += d * float64(f)
+			}
+		}
+	}
+	return out
+}
+
+// orthonormalize applies modified Gram-Schmidt to the vectors given in
+// place. Vectors that are (nearly) linearly dependent on previous vectors are
+// set to zero.
+func orthonormalize(vs [][]float64) {
+	for i, v := range vs {
+		for _, u := range vs[:i] {
+			d := dot64(u, v)
+			for j := range v {
+				v[j] -= d * u[j]
+			}
+		}
+		mag := math.Sqrt(dot64(v, v))
+		for j := range v {
+			if mag < 1e-12 {
+				v[j] = 0
+			} else {
+				v[j] /= mag
+			}
+		}
+	}
+}
+
+// symEigen computes the eigenvalues and eigenvectors of the symmetric matrix
+// given using cyclic Jacobi rotations. Eigenvalues are returned in
+// decreasing order, and the c'th column of the returned matrix is the
+// eigenvector corresponding to the c'th eigenvalue. The matrix given is
+// destroyed.
+func symEigen(a [][]float64) ([]float64, [][]float64) {
+	n := len(a)
+	v := make([][]float64, n)
+	for i := range v {
+		v[i] = make([]float64, n)
+		v[i][i] = 1
+	}
+	for sweep := 0; sweep < 100; sweep++ {
+		var off float64
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				off += a[i][j] * a[i][j]
+			}
+		}
+		if off < 1e-22 {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := 0; k < n; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = c*vkp - s*vkq
+					v[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	// Selection sort the eigenpairs by decreasing eigenvalue.
+	vals := make([]float64, n)
+	for i := range vals {
+		vals[i] = a[i][i]
+	}
+	for i := 0; i < n; i++ {
+		best := i
+		for j := i + 1; j < n; j++ {
+			if vals[j] > vals[best] {
+				best = j
+			}
+		}
+		vals[i], vals[best] = vals[best], vals[i]
+		for k := 0; k < n; k++ {
+			v[k][i], v[k][best] = v[k][best], v[k][i]
+		}
+	}
+	return vals, v
+}
+
+func dot64(a, b []float64) float64 {
+	var d float64
+	for i := range a {
+		d += a[i] * b[i]
+	}
+	return d
+}
+
+func dot32(a, b []float32) float32 {
+	var d float32
+	for i := range a {
+		d += a[i] * b[i]
+	}
+	return d
+}
+
+// PathFor returns the conventional location of a projection stored
+// alongside the BOW database at dbPath.
+func PathFor(dbPath string) string {
+	return dbPath + ".projection.json"
+}
+
+// Write writes the projection as JSON.
+func (p *Projection) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(p)
+}
+
+// Read reads a projection written by Write.
+func Read(r io.Reader) (*Projection, error) {
+	p := new(Projection)
+	if err := json.NewDecoder(r).Decode(p); err != nil {
+		return nil, err
+	}
+	if p.Method != MethodPCA && p.Method != MethodGaussian {
+		return nil, fmt.Errorf("Unknown projection method '%s'.", p.Method)
+	}
+	if len(p.Mean) != p.Size {
+		return nil, fmt.Errorf("Projection mean has size %d but the "+
+			"projection has size %d.", len(p.Mean), p.Size)
+	}
+	for i, c := range p.Components {
+		if len(c) != p.Size {
+			return nil, fmt.Errorf("Projection component %d has size %d "+
+				"but the projection has size %d.", i, len(c), p.Size)
+		}
+	}
+	return p, nil
+}
+
+// Save writes the projection to the file at fpath.
+func (p *Projection) Save(fpath string) error {
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	if err := p.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Open reads a projection from the file at fpath.
+func Open(fpath string) (*Projection, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
@@ -0,0 +1,76 @@
+package projection
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+func TestFitPCA(t *testing.T) {
+	// Every sample lies (almost) on the line spanned by dir, so the first
+	// principal component should be parallel to dir.
+	size := 20
+	rng := rand.New(rand.NewSource(7))
+	dir := make([]float32, size)
+	for i := range dir {
+		dir[i] = float32(i%3) / float32(size)
+	}
+	bows := make([]bow.Bow, 200)
+	for i := range bows {
+		b := bow.NewBow(size)
+		s := float32(rng.NormFloat64() * 10)
+		for j := range b.Freqs {
+			b.Freqs[j] = s*dir[j] + float32(rng.NormFloat64()*0.01)
+		}
+		bows[i] = b
+	}
+
+	opts := PCADefault
+	opts.Normalize = false
+	p := FitPCA(bows, 3, opts)
+	if p.Dims() != 3 {
+		t.Fatalf("Expected 3 components but got %d.", p.Dims())
+	}
+
+	var d, mag float64
+	for i := range dir {
+		d += float64(dir[i] * p.Components[0][i])
+		mag += float64(dir[i] * dir[i])
+	}
+	if cos := math.Abs(d) / math.Sqrt(mag); cos < 0.999 {
+		t.Fatalf("First component is not parallel to the sample "+
+			"(|cos| = %f).", cos)
+	}
+	for i := range p.Components {
+		for j := range p.Components {
+			got := float64(dot32(p.Components[i], p.Components[j]))
+			want := 0.0
+			if i == j {
+				want = 1.0
+			}
+			if math.Abs(got-want) > 1e-4 {
+				t.Fatalf("Components %d and %d have dot product %f, "+
+					"but expected %f.", i, j, got, want)
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := p.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	p2, err := Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, v2 := p.Apply(bows[0]), p2.Apply(bows[0])
+	for i := range v1 {
+		if v1[i] != v2[i] {
+			t.Fatalf("Projection changed after round trip: %v != %v",
+				v1, v2)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package bow
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Encoding specifies how the fragment frequencies of a BOW are represented
+// in memory and on disk.
+//
+// The quantized encodings trade precision for space. Their error bounds are:
+//
+// EncodingUint8 and EncodingUint16 store unweighted fragment counts exactly.
+// Quantizing a BOW with a frequency that isn't a whole number, or that is
+// too big for the encoding (255 and 65535, respectively), fails. Distances
+// computed with these encodings are the same as distances computed with
+// float32 frequencies.
+//
+// EncodingScalar8 stores each frequency f of a BOW as a byte q with a scale s
+// shared by every frequency in the BOW, where s = max(f) / 255 and
+// q = round(f / s). The error of each non-zero frequency is at most s / 2, and
+// zero frequencies are stored exactly. Consequently, for a BOW with n non-zero
+// frequencies, the euclidean distance to any other BOW changes by at most
+// (s / 2) * sqrt(n), and the cosine distance changes by at most
+// sqrt(n) * s / |b|, where |b| is the magnitude of the BOW. Negative
+// frequencies cannot be quantized with this encoding.
+type Encoding int
+
+const (
+	EncodingFloat32 Encoding = iota
+	EncodingUint8
+	EncodingUint16
+	EncodingScalar8
+)
+
+func (enc Encoding) String() string {
+	switch enc {
+	case EncodingFloat32:
+		return "float32"
+	case EncodingUint8:
+		return "uint8"
+	case EncodingUint16:
+		return "uint16"
+	case EncodingScalar8:
+		return "scalar8"
+	}
+	panic(fmt.Sprintf("Unrecognized encoding: %d", enc))
+}
+
+// NewEncoding returns the encoding corresponding to the name given, which
+// should be one of "float32", "uint8", "uint16" or "scalar8".
+func NewEncoding(name string) (Encoding, error) {
+	all := []Encoding{
+		EncodingFloat32, EncodingUint8, EncodingUint16, EncodingScalar8,
+	}
+	for _, enc := range all {
+		if enc.String() == name {
+			return enc, nil
+		}
+	}
+	return 0, fmt.Errorf("Unrecognized encoding '%s'. Valid encodings are "+
+		"'float32', 'uint8', 'uint16' and 'scalar8'.", name)
+}
+
+// QuantizedBow is a bag-of-words whose frequencies are stored with one of
+// the quantized encodings. Only non-zero frequencies are stored: the
+// frequency of fragment Frags[k] is U8[k] * Scale or U16[k] * Scale,
+// depending on the encoding, and every other fragment has a frequency of
+// zero. Only one of U8 or U16 is used. Frags is in increasing order.
+//
+// Distances can be computed between a Bow and a QuantizedBow without
+// converting the QuantizedBow back to a Bow.
+type QuantizedBow struct {
+	Encoding Encoding
+	Scale    float32
+	Size     int
+	Frags    []uint16
+	U8       []uint8
+	U16      []uint16
+}
+
+// QuantizedBowed is like Bowed, except its bag-of-words is quantized.
+type QuantizedBowed struct {
+	Id   string
+	Data []byte
+	Bow  QuantizedBow
+}
+
+// Bowed converts the quantized bag-of-words into a regular Bowed value.
+func (qb QuantizedBowed) Bowed() Bowed {
+	return Bowed{Id: qb.Id, Data: qb.Data, Bow: qb.Bow.Bow()}
+}
+
+// Quantize returns the bag-of-words stored with the encoding given. An error
+// is returned if the BOW cannot be represented with the encoding. (See the
+// documentation for Encoding.)
+//
+// Quantize will panic if the encoding is EncodingFloat32.
+func Quantize(b Bow, enc Encoding) (QuantizedBow, error) {
+	q := QuantizedBow{Encoding: enc, Scale: 1, Size: b.Len()}
+	switch enc {
+	case EncodingUint8, EncodingUint16:
+		max := float32(math.MaxUint8)
+		if enc == EncodingUint16 {
+			max = math.MaxUint16
+		}
+		for i, f := range b.Freqs {
+			if f < 0 || f > max || f != float32(math.Floor(float64(f))) {
+				return QuantizedBow{}, fmt.Errorf("Frequency %f of fragment "+
+					"%d cannot be stored as %s.", f, i, enc)
+			}
+		}
+		for i, f := range b.Freqs {
+			if f == 0 {
+				continue
+			}
+			q.Frags = append(q.Frags, uint16(i))
+			if enc == EncodingUint8 {
+				q.U8 = append(q.U8, uint8(f))
+			} else {
+				q.U16 = append(q.U16, uint16(f))
+			}
+		}
+	case EncodingScalar8:
+		var max float32
+		for i, f := range b.Freqs {
+			if f < 0 {
+				return QuantizedBow{}, fmt.Errorf("Frequency %f of fragment "+
+					"%d is negative and cannot be stored as %s.", f, i, enc)
+			}
+			if f > max {
+				max = f
+			}
+		}
+		if max > 0 {
+			q.Scale = max / math.MaxUint8
+		}
+		for i, f := range b.Freqs {
+			// Frequencies smaller than half the scale round to zero.
+			u := uint8(math.Floor(float64(f/q.Scale) + 0.5))
+			if u > 0 {
+				q.Frags = append(q.Frags, uint16(i))
+				q.U8 = append(q.U8, u)
+			}
+		}
+	default:
+		panic(fmt.Sprintf("Cannot quantize a Bow with encoding '%s'.", enc))
+	}
+	return q, nil
+}
+
+// Len returns the size of the vector.
+func (q QuantizedBow) Len() int {
+	return q.Size
+}
+
+// NonZero returns the number of non-zero frequencies stored.
+func (q QuantizedBow) NonZero() int {
+	return len(q.Frags)
+}
+
+// value returns the k'th stored frequency, which is the frequency of
+// fragment Frags[k].
+func (q QuantizedBow) value(k int) float32 {
+	if q.Encoding == EncodingUint16 {
+		return float32(q.U16[k]) * q.Scale
+	}
+	return float32(q.U8[k]) * q.Scale
+}
+
+// Freq returns the (approximate) frequency of a fragment.
+func (q QuantizedBow) Freq(fragNum int) float32 {
+	k := sort.Search(len(q.Frags), func(k int) bool {
+		return int(q.Frags[k]) >= fragNum
+	})
+	if k == len(q.Frags) || int(q.Frags[k]) != fragNum {
+		return 0
+	}
+	return q.value(k)
+}
+
+// Bow converts the quantized bag-of-words into a regular bag-of-words.
+func (q QuantizedBow) Bow() Bow {
+	b := NewBow(q.Len())
+	q.BowInto(b)
+	return b
+}
+
+// BowInto is like Bow, except every frequency of b is overwritten, so that
+// a single Bow can be reused to expand many quantized BOWs one at a time.
+// BowInto will panic if b has a different length.
+func (q QuantizedBow) BowInto(b Bow) {
+	if b.Len() != q.Len() {
+		panic(fmt.Sprintf("Cannot expand a quantized BOW of size %d into a "+
+			"Bow of size %d.", q.Len(), b.Len()))
+	}
+	for i := range b.Freqs {
+		b.Freqs[i] = 0
+	}
+	for k, frag := range q.Frags {
+		b.Freqs[frag] = q.value(k)
+	}
+}
+
+// dotMag computes the dot product of b and q along with the squared
+// magnitudes of b and q. Since zero frequencies contribute nothing, the
+// results are exactly those of Bow.Cosine on q.Bow().
+func (q QuantizedBow) dotMag(b Bow) (dot, mag1, mag2 float32) {
+	fs := b.Freqs
+	for _, f := range fs {
+		mag1 += f * f
+	}
+
+	// This function is a hot-spot, so the loops are specialized for each
+	// representation.
+	if q.Encoding == EncodingUint16 {
+		for k, u := range q.U16 {
+			f1, f2 := fs[q.Frags[k]], float32(u)*q.Scale
+			dot += f1 * f2
+			mag2 += f2 * f2
+		}
+	} else {
+		for k, u := range q.U8 {
+			f1, f2 := fs[q.Frags[k]], float32(u)*q.Scale
+			dot += f1 * f2
+			mag2 += f2 * f2
+		}
+	}
+	return
+}
+
+// Cosine returns the cosine distance between b and q.
+func (q QuantizedBow) Cosine(b Bow) float64 {
+	dot, mag1, mag2 := q.dotMag(b)
+	r := 1.0 - (float64(dot) / math.Sqrt(float64(mag1)*float64(mag2)))
+	if math.IsNaN(r) {
+		return 1.0
+	}
+	return r
+}
+
+// Euclid returns the euclidean distance between b and q. The frequencies of
+// b are visited in order, so the result is exactly that of Bow.Euclid on
+// q.Bow().
+func (q QuantizedBow) Euclid(b Bow) float64 {
+	fs := b.Freqs
+	squareSum := float32(0)
+	next := 0
+	for k, frag := range q.Frags {
+		for ; next < int(frag); next++ {
+			squareSum += fs[next] * fs[next]
+		}
+		d := fs[frag] - q.value(k)
+		squareSum += d * d
+		next = int(frag) + 1
+	}
+	for ; next < len(fs); next++ {
+		squareSum += fs[next] * fs[next]
+	}
+	return math.Sqrt(float64(squareSum))
+}
@@ -0,0 +1,213 @@
+package bow
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomCounts returns a BOW of the size given with whole number frequencies
+// no bigger than max, where about half of the frequencies are zero.
+func randomCounts(rng *rand.Rand, size, max int) Bow {
+	b := NewBow(size)
+	for i := range b.Freqs {
+		if rng.Intn(2) == 0 {
+			b.Freqs[i] = float32(rng.Intn(max + 1))
+		}
+	}
+	return b
+}
+
+// randomFreqs is like randomCounts, except frequencies have a fractional
+// part, as they do in weighted BOWs.
+func randomFreqs(rng *rand.Rand, size int) Bow {
+	b := NewBow(size)
+	for i := range b.Freqs {
+		if rng.Intn(2) == 0 {
+			b.Freqs[i] = rng.Float32() * 10
+		}
+	}
+	return b
+}
+
+func TestQuantize(t *testing.T) {
+	b := newBowMap(10, map[int]float32{1: 3, 4: 255, 9: 1})
+	tests := []struct {
+		enc  Encoding
+		want QuantizedBow
+	}{
+		{EncodingUint8, QuantizedBow{
+			Encoding: EncodingUint8, Scale: 1, Size: 10,
+			Frags: []uint16{1, 4, 9}, U8: []uint8{3, 255, 1},
+		}},
+		{EncodingUint16, QuantizedBow{
+			Encoding: EncodingUint16, Scale: 1, Size: 10,
+			Frags: []uint16{1, 4, 9}, U16: []uint16{3, 255, 1},
+		}},
+		{EncodingScalar8, QuantizedBow{
+			Encoding: EncodingScalar8, Scale: 1, Size: 10,
+			Frags: []uint16{1, 4, 9}, U8: []uint8{3, 255, 1},
+		}},
+	}
+	for _, test := range tests {
+		q, err := Quantize(b, test.enc)
+		if err != nil {
+			t.Fatalf("%s: %s", test.enc, err)
+		}
+		if !reflect.DeepEqual(q, test.want) {
+			t.Fatalf("%s: expected %+v but got %+v.", test.enc, test.want, q)
+		}
+		if q.Len() != 10 || q.NonZero() != 3 {
+			t.Fatalf("%s: got length %d with %d non-zero frequencies.",
+				test.enc, q.Len(), q.NonZero())
+		}
+		for i, f := range b.Freqs {
+			if q.Freq(i) != f {
+				t.Fatalf("%s: expected frequency %f of fragment %d but got %f.",
+					test.enc, f, i, q.Freq(i))
+			}
+		}
+		if !q.Bow().Equal(b) {
+			t.Fatalf("%s: expected %s but got %s.", test.enc, b, q.Bow())
+		}
+	}
+
+	// A BOW without fragments has nothing to store.
+	for _, enc := range []Encoding{EncodingUint8, EncodingScalar8} {
+		q, err := Quantize(NewBow(10), enc)
+		if err != nil || q.NonZero() != 0 || q.Scale != 1 {
+			t.Fatalf("%s: quantized an empty BOW to %+v (%v).", enc, q, err)
+		}
+	}
+
+	errors := []struct {
+		enc   Encoding
+		freqs map[int]float32
+	}{
+		{EncodingUint8, map[int]float32{1: 256}},
+		{EncodingUint8, map[int]float32{1: 1.5}},
+		{EncodingUint8, map[int]float32{1: -1}},
+		{EncodingUint16, map[int]float32{1: 65536}},
+		{EncodingUint16, map[int]float32{1: 0.25}},
+		{EncodingScalar8, map[int]float32{1: 2, 2: -0.5}},
+	}
+	for _, test := range errors {
+		b := newBowMap(10, test.freqs)
+		if q, err := Quantize(b, test.enc); err == nil {
+			t.Fatalf("%s: expected an error for %v but got %+v.",
+				test.enc, test.freqs, q)
+		}
+	}
+}
+
+// TestQuantizeBounds checks the error bounds documented for each encoding
+// against distances computed with float32 frequencies.
+func TestQuantizeBounds(t *testing.T) {
+	const size, slack = 400, 1e-5
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 100; i++ {
+		query := randomFreqs(rng, size)
+
+		// Unweighted counts are stored exactly.
+		counts := randomCounts(rng, size, 255)
+		for _, enc := range []Encoding{EncodingUint8, EncodingUint16} {
+			q, err := Quantize(counts, enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Cosine(query) != counts.Cosine(query) ||
+				q.Euclid(query) != counts.Euclid(query) {
+				t.Fatalf("%s: distances differ from float32 distances.", enc)
+			}
+		}
+
+		b := randomFreqs(rng, size)
+		q, err := Quantize(b, EncodingScalar8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, n := float64(q.Scale), 0
+		for j, f := range b.Freqs {
+			if f != 0 {
+				n++
+			}
+			if d := math.Abs(float64(q.Freq(j) - f)); d > s/2+slack {
+				t.Fatalf("Frequency %f of fragment %d was stored as %f, "+
+					"with an error bigger than %f.", f, j, q.Freq(j), s/2)
+			}
+		}
+
+		bound := s / 2 * math.Sqrt(float64(n))
+		if d := math.Abs(q.Euclid(query) - b.Euclid(query)); d > bound+slack {
+			t.Fatalf("Euclidean distance changed by %f, but the bound is %f.",
+				d, bound)
+		}
+		bound = math.Sqrt(float64(n)) * s / b.Magnitude()
+		if d := math.Abs(q.Cosine(query) - b.Cosine(query)); d > bound+slack {
+			t.Fatalf("Cosine distance changed by %f, but the bound is %f.",
+				d, bound)
+		}
+	}
+}
+
+// TestQuantizedKernels checks that distances computed on the sparse
+// quantized frequencies are exactly those computed on the expanded BOW.
+func TestQuantizedKernels(t *testing.T) {
+	const size = 400
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 100; i++ {
+		query := randomFreqs(rng, size)
+		qs := make([]QuantizedBow, 0, 3)
+		for _, enc := range []Encoding{EncodingUint8, EncodingUint16} {
+			q, err := Quantize(randomCounts(rng, size, 255), enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qs = append(qs, q)
+		}
+		q, err := Quantize(randomFreqs(rng, size), EncodingScalar8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		qs = append(qs, q)
+
+		for _, q := range qs {
+			b := q.Bow()
+			if got, want := q.Cosine(query), b.Cosine(query); got != want {
+				t.Fatalf("%s: expected cosine distance %f but got %f.",
+					q.Encoding, want, got)
+			}
+			if got, want := q.Euclid(query), b.Euclid(query); got != want {
+				t.Fatalf("%s: expected euclidean distance %f but got %f.",
+					q.Encoding, want, got)
+			}
+		}
+	}
+
+	// The cosine distance to a zero vector is 1, like it is for Bow.
+	q, err := Quantize(NewBow(size), EncodingUint8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := q.Cosine(randomFreqs(rng, size)); d != 1 {
+		t.Fatalf("Expected a cosine distance of 1 to an empty BOW, but got %f.",
+			d)
+	}
+}
+
+func TestBowInto(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	b := randomFreqs(rng, 50)
+	for i := 0; i < 10; i++ {
+		q, err := Quantize(randomCounts(rng, 50, 255), EncodingUint8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.BowInto(b)
+		if !b.Equal(q.Bow()) {
+			t.Fatalf("Expected %s but got %s.", q.Bow(), b)
+		}
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/TuftsBCB/fragbag"
 	"github.com/TuftsBCB/io/pdb"
 	"github.com/TuftsBCB/io/pdbx"
 	"github.com/TuftsBCB/seq"
 	"github.com/TuftsBCB/structure"
+	"github.com/yunwilliamyu/esfragbag"
 )
 
 // Bowed corresponds to a bag-of-words with meta data about its source.
@@ -30,6 +30,17 @@ type Bowed struct {
 	Bow Bow
 }
 
+// Copy returns a deep copy of b, so that the copy does not share its Data or
+// frequencies with b.
+func (b Bowed) Copy() Bowed {
+	cp := Bowed{Id: b.Id, Bow: NewBow(b.Bow.Len())}
+	if b.Data != nil {
+		cp.Data = append([]byte(nil), b.Data...)
+	}
+	copy(cp.Bow.Freqs, b.Bow.Freqs)
+	return cp
+}
+
 // StructureBower corresponds to Bower values that can provide BOWs given
 // a structure fragment library.
 type StructureBower interface {
@@ -51,6 +62,10 @@ type pdbChainStructure struct {
 
 // BowerFromChain provides a reference implementation of the StructureBower
 // interface for PDB chains.
+//
+// Chain breaks are detected from both alpha-carbon distances and gaps in
+// residue numbering. Windows that span a chain break are skipped. (Their
+// number can be recorded with RecordSkippedWindows.)
 func BowerFromChain(c *pdb.Chain) StructureBower {
 	return pdbChainStructure{c}
 }
@@ -66,10 +81,15 @@ func (c pdbChainStructure) id() string {
 }
 
 func (c pdbChainStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
-	return Bowed{
-		Id:  c.id(),
-		Bow: StructureBow(lib, c.CaAtoms()),
-	}
+	b, _ := c.skippedBow(lib)
+	return b
+}
+
+func (c pdbChainStructure) skippedBow(
+	lib fragbag.StructureLibrary,
+) (Bowed, int) {
+	b, skipped := chainBow(lib, c.Chain)
+	return Bowed{Id: c.id(), Bow: b}, skipped
 }
 
 type pdbModelStructure struct {
@@ -77,7 +97,7 @@ type pdbModelStructure struct {
 }
 
 // BowerFromModel provides a reference implementation of the StructureBower
-// interface for PDB models.
+// interface for PDB models. Chain breaks are handled as in BowerFromChain.
 func BowerFromModel(c *pdb.Model) StructureBower {
 	return pdbModelStructure{c}
 }
@@ -94,10 +114,15 @@ func (m pdbModelStructure) id() string {
 }
 
 func (m pdbModelStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
-	return Bowed{
-		Id:  m.id(),
-		Bow: StructureBow(lib, m.CaAtoms()),
-	}
+	b, _ := m.skippedBow(lib)
+	return b
+}
+
+func (m pdbModelStructure) skippedBow(
+	lib fragbag.StructureLibrary,
+) (Bowed, int) {
+	b, skipped := modelBow(lib, m.Model)
+	return Bowed{Id: m.id(), Bow: b}, skipped
 }
 
 type cifChainStructure struct {
@@ -106,6 +131,15 @@ type cifChainStructure struct {
 
 // BowerFromCifChain provides a reference implementation of the StructureBower
 // interface for chains in PDBx/mmCIF formatted files.
+//
+// Like BowerFromChain, only the first model of a chain with more than one
+// model (e.g., an NMR ensemble) is used. To compute a BOW for every model or
+// to combine the BOWs of all models (e.g., with EnsembleMean), use
+// BowerFromCifEnsemble. A chain without any models has a BOW with all
+// frequencies set to 0.
+//
+// Windows that span a chain break are skipped. (Their number can be recorded
+// with RecordSkippedWindows.)
 func BowerFromCifChain(c *pdbx.Chain) StructureBower {
 	return cifChainStructure{c}
 }
@@ -115,10 +149,24 @@ func (c cifChainStructure) id() string {
 }
 
 func (c cifChainStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
-	return Bowed{
-		Id:  c.id(),
-		Bow: StructureBow(lib, c.Models[0].AlphaCarbons),
+	b, _ := c.skippedBow(lib)
+	return b
+}
+
+func (c cifChainStructure) skippedBow(
+	lib fragbag.StructureLibrary,
+) (Bowed, int) {
+	b, skipped := cifChainBow(lib, c.Chain)
+	return Bowed{Id: c.id(), Bow: b}, skipped
+}
+
+// cifChainBow computes the BOW of the first model of a chain, along with the
+// number of windows skipped.
+func cifChainBow(lib fragbag.StructureLibrary, c *pdbx.Chain) (Bow, int) {
+	if len(c.Models) == 0 {
+		return NewBow(lib.Size()), 0
 	}
+	return structureBow(lib, c.Models[0].AlphaCarbons)
 }
 
 // StructureBow is a helper function to compute a bag-of-words given a
@@ -127,25 +175,18 @@ func (c cifChainStructure) StructureBow(lib fragbag.StructureLibrary) Bowed {
 // If the lib given is a weighted library, then the Bow returned will also
 // be weighted.
 //
+// Windows of atoms that span a chain break are skipped. Chain breaks are
+// detected from the distance between consecutive alpha-carbons. (See
+// ChainBreaks and MaxCaDistance.) Use StructureBowBreaks to provide chain
+// breaks or to find out how many windows were skipped. (Earlier versions of
+// this package assigned a fragment to such windows, so the BOWs of structures
+// with missing residues differ from the BOWs they computed.)
+//
 // Note that this function should only be used when providing your own
 // implementation of the StructureBower interface. Otherwise, BOWs should
 // be computed using the StructureBow method of the interface.
 func StructureBow(lib fragbag.StructureLibrary, atoms []structure.Coords) Bow {
-	var best, uplimit int
-
-	b := NewBow(lib.Size())
-	libSize := lib.FragmentSize()
-	uplimit = len(atoms) - libSize
-	for i := 0; i <= uplimit; i++ {
-		best = lib.BestStructureFragment(atoms[i : i+libSize])
-		if best > -1 {
-			b.Freqs[best] += 1
-		}
-	}
-	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
-		b = b.Weighted(wlib)
-	}
-	return b
+	return StructureFragments(lib, atoms).Bow(lib)
 }
 
 // SequenceBower corresponds to Bower values that can provide BOWs given
@@ -155,19 +196,41 @@ type SequenceBower interface {
 	SequenceBow(lib fragbag.SequenceLibrary) Bowed
 }
 
+// SequenceIdFunc returns the identifier of a sequence given its name (e.g.,
+// the header of a FASTA entry).
+type SequenceIdFunc func(name string) string
+
+// SequenceIdFirstField returns the first whitespace delimited field of a
+// sequence name. If the name is empty, an empty string is returned.
+func SequenceIdFirstField(name string) string {
+	fields := strings.Fields(name)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
 type sequence struct {
 	seq.Sequence
+	idf SequenceIdFunc
 }
 
 // BowerFromSequence provides a reference implementation of the SequenceBower
-// interface for biological sequences.
+// interface for biological sequences. The identifier of each Bowed is the
+// first whitespace delimited field of the sequence's name.
 func BowerFromSequence(s seq.Sequence) SequenceBower {
-	return sequence{s}
+	return sequence{s, SequenceIdFirstField}
+}
+
+// BowerFromSequenceId is like BowerFromSequence, except the identifier of
+// each Bowed is computed from the sequence's name with the function given.
+func BowerFromSequenceId(s seq.Sequence, idf SequenceIdFunc) SequenceBower {
+	return sequence{s, idf}
 }
 
 func (s sequence) SequenceBow(lib fragbag.SequenceLibrary) Bowed {
 	return Bowed{
-		Id:   strings.Fields(s.Name)[0],
+		Id:   s.idf(s.Name),
 		Data: s.Bytes(),
 		Bow:  SequenceBow(lib, s.Sequence),
 	}
@@ -183,20 +246,5 @@ func (s sequence) SequenceBow(lib fragbag.SequenceLibrary) Bowed {
 // implementation of the SequenceBower interface. Otherwise, BOWs should
 // be computed using the SequenceBow method of the interface.
 func SequenceBow(lib fragbag.SequenceLibrary, s seq.Sequence) Bow {
-	var best, uplimit int
-
-	b := NewBow(lib.Size())
-	libSize := lib.FragmentSize()
-	uplimit = s.Len() - libSize
-	for i := 0; i <= uplimit; i++ {
-		best = lib.BestSequenceFragment(s.Slice(i, i+libSize))
-		if best < 0 {
-			continue
-		}
-		b.Freqs[best] += 1
-	}
-	if wlib, ok := lib.(fragbag.WeightedLibrary); ok {
-		b = b.Weighted(wlib)
-	}
-	return b
+	return SequenceFragments(lib, s).Bow(lib)
 }
@@ -0,0 +1,81 @@
+package bowdb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// FragmentsFunc returns the ordered list of fragments of an entry in a
+// BOW database. BOW databases only store bags-of-words, so the fragment
+// lists of entries must be provided by the caller (e.g., by recomputing them
+// from the source structure with bow.StructureFragments).
+type FragmentsFunc func(entry bow.Bowed) (bow.Fragments, error)
+
+// AlignResult corresponds to a single result returned from an alignment
+// search. It embeds a search result along with the alignment of the query's
+// fragments against the entry's fragments.
+type AlignResult struct {
+	SearchResult
+	bow.Alignment
+
+	// The half-open intervals of residues covered by the aligned regions of
+	// the query and the entry.
+	QueryResidues, EntryResidues [2]int
+}
+
+// SearchAlign performs a search against the query entry and reranks the
+// results by aligning the query's ordered fragments against the ordered
+// fragments of each hit. Unlike a bag-of-words, an alignment captures the
+// order in which fragments appear.
+//
+// The candidates are exactly the results of Search with the options given.
+// They are returned sorted by alignment score in descending order. Ties are
+// broken by the order returned by Search.
+//
+// The query's fragments and the fragments returned by `frags` must have been
+// computed with this database's fragment library. The substitution matrix
+// must have the same size as that library.
+func (db *DB) SearchAlign(
+	opts SearchOptions,
+	aopts bow.AlignOptions,
+	subst bow.SubstMatrix,
+	query bow.Bowed,
+	queryFrags bow.Fragments,
+	frags FragmentsFunc,
+) ([]AlignResult, error) {
+	if subst.Size != db.Lib.Size() {
+		return nil, fmt.Errorf("Substitution matrix has size %d but the "+
+			"fragment library of '%s' has size %d.",
+			subst.Size, db, db.Lib.Size())
+	}
+
+	fragSize := db.Lib.FragmentSize()
+	hits := db.Search(opts, query)
+	if err := db.Err(); err != nil {
+		return nil, err
+	}
+	results := make([]AlignResult, len(hits))
+	for i, hit := range hits {
+		entryFrags, err := frags(hit.Bowed)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get fragments for '%s': %s",
+				hit.Id, err)
+		}
+
+		aln := bow.Align(aopts, subst, queryFrags, entryFrags)
+		qs, qe := aln.QueryResidues(fragSize)
+		es, ee := aln.TargetResidues(fragSize)
+		results[i] = AlignResult{
+			SearchResult:  hit,
+			Alignment:     aln,
+			QueryResidues: [2]int{qs, qe},
+			EntryResidues: [2]int{es, ee},
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results, nil
+}
@@ -0,0 +1,257 @@
+package bowdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+	"strings"
+
+	"github.com/yunwilliamyu/esfragbag"
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// OpenAppend opens an existing BOW database for writing. Entries can then be
+// added with Add, deleted with Delete and replaced with Replace. As with
+// Create, Close must be called when finished.
+//
+// The fragment library given must be the same as the library embedded in
+// the database (i.e., it must have the same tag, name, size and fragment
+// size). Otherwise, an error is returned. The embedded library is never
+// modified.
+//
+// Changes are appended to the end of the database's archive: every call to
+// OpenAppend adds a new segment of entries and a list of deleted IDs. Use
+// Compact to rewrite the database without deleted entries once many changes
+// have accumulated.
+func OpenAppend(lib fragbag.Library, fpath string) (*DB, error) {
+	f, err := os.OpenFile(fpath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if version, err := formatVersion(f); err != nil {
+		f.Close()
+		return nil, err
+	} else if version != 1 {
+		f.Close()
+		return nil, errReadOnly(fpath, version)
+	}
+
+	db := &DB{Name: path.Base(fpath)}
+	if err := db.readMeta(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := sameLibrary(db.Lib, lib); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Could not open BOW database '%s' for "+
+			"appending: %s", fpath, err)
+	}
+	end, err := archiveEnd(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	segment, err := newSegmentWriter(fpath)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	db.session++
+	db.tombstones = nil
+	db.tw = tar.NewWriter(f)
+	db.segment = segment
+	db.writeBuf = new(bytes.Buffer)
+	db.entryChan = make(chan bow.Bowed)
+	db.writingDone = make(chan struct{})
+	db.startWriting()
+	db.file = f
+	return db, nil
+}
+
+// Delete removes every entry with the ID given that was in the database
+// when it was opened with OpenAppend. (Entries added since are not affected.)
+// It is safe to call Delete from multiple goroutines.
+//
+// Delete will panic if it is called on a BOW database that has been opened
+// for reading.
+func (db *DB) Delete(id string) {
+	if db.entryChan == nil {
+		panic("Cannot delete from a BOW database opened in read mode.")
+	}
+	db.deleteLock.Lock()
+	db.deletes = append(db.deletes, id)
+	db.deleteLock.Unlock()
+}
+
+// Replace deletes every entry with the same ID as the entry given (see
+// Delete) and then adds the entry.
+func (db *DB) Replace(e bow.Bowed) {
+	db.Delete(e.Id)
+	db.Add(e)
+}
+
+// writeTombstones writes the IDs deleted in this session, if any.
+func (db *DB) writeTombstones() error {
+	if len(db.deletes) == 0 {
+		return nil
+	}
+	ids := []byte(strings.Join(db.deletes, "\n") + "\n")
+	name := memberName(fileTombstones, db.session)
+	if err := db.writeMember(name, ids); err != nil {
+		return fmt.Errorf("Could not write deleted IDs: %s", err)
+	}
+	return nil
+}
+
+// Compact rewrites the BOW database at fpath so that it contains a single
+// segment of entries, without any deleted entries. The encoding, the
+// embedded fragment library and a stored inverted index (if any) are
+// preserved, and entries are copied without being decoded.
+//
+// The database is rewritten to a temporary file in the same directory, which
+// then replaces the original. No other process should append to the database
+// while it is being compacted.
+func Compact(fpath string) error {
+	db, err := Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if db.Version != 1 {
+		return errReadOnly(fpath, db.Version)
+	}
+
+	tmpDir, err := ioutil.TempDir(path.Dir(fpath), ".compact")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Use the same base name so that the directory inside the archive
+	// doesn't change.
+	tmpPath := path.Join(tmpDir, path.Base(fpath))
+	out, err := CreateEncoded(db.Lib, tmpPath, db.Encoding)
+	if err != nil {
+		return err
+	}
+	if err := db.copyRaw(out); err != nil {
+		out.Close()
+		return err
+	}
+	if db.invertedStored {
+		// The compacted database has the same entries in the same order,
+		// so its index is built from a second reader of the original.
+		src, err := Open(fpath)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		n, entryAt, err := src.loaded()
+		if err == nil {
+			idx := newInvertedIndex(db.Lib.Size(), n, entryAt)
+			err = out.writeInverted(idx)
+		}
+		src.Close()
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, fpath)
+}
+
+// copyRaw copies every remaining entry that hasn't been deleted to out,
+// without decoding it. Both databases must have the same encoding.
+func (db *DB) copyRaw(out *DB) error {
+	for {
+		id, data, freqs, err := db.readRaw()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := out.writeRaw(id, data, freqs); err != nil {
+			return err
+		}
+	}
+}
+
+// errReadOnly is returned when modifying a database whose format version
+// doesn't support it.
+func errReadOnly(fpath string, version int) error {
+	return fmt.Errorf("BOW database '%s' has format version %d, which "+
+		"cannot be modified. Convert it to version 1 first.", fpath, version)
+}
+
+// writeRaw writes an entry whose BOW has already been encoded with the
+// database's encoding. It must not be called concurrently with Add.
+func (db *DB) writeRaw(id string, data, freqs []byte) error {
+	db.writeBuf.WriteString(id)
+	if err := db.writeItem(); err != nil {
+		return err
+	}
+	db.writeBuf.Write(data)
+	if err := db.writeItem(); err != nil {
+		return err
+	}
+	db.writeBuf.Write(freqs)
+	if err := db.writeItem(); err != nil {
+		return err
+	}
+	db.numWritten++
+	return nil
+}
+
+// sameLibrary returns an error if the two fragment libraries differ.
+func sameLibrary(embedded, lib fragbag.Library) error {
+	if embedded.Tag() != lib.Tag() ||
+		embedded.Name() != lib.Name() ||
+		embedded.Size() != lib.Size() ||
+		embedded.FragmentSize() != lib.FragmentSize() {
+		return fmt.Errorf("Fragment library '%s' (%s, %d fragments of size "+
+			"%d) differs from the embedded library '%s' (%s, %d fragments "+
+			"of size %d).",
+			lib.Name(), lib.Tag(), lib.Size(), lib.FragmentSize(),
+			embedded.Name(), embedded.Tag(), embedded.Size(),
+			embedded.FragmentSize())
+	}
+	return nil
+}
+
+// archiveEnd returns the offset of the end of the last member in a TAR
+// archive, which is where new members should be written.
+func archiveEnd(f *os.File) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var end int64
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return end, nil
+		} else if err != nil {
+			return 0, err
+		}
+
+		// The TAR reader has read exactly up to the member's data, which is
+		// padded to a multiple of the block size.
+		dataStart, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		end = dataStart + (hdr.Size+511)/512*512
+	}
+}
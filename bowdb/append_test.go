@@ -0,0 +1,281 @@
+package bowdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag"
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// otherLibrary is a fragment library that differs from the library it wraps
+// only by its name.
+type otherLibrary struct {
+	fragbag.Library
+}
+
+func (lib otherLibrary) Name() string { return "other-" + lib.Library.Name() }
+
+// appendEntries opens the database at fpath for appending, calls f and then
+// closes the database.
+func appendEntries(
+	t *testing.T,
+	lib fragbag.Library,
+	fpath string,
+	f func(db *DB),
+) {
+	db, err := OpenAppend(lib, fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// memberNames returns the base names of every file in the archive at fpath.
+func memberNames(t *testing.T, fpath string) []string {
+	archive, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return names
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			names = append(names, filepath.Base(hdr.Name))
+		}
+	}
+}
+
+// samePostings returns whether two inverted indexes have the same posting
+// lists, treating empty lists as equal to nil lists.
+func samePostings(idx1, idx2 *InvertedIndex) bool {
+	if idx1.NumEntries != idx2.NumEntries ||
+		len(idx1.Postings) != len(idx2.Postings) {
+		return false
+	}
+	for frag := range idx1.Postings {
+		p1, p2 := idx1.Postings[frag], idx2.Postings[frag]
+		if len(p1) != len(p2) {
+			return false
+		}
+		for i := range p1 {
+			if p1[i] != p2[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestAppend(t *testing.T) {
+	lib := testLibrary(t)
+	rng := rand.New(rand.NewSource(9))
+	entries := testEntries(rng, 100, lib)
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, enc := range []bow.Encoding{bow.EncodingFloat32, bow.EncodingUint8} {
+		fpath := filepath.Join(dir, enc.String()+".bowdb")
+		createTestDB(t, lib, fpath, enc, entries[:60])
+
+		// Append, then reopen.
+		appendEntries(t, lib, fpath, func(db *DB) {
+			for _, e := range entries[60:] {
+				db.Add(e)
+			}
+		})
+		if got := readAll(t, fpath); !reflect.DeepEqual(entries, got) {
+			t.Fatalf("%s: expected %d entries after appending but got %d.",
+				enc, len(entries), len(got))
+		}
+
+		// Delete entries from both sessions, then read them all.
+		appendEntries(t, lib, fpath, func(db *DB) {
+			db.Delete(entries[0].Id)
+			db.Delete(entries[70].Id)
+			db.Delete("missing")
+		})
+		want := append([]bow.Bowed(nil), entries[1:70]...)
+		want = append(want, entries[71:]...)
+		if got := readAll(t, fpath); !reflect.DeepEqual(want, got) {
+			t.Fatalf("%s: expected %d entries after deleting but got %d.",
+				enc, len(want), len(got))
+		}
+
+		// Replace an entry: its ID appears once, with the new BOW, after
+		// every other entry.
+		replaced := bow.Bowed{Id: entries[5].Id, Bow: entries[6].Bow}
+		appendEntries(t, lib, fpath, func(db *DB) {
+			db.Replace(replaced)
+		})
+		want = append(append(want[:4:4], want[5:]...), replaced)
+		got := readAll(t, fpath)
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("%s: expected %d entries after replacing but got %d.",
+				enc, len(want), len(got))
+		}
+		if err := verify(fpath); err != nil {
+			t.Fatalf("%s: appended database failed to verify: %s", enc, err)
+		}
+
+		// Compacting keeps the same entries in a single session, and
+		// rebuilds a stored inverted index.
+		if err := WriteInvertedIndex(fpath); err != nil {
+			t.Fatal(err)
+		}
+		appendEntries(t, lib, fpath, func(db *DB) {
+			db.Delete(entries[99].Id)
+		})
+		want = want[:len(want)-1]
+		want = append(want[:len(want)-1], replaced)
+		if err := Compact(fpath); err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, fpath); !reflect.DeepEqual(want, got) {
+			t.Fatalf("%s: expected %d entries after compacting but got %d.",
+				enc, len(want), len(got))
+		}
+		members := strings.Join(memberNames(t, fpath), " ")
+		if strings.Contains(members, fileBowDB+".") ||
+			strings.Contains(members, fileTombstones) {
+			t.Fatalf("%s: compacted database has members %s.", enc, members)
+		}
+		if err := verify(fpath); err != nil {
+			t.Fatalf("%s: compacted database failed to verify: %s", enc, err)
+		}
+
+		db, err := Open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := db.readInverted()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, entryAt, err := db.loaded()
+		if err != nil {
+			t.Fatal(err)
+		}
+		built := newInvertedIndex(lib.Size(), n, entryAt)
+		if stored == nil || stored.session != db.lastSession ||
+			!samePostings(stored, built) {
+			t.Fatalf("%s: compacting did not rebuild the stored inverted "+
+				"index.", enc)
+		}
+		db.Close()
+	}
+
+	// Segments are written to temporary files, which are removed on close.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), ".") {
+			t.Fatalf("Temporary file '%s' was left behind.", fi.Name())
+		}
+	}
+}
+
+func TestAppendLibraryMismatch(t *testing.T) {
+	lib := testLibrary(t)
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "test.bowdb")
+	createTestDB(t, lib, fpath, bow.EncodingFloat32,
+		testEntries(rand.New(rand.NewSource(1)), 10, lib))
+	before, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenAppend(otherLibrary{lib}, fpath)
+	if err == nil {
+		db.Close()
+		t.Fatalf("Opened a database for appending with a different library.")
+	}
+	if !strings.Contains(err.Error(), "differs from the embedded library") {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	after, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatalf("Database was modified by a failed OpenAppend.")
+	}
+}
+
+// TestArchiveEnd checks that archiveEnd finds the end of the last member from
+// the file position left by the TAR reader, for members of every size
+// relative to the block size and with extended (PAX) headers.
+func TestArchiveEnd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sizes := []int{0, 1, 511, 512, 513, 2000}
+	for i := range sizes {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		for j, size := range sizes[:i+1] {
+			name := "member" + strings.Repeat("x", j*40)
+			hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(size)}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(make([]byte, size)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		want := int64(buf.Len())
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		fpath := filepath.Join(dir, "test.tar")
+		if err := ioutil.WriteFile(fpath, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := archiveEnd(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("Archive with sizes %v: expected end %d but got %d.",
+				sizes[:i+1], want, got)
+		}
+	}
+}
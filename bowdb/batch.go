@@ -0,0 +1,238 @@
+package bowdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// batchBlockSize is the number of entries compared with every query in a
+// batch before moving on to the next block of entries. A block of entries
+// with 400 fragments each fits comfortably in a typical L2 cache.
+const batchBlockSize = 256
+
+// SearchBatch runs a search for every query given. The i'th element of the
+// result is identical to the result of Search(opts, queries[i]). (So if the
+// entries cannot be read, every result is empty and Err returns the error.)
+//
+// Instead of a full pass over the database for each query, entries are
+// compared with every query one block at a time, so that each block is read
+// from memory once while it is in cache. The queries are split among
+// opts.Workers goroutines.
+func (db *DB) SearchBatch(
+	opts SearchOptions,
+	queries []bow.Bowed,
+) [][]SearchResult {
+	results := make([][]SearchResult, len(queries))
+	if len(queries) == 0 {
+		return results
+	}
+
+	var numEntries int
+	var entryAt func(i int) bow.Bowed
+	distances := make([]func(i int) float64, len(queries))
+	qopts := make([]SearchOptions, len(queries))
+	nulls := make([]*NullDistribution, len(queries))
+	for q, query := range queries {
+		var err error
+		numEntries, distances[q], entryAt, err = db.scan(opts, query)
+		if err != nil {
+			return results
+		}
+		qopts[q], nulls[q] = significant(
+			opts, query, numEntries, db.distanceTo(opts))
+	}
+
+	workers := opts.Workers
+	if workers > len(queries) {
+		workers = len(queries)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			qstart := w * len(queries) / workers
+			qend := (w + 1) * len(queries) / workers
+			hits := make([]*collector, qend-qstart)
+			for q := range hits {
+				hits[q] = newCollector(qopts[qstart+q], queries[qstart+q])
+				hits[q].null = nulls[qstart+q]
+			}
+			for start := 0; start < numEntries; start += batchBlockSize {
+				end := start + batchBlockSize
+				if end > numEntries {
+					end = numEntries
+				}
+				for q, qhits := range hits {
+					distance := distances[qstart+q]
+					for i := start; i < end; i++ {
+						dist := distance(i)
+						if qhits.wants(dist, i) {
+							qhits.insert(entryAt(i), dist, i)
+						}
+					}
+				}
+			}
+			for q, qhits := range hits {
+				results[qstart+q] = qhits.results()
+			}
+		}(w)
+	}
+	wg.Wait()
+	return results
+}
+
+// MatrixOptions specifies how an all-vs-all distance matrix is computed and
+// written.
+type MatrixOptions struct {
+	// SortBy specifies the distance metric: SortByCosine or SortByEuclid.
+	SortBy int
+
+	// Sparse specifies whether only the pairs of entries with a distance
+	// of at most Max are written.
+	Sparse bool
+
+	// Max is the distance threshold for a sparse matrix. It is ignored for
+	// a dense matrix.
+	Max float64
+
+	// ChunkRows is the number of rows of the matrix computed in memory before
+	// they are written. Memory use is proportional to ChunkRows times the
+	// number of entries for a dense matrix.
+	ChunkRows int
+
+	// Workers is the number of goroutines computing the rows of a chunk.
+	Workers int
+}
+
+// MatrixDefault provides default settings for a dense cosine distance matrix.
+var MatrixDefault = MatrixOptions{
+	SortBy:    SortByCosine,
+	Sparse:    false,
+	Max:       math.MaxFloat64,
+	ChunkRows: 256,
+	Workers:   runtime.NumCPU(),
+}
+
+// WriteDistanceMatrix computes the distance between every pair of entries in
+// the database and writes the resulting matrix to w. Rows and columns are in
+// the order of the database's entries (i.e., the order of Entries or
+// QuantizedEntries).
+//
+// A dense matrix is written in row-major order, where every distance is a
+// big-endian float32. For N entries, exactly 4*N*N bytes are written.
+//
+// A sparse matrix is written as text, with one line for every pair of distinct
+// entries with a distance of at most opts.Max. Each line has the IDs of the
+// two entries followed by their distance, separated by tabs. Since the
+// supported metrics are symmetric, each pair is written only once (with the
+// entry that comes first in the database first). This is the "abc" format
+// used by many clustering programs.
+func (db *DB) WriteDistanceMatrix(w io.Writer, opts MatrixOptions) error {
+	switch opts.SortBy {
+	case SortByCosine, SortByEuclid:
+	default:
+		return fmt.Errorf("Unrecognized SortBy value: %d", opts.SortBy)
+	}
+	chunkRows := opts.ChunkRows
+	if chunkRows < 1 {
+		chunkRows = MatrixDefault.ChunkRows
+	}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	sopts := SearchOptions{SortBy: opts.SortBy}
+	numEntries, entryAt, err := db.loaded()
+	if err != nil {
+		return err
+	}
+	var ids []string
+	if opts.Sparse {
+		ids = make([]string, numEntries)
+		for i := range ids {
+			ids[i] = entryAt(i).Id
+		}
+	}
+	buf := bufio.NewWriter(w)
+	rows := make([][]float32, chunkRows)
+	for start := 0; start < numEntries; start += chunkRows {
+		end := start + chunkRows
+		if end > numEntries {
+			end = numEntries
+		}
+
+		// Compute the rows of this chunk concurrently. For a sparse
+		// matrix, only the upper triangle is needed.
+		next := make(chan int)
+		wg := new(sync.WaitGroup)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					first := 0
+					if opts.Sparse {
+						first = i + 1
+					}
+					row := rows[i-start][:0]
+					_, distance, _, _ := db.scan(sopts, entryAt(i))
+					for j := first; j < numEntries; j++ {
+						row = append(row, float32(distance(j)))
+					}
+					rows[i-start] = row
+				}
+			}()
+		}
+		for i := start; i < end; i++ {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+
+		for i := start; i < end; i++ {
+			var err error
+			if opts.Sparse {
+				err = writeSparseRow(buf, opts.Max, ids, i, rows[i-start])
+			} else {
+				err = binary.Write(buf, binary.BigEndian, rows[i-start])
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return buf.Flush()
+}
+
+// writeSparseRow writes the distances in the upper triangle of the i'th row
+// that are at most max, where ids has the ID of every entry.
+func writeSparseRow(
+	w io.Writer,
+	max float64,
+	ids []string,
+	i int,
+	row []float32,
+) error {
+	for k, dist := range row {
+		if float64(dist) > max {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%g\n", ids[i], ids[i+1+k], dist)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package bowdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+func TestSearchBatch(t *testing.T) {
+	lib := testLibrary(t)
+	rng := rand.New(rand.NewSource(14))
+	entries := testEntries(rng, 700, lib)
+	queries := append(testEntries(rng, 5, lib), entries[10], entries[20])
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := []SearchOptions{
+		SearchDefault,
+		{Limit: -1, Min: 0, Max: 0.5, SortBy: SortByCosine, Order: OrderDesc,
+			Workers: 3},
+		{Limit: 0, Min: 0, Max: 2, SortBy: SortByCosine},
+		{Limit: 5, Min: 0, Max: 100, SortBy: SortByEuclid, Workers: 20},
+		{Limit: 10, Min: 0, Max: 2, SortBy: SortByCosine, Workers: 2,
+			Filter: Filter{
+				ExcludeSelf: true,
+				Exclude:     regexp.MustCompile("1$"),
+			}},
+		{Limit: 10, Min: 0, Max: 2, SortBy: SortByCosine, Null: NullScores},
+		{Limit: 10, Min: 0, Max: 100, SortBy: SortByEuclid, Workers: 4,
+			Null: NullDecoys, Decoys: 3},
+	}
+	for _, enc := range []bow.Encoding{bow.EncodingFloat32, bow.EncodingUint8} {
+		fpath := filepath.Join(dir, enc.String()+".bowdb")
+		createTestDB(t, lib, fpath, enc, entries)
+		deleteIds(t, lib, fpath, entries[20].Id, entries[300].Id)
+
+		db, err := Open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opts := range options {
+			results := db.SearchBatch(opts, queries)
+			if len(results) != len(queries) {
+				t.Fatalf("%s: expected %d results but got %d.",
+					enc, len(queries), len(results))
+			}
+			for i, query := range queries {
+				want := db.Search(opts, query)
+				if !reflect.DeepEqual(want, results[i]) {
+					t.Fatalf("%s: SearchBatch (%+v) differs from Search for "+
+						"query '%s':\nwant: %s\ngot:  %s", enc, opts, query.Id,
+						resultIds(want), resultIds(results[i]))
+				}
+			}
+		}
+		if results := db.SearchBatch(SearchDefault, nil); len(results) != 0 {
+			t.Fatalf("%s: got %d results without queries.", enc, len(results))
+		}
+		db.Close()
+	}
+}
+
+func TestWriteDistanceMatrix(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(rand.New(rand.NewSource(15)), 50, lib)
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, enc := range []bow.Encoding{bow.EncodingFloat32, bow.EncodingUint8} {
+		fpath := filepath.Join(dir, enc.String()+".bowdb")
+		createTestDB(t, lib, fpath, enc, entries)
+		deleteIds(t, lib, fpath, entries[7].Id)
+		all := readAll(t, fpath)
+		n := len(all)
+
+		for _, sortBy := range []int{SortByCosine, SortByEuclid} {
+			// The distance between the i'th and j'th entries, computed in
+			// the same order as a search with the i'th entry as the query.
+			distance := func(i, j int) float32 {
+				q, e := all[i].Bow, all[j].Bow
+				if enc != bow.EncodingFloat32 {
+					q, e = e, q
+				}
+				if sortBy == SortByCosine {
+					return float32(q.Cosine(e))
+				}
+				return float32(q.Euclid(e))
+			}
+			opts := MatrixOptions{SortBy: sortBy, ChunkRows: 7, Workers: 3}
+			name := fmt.Sprintf("%s (sort by %d)", enc, sortBy)
+
+			// A dense matrix has 4*N*N bytes of big-endian float32s.
+			dense := writeMatrix(t, fpath, opts)
+			if len(dense) != 4*n*n {
+				t.Fatalf("%s: expected %d bytes but got %d.",
+					name, 4*n*n, len(dense))
+			}
+			for i := 0; i < n; i++ {
+				for j := 0; j < n; j++ {
+					bits := binary.BigEndian.Uint32(dense[4*(i*n+j):])
+					got, want := math.Float32frombits(bits), distance(i, j)
+					if got != want {
+						t.Fatalf("%s: expected distance %f at (%d, %d) but "+
+							"got %f.", name, want, i, j, got)
+					}
+				}
+			}
+
+			// A sparse matrix has every pair at most the threshold, which
+			// is inclusive, whatever the chunk size. The threshold is the
+			// median of the distances less than the largest distance.
+			var upper []float64
+			for i := 0; i < n; i++ {
+				for j := i + 1; j < n; j++ {
+					upper = append(upper, float64(distance(i, j)))
+				}
+			}
+			sort.Float64s(upper)
+			below := upper[:sort.SearchFloat64s(upper, upper[len(upper)-1])]
+			if len(below) == 0 {
+				t.Fatalf("%s: every pair has the same distance.", name)
+			}
+			opts.Sparse = true
+			opts.Max = below[len(below)/2]
+
+			want := new(bytes.Buffer)
+			for i := 0; i < n; i++ {
+				for j := i + 1; j < n; j++ {
+					if d := distance(i, j); float64(d) <= opts.Max {
+						fmt.Fprintf(want, "%s\t%s\t%g\n",
+							all[i].Id, all[j].Id, d)
+					}
+				}
+			}
+			for _, chunkRows := range []int{1, 7, n, 1000} {
+				opts.ChunkRows = chunkRows
+				sparse := writeMatrix(t, fpath, opts)
+				if !bytes.Equal(want.Bytes(), sparse) {
+					t.Fatalf("%s: sparse matrix with %d rows per chunk "+
+						"differs:\nwant:\n%s\ngot:\n%s",
+						name, chunkRows, want, sparse)
+				}
+			}
+		}
+	}
+
+	db, err := Open(filepath.Join(dir, "float32.bowdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	opts := MatrixDefault
+	opts.SortBy = 99
+	if err := db.WriteDistanceMatrix(new(bytes.Buffer), opts); err == nil {
+		t.Fatalf("Expected an error for an unrecognized metric.")
+	}
+}
+
+// writeMatrix returns the distance matrix of the database at fpath.
+func writeMatrix(t *testing.T, fpath string, opts MatrixOptions) []byte {
+	db, err := Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	buf := new(bytes.Buffer)
+	if err := db.WriteDistanceMatrix(buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
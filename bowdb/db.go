@@ -6,11 +6,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	path "path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,14 +23,17 @@ import (
 )
 
 const (
-	fileBowDB   = "bow.db"
-	fileFragLib = "frag-lib.json"
+	fileBowDB      = "bow.db"
+	fileFragLib    = "frag-lib.json"
+	fileEncoding   = "encoding"
+	fileTombstones = "deleted"
 )
 
 // DB represents a BOW database. It is always connected to a particular
 // fragment library. In particular, the disk representation of the database is
 // a directory with a copy of the fragment library used to create the database
-// and a binary formatted file of all the frequency vectors computed.
+// and a binary formatted file of all the frequency vectors computed. (This
+// is format version 1. See Convert for the memory-mapped version 2 format.)
 type DB struct {
 	// The fragment library used to make this database.
 	Lib fragbag.Library
@@ -36,35 +42,80 @@ type DB struct {
 	// name of the database's file path).
 	Name string
 
+	// The encoding of the fragment frequencies of every entry.
+	Encoding bow.Encoding
+
+	// The format version of the database on disk: 1 for a TAR archive or 2
+	// for a memory-mapped file.
+	Version int
+
 	// The set of entries read from disk when reading a bow DB.
 	// This is populated by ReadAll.
 	Entries     []bow.Bowed
 	readAllLock *sync.Mutex // Protects concurrent calls of ReadAll
 
+	// The set of entries read from disk when reading a bow DB with a
+	// quantized encoding. This is populated by ReadAllQuantized.
+	QuantizedEntries []bow.QuantizedBowed
+	readErr          error // The error that stopped reading entries.
+
+	idIndex idIndex   // Entry IDs in sorted order, for lookups by ID.
+	idsOnce sync.Once // Builds the ID index on first use.
+
+	inverted       *InvertedIndex // Built or read on first use.
+	invertedLock   sync.Mutex     // Protects inverted.
+	invertedStored bool           // Whether the archive has an index.
+
 	fileBuf *bufio.Reader // A buffer for reading the bow db.
+	tr      *tar.Reader   // The archive containing the bow db.
+
+	// Every append to a database is a session, which adds a new segment
+	// of entries and a set of deleted IDs. The entries of the original
+	// database are in session 0.
+	session     int            // Session of the segment being read/written.
+	lastSession int            // The most recent session of the database.
+	tombstones  map[string]int // Latest session in which an ID was deleted.
+	deletes     []string       // IDs deleted in this (writing) session.
+	deleteLock  *sync.Mutex    // Protects deletes.
+
+	manifests  map[int]*manifest // The manifest of every session that has one.
+	segEntries int               // Entries read from the current segment.
+	segCRC     hash.Hash32       // Checksum of the current segment so far.
 
 	entryBuf []byte    // Temporary buffer for reading DB entries.
+	rawData  []byte    // Temporary buffer for the data of an entry.
 	bowPool  []float32 // Memory pool for fragment frequencies.
 	bowLast  int       // Last index used in bow pool.
+	u8Pool   []uint8   // Memory pool for 8-bit quantized frequencies.
+	u8Last   int       // Last index used in 8-bit pool.
+	u16Pool  []uint16  // Memory pool for fragment numbers and 16-bit values.
+	u16Last  int       // Last index used in 16-bit pool.
 	dataPool []byte    // Memory pool for entry data.
 	dataLast int       // Last index used in data pool.
 
 	tw          *tar.Writer    // The writer archive.
-	saveBuf     *bytes.Buffer  // Buffer for bowdb while writing.
+	written     []memberSum    // Members written in this session.
+	numWritten  int            // Entries written in this session.
+	segment     *segmentWriter // Entries written in this session.
 	writeBuf    *bytes.Buffer  // Temporary buffer for binary.
 	writingDone chan struct{}  // Indicate when writing is done.
 	entryChan   chan bow.Bowed // Concurrent writing.
 
 	file *os.File // Save the file that was opened
+
+	v2    *v2File // The mapped file of a version 2 database.
+	v2Pos int     // The next entry read by readRaw in a version 2 database.
 }
 
 // Open opens a new BOW database for reading. In particular, all entries
 // in the database will be loaded into memory.
+//
+// Both format versions are supported. A version 2 database is mapped into
+// memory rather than read, so that it can be searched right away.
 func Open(fpath string) (*DB, error) {
-	var err error
-
 	db := &DB{
 		Name:        path.Base(fpath),
+		Version:     1,
 		readAllLock: new(sync.Mutex),
 	}
 
@@ -72,32 +123,199 @@ func Open(fpath string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	tr := tar.NewReader(dbf)
-
-	if _, err := tr.Next(); err != nil { // the dir header, skip it
+	version, err := formatVersion(dbf)
+	if err != nil {
+		dbf.Close()
 		return nil, err
 	}
-	if _, err := tr.Next(); err != nil { // the flib header
-		return nil, err
+	if version == 2 {
+		if err := db.openV2(dbf); err != nil {
+			dbf.Close()
+			return nil, err
+		}
+		db.file = dbf
+		return db, nil
 	}
-
-	db.Lib, err = fragbag.Open(tr)
-	if err != nil {
+	if err := db.readMeta(dbf); err != nil {
+		dbf.Close()
 		return nil, err
 	}
-
-	if _, err := tr.Next(); err != nil { // the bow db header
+	if err := db.rewind(dbf, 1<<20); err != nil {
+		dbf.Close()
 		return nil, err
 	}
-	db.fileBuf = bufio.NewReaderSize(tr, 1<<20)
 	db.file = dbf
 	return db, nil
 }
 
+// readMeta reads every member of the archive except for the entries. That
+// is, the fragment library, the encoding, the number of sessions, the IDs
+// deleted in each session and the manifest of each session. Members are
+// found by name, and every member but the segments of entries is checked
+// against the manifests.
+func (db *DB) readMeta(dbf *os.File) error {
+	db.tombstones = make(map[string]int)
+	db.manifests = make(map[int]*manifest)
+	found := make(map[string]memberSum)
+	var segments []int
+	var end int64
+
+	tr := tar.NewReader(dbf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return truncated(err)
+		}
+		dataStart, err := dbf.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		end = dataStart + (hdr.Size+511)/512*512
+
+		name := path.Base(hdr.Name)
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if session, ok := sessionOf(name, fileBowDB); ok {
+			db.session = max(db.session, session)
+			segments = append(segments, session)
+			found[name] = memberSum{Name: name, Size: hdr.Size}
+			continue
+		}
+		if session, ok := sessionOf(name, fileInverted); ok {
+			db.session = max(db.session, session)
+			db.invertedStored = true
+			found[name] = memberSum{Name: name, Size: hdr.Size}
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("Could not read member '%s' of BOW database "+
+				"'%s': %s", name, db.Name, truncated(err))
+		}
+		found[name] = newMemberSum(name, data)
+		switch {
+		case name == fileFragLib:
+			if db.Lib, err = fragbag.Open(bytes.NewReader(data)); err != nil {
+				return err
+			}
+		case name == fileEncoding:
+			if db.Encoding, err = bow.NewEncoding(string(data)); err != nil {
+				return err
+			}
+		default:
+			if session, ok := sessionOf(name, fileTombstones); ok {
+				db.session = max(db.session, session)
+				err := db.readTombstones(bytes.NewReader(data), session)
+				if err != nil {
+					return err
+				}
+			} else if session, ok := sessionOf(name, fileManifest); ok {
+				db.session = max(db.session, session)
+				if err := db.readManifest(data, session); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := checkTrailer(dbf, db.Name, end); err != nil {
+		return err
+	}
+	if db.Lib == nil {
+		return fmt.Errorf("BOW database '%s' has no fragment library.",
+			db.Name)
+	}
+	if err := db.checkManifests(found, segments); err != nil {
+		return err
+	}
+	db.lastSession = db.session
+	return nil
+}
+
+// readTombstones reads a list of deleted IDs, one per line.
+func (db *DB) readTombstones(r io.Reader, session int) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		id := scanner.Text()
+		if len(id) > 0 && db.tombstones[id] < session {
+			db.tombstones[id] = session
+		}
+	}
+	return scanner.Err()
+}
+
+// rewind positions the reader at the first entry of the database.
+func (db *DB) rewind(dbf *os.File, bufSize int) error {
+	if _, err := dbf.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	db.tr = tar.NewReader(dbf)
+	db.fileBuf = bufio.NewReaderSize(strings.NewReader(""), bufSize)
+	_, err := db.nextSegment()
+	return err
+}
+
+// nextSegment positions the reader at the first entry of the next segment
+// of entries in the archive. It returns false if there are no more segments.
+func (db *DB) nextSegment() (bool, error) {
+	for {
+		hdr, err := db.tr.Next()
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		if session, ok := sessionOf(path.Base(hdr.Name), fileBowDB); ok {
+			db.session = session
+			db.startSegment()
+			return true, nil
+		}
+	}
+}
+
+// deleted returns true if an entry with the ID given in the segment
+// currently being read has been deleted in a later session.
+func (db *DB) deleted(id string) bool {
+	return db.tombstones[id] > db.session
+}
+
+// sessionOf returns the session of an archive member named after base.
+// The member of session 0 is named base, while the member of session N is
+// named base.N.
+func sessionOf(name, base string) (int, bool) {
+	if name == base {
+		return 0, true
+	}
+	if !strings.HasPrefix(name, base+".") {
+		return 0, false
+	}
+	session, err := strconv.Atoi(name[len(base)+1:])
+	if err != nil || session <= 0 {
+		return 0, false
+	}
+	return session, true
+}
+
+// memberName returns the name of the archive member named after base for
+// the given session. It is the inverse of sessionOf.
+func memberName(base string, session int) string {
+	if session == 0 {
+		return base
+	}
+	return fmt.Sprintf("%s.%d", base, session)
+}
+
 // ReadAll reads all entries from disk and returns them in a slice.
 // Subsequent calls do not read from disk; the already read entries are
 // returned.
 //
+// If reading fails (e.g., because a segment of the database is corrupt), no
+// entries are kept, and this and every subsequent call return the same
+// error. (See Err.)
+//
 // ReadAll will panic if it is called on a database that was made with the
 // Create function.
 func (db *DB) ReadAll() ([]bow.Bowed, error) {
@@ -108,30 +326,112 @@ func (db *DB) ReadAll() ([]bow.Bowed, error) {
 	db.readAllLock.Lock()
 	defer db.readAllLock.Unlock()
 
+	if db.readErr != nil {
+		return nil, db.readErr
+	}
 	if db.Entries != nil {
 		return db.Entries, nil
 	}
-	db.Entries = make([]bow.Bowed, 0, 10000)
+	entries := make([]bow.Bowed, 0, 10000)
 	for {
 		entry, err := db.read()
 		if err == io.EOF {
 			break
 		} else if err != nil {
+			db.readErr = err
 			return nil, err
 		}
-		db.Entries = append(db.Entries, *entry)
+		entries = append(entries, *entry)
 	}
+	db.Entries = entries
 	return db.Entries, nil
 }
 
+// ReadAllQuantized is like ReadAll, except entries are kept in their
+// quantized encoding, which uses less memory. The entries are stored in
+// QuantizedEntries. Search uses these entries for a database with a
+// quantized encoding.
+//
+// ReadAllQuantized will panic if it is called on a database that was made
+// with the Create function, or if the database's encoding is
+// EncodingFloat32.
+func (db *DB) ReadAllQuantized() ([]bow.QuantizedBowed, error) {
+	if db.readAllLock == nil {
+		panic("DB.ReadAllQuantized cannot be called when the database is " +
+			"being written")
+	}
+	if db.Encoding == bow.EncodingFloat32 {
+		panic("DB.ReadAllQuantized cannot be called on a database with " +
+			"float32 frequencies")
+	}
+
+	db.readAllLock.Lock()
+	defer db.readAllLock.Unlock()
+
+	if db.readErr != nil {
+		return nil, db.readErr
+	}
+	if db.QuantizedEntries != nil {
+		return db.QuantizedEntries, nil
+	}
+	entries := make([]bow.QuantizedBowed, 0, 10000)
+	for {
+		entry, err := db.readQuantized()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			db.readErr = err
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	db.QuantizedEntries = entries
+	return db.QuantizedEntries, nil
+}
+
+// Err returns the error that stopped ReadAll or ReadAllQuantized from
+// reading the entries of the database, if any.
+//
+// Methods that read every entry into memory when necessary return this
+// error if they can (e.g., SearchId and WriteDistanceMatrix). Those that
+// cannot (Search, SearchBatch, Get, Has and WithPrefix) behave as if the
+// database had no entries, so Err should be checked after calling them.
+func (db *DB) Err() error {
+	if db.readAllLock == nil {
+		return nil
+	}
+	db.readAllLock.Lock()
+	defer db.readAllLock.Unlock()
+	return db.readErr
+}
+
 // Create creates a new BOW database on disk at 'dir'. If the directory
 // already exists or cannot be created, an error is returned.
 //
-// When you're finished adding entries, you must call Close.
+// When you're finished adding entries, you must call Close. Entries are
+// written to a temporary file in the same directory until then, and are
+// copied into the database when it is closed. If Close returns an error,
+// the database may be incomplete.
 //
-// Once a BOW database is created, it cannot be modified. (This restriction
-// may be lifted in the future.)
+// Once a BOW database is created, entries can be added, deleted or replaced
+// with OpenAppend.
 func Create(lib fragbag.Library, fpath string) (*DB, error) {
+	return CreateEncoded(lib, fpath, bow.EncodingFloat32)
+}
+
+// CreateEncoded is like Create, except the fragment frequencies of every
+// entry are stored with the encoding given. A quantized encoding makes the
+// database smaller on disk and, when read with ReadAllQuantized, in memory.
+// (See the documentation of bow.Encoding for the error bounds of each
+// encoding.)
+//
+// Entries that cannot be represented with the encoding are not added, and
+// an error is logged. No encoding can represent negative frequencies.
+func CreateEncoded(
+	lib fragbag.Library,
+	fpath string,
+	enc bow.Encoding,
+) (*DB, error) {
 	if _, err := os.Stat(fpath); err == nil || !os.IsNotExist(err) {
 		return nil, fmt.Errorf("BOW database '%s' already exists.", fpath)
 	}
@@ -139,54 +439,88 @@ func Create(lib fragbag.Library, fpath string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	segment, err := newSegmentWriter(fpath)
+	if err != nil {
+		outf.Close()
+		os.Remove(fpath)
+		return nil, err
+	}
 
 	db := &DB{
-		Lib:  lib,
-		Name: path.Base(fpath),
+		Lib:      lib,
+		Name:     path.Base(fpath),
+		Encoding: enc,
 
 		tw:          tar.NewWriter(outf),
-		saveBuf:     new(bytes.Buffer),
+		segment:     segment,
 		writeBuf:    new(bytes.Buffer),
 		entryChan:   make(chan bow.Bowed),
 		writingDone: make(chan struct{}),
 	}
+	if err := db.writeHeader(); err != nil {
+		segment.remove()
+		outf.Close()
+		os.Remove(fpath)
+		return nil, err
+	}
 
+	db.startWriting()
+	db.file = outf
+	return db, nil
+}
+
+// writeHeader writes the members that start a new database: the directory
+// of the archive, the fragment library and the encoding.
+func (db *DB) writeHeader() error {
 	// Put all bow DB files in a directory within the archive.
 	hdrDir := db.newHdrDir(db.dirName())
 	if err := db.tw.WriteHeader(hdrDir); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Create an entry for the fragment library. Copy the bytes.
 	flibBytes := new(bytes.Buffer)
 	if err := fragbag.Save(flibBytes, db.Lib); err != nil {
-		return nil, fmt.Errorf("Could not copy fragment library: %s", err)
+		return fmt.Errorf("Could not copy fragment library: %s", err)
 	}
-	hdr := db.newHdr(fileFragLib, flibBytes.Len())
-	if err := db.tw.WriteHeader(hdr); err != nil {
-		return nil, err
+	if err := db.writeMember(fileFragLib, flibBytes.Bytes()); err != nil {
+		return err
 	}
-	if _, err := db.tw.Write(flibBytes.Bytes()); err != nil {
-		return nil, err
+
+	// Databases with float32 frequencies have no encoding entry, so that
+	// they can be read by older versions of this package.
+	if db.Encoding != bow.EncodingFloat32 {
+		enc := []byte(db.Encoding.String())
+		if err := db.writeMember(fileEncoding, enc); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Now spin up a goroutine that is responsible for writing entries.
+// startWriting spins up a goroutine that is responsible for writing entries.
+func (db *DB) startWriting() {
+	db.deleteLock = new(sync.Mutex)
 	go func() {
 		for entry := range db.entryChan {
-			if err = db.write(entry); err != nil {
-				log.Printf("Could not write to %s: %s", fileBowDB, err)
+			if err := db.write(entry); err != nil {
+				log.Printf("Could not write to %s: %s",
+					memberName(fileBowDB, db.session), err)
+			} else {
+				db.numWritten++
 			}
 		}
 		db.writingDone <- struct{}{}
 	}()
-	db.file = outf
-	return db, nil
 }
 
 // Add will add a row to the database. It is safe to call `Add` from multiple
 // goroutines. The bowed value given must have been computed with the fragment
 // library given to Create.
 //
+// Entries with negative frequencies cannot be stored. They are not added,
+// and an error is logged.
+//
 // Add will panic if it is called on a BOW database that has been opened for
 // reading.
 func (db *DB) Add(e bow.Bowed) {
@@ -202,22 +536,44 @@ func (db *DB) Close() error {
 		close(db.entryChan)
 		<-db.writingDone
 
-		hdr := db.newHdr(fileBowDB, db.saveBuf.Len())
-		if err := db.tw.WriteHeader(hdr); err != nil {
-			return fmt.Errorf("Could not write TAR header for bow db: %s", err)
+		err := db.finishSession()
+		if rerr := db.segment.remove(); err == nil && rerr != nil {
+			err = fmt.Errorf("Could not remove temporary file: %s", rerr)
 		}
-		if _, err := db.tw.Write(db.saveBuf.Bytes()); err != nil {
-			return fmt.Errorf("Could not write contents of bow db: %s", err)
+		if err != nil {
+			db.file.Close()
+			return err
 		}
-
-		if err := db.tw.Close(); err != nil {
-			return fmt.Errorf("Could not close bowdb archive: %s", err)
+	}
+	if db.v2 != nil && db.v2.unmap != nil {
+		if err := db.v2.unmap(); err != nil {
+			return err
 		}
+		db.v2.unmap = nil
 	}
 	//return nil
 	return db.file.Close()
 }
 
+// finishSession writes the segment, the deleted IDs and the manifest of this
+// session, and then ends the archive.
+func (db *DB) finishSession() error {
+	name := memberName(fileBowDB, db.session)
+	if err := db.writeSegment(name); err != nil {
+		return fmt.Errorf("Could not write bow db: %s", err)
+	}
+	if err := db.writeTombstones(); err != nil {
+		return err
+	}
+	if err := db.writeManifest(); err != nil {
+		return err
+	}
+	if err := db.tw.Close(); err != nil {
+		return fmt.Errorf("Could not close bowdb archive: %s", err)
+	}
+	return nil
+}
+
 // String returns the name of the database.
 func (db *DB) String() string {
 	return db.Name
@@ -263,34 +619,140 @@ func max(a, b int) int {
 // there is a fair bit of allocation going on in the binary package.)
 // Benchmarks are gone in the wind...
 func (db *DB) read() (*bow.Bowed, error) {
+	id, rawData, rawBow, err := db.readRaw()
+	if err != nil {
+		return nil, err
+	}
+	data := db.newData(len(rawData))
+	copy(data, rawData)
 
-	// Read in the id string.
-	if err := db.readItem(); err != nil {
+	freqs := db.newBow()
+	if err := db.decodeFreqs(rawBow, freqs); err != nil {
 		return nil, err
 	}
-	id := string(db.entryBuf)
+	return &bow.Bowed{Id: id, Data: data, Bow: bow.Bow{Freqs: freqs}}, nil
+}
+
+// readRaw reads the id, data and BOW items of the next entry that hasn't
+// been deleted, without decoding the BOW. The data and BOW returned are only
+// valid until the next read.
+//
+// io.EOF is returned only when there are no more entries. If the archive
+// ends in the middle of an entry, io.ErrUnexpectedEOF is returned.
+func (db *DB) readRaw() (string, []byte, []byte, error) {
+	if db.v2 != nil {
+		return db.readV2Raw()
+	}
+	for {
+		// Read in the id string, moving on to the next segment if
+		// this one is exhausted.
+		if err := db.readItem(); err == io.EOF {
+			if err := db.endSegment(); err != nil {
+				return "", nil, nil, err
+			}
+			if more, err := db.nextSegment(); err != nil {
+				return "", nil, nil, err
+			} else if more {
+				continue
+			}
+			return "", nil, nil, io.EOF
+		} else if err != nil {
+			return "", nil, nil, err
+		}
+		id := string(db.entryBuf)
+
+		// Read in the arbitrary data.
+		if err := db.readItem(); err != nil {
+			return "", nil, nil, truncated(err)
+		}
+		db.rawData = append(db.rawData[:0], db.entryBuf...)
+
+		// Now read in the BOW.
+		if err := db.readItem(); err != nil {
+			return "", nil, nil, truncated(err)
+		}
+		db.segEntries++
+		if db.deleted(id) {
+			continue
+		}
+		return id, db.rawData, db.entryBuf, nil
+	}
+}
+
+// truncated converts an EOF in the middle of an entry to an error.
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
 
-	// Read in the arbitrary data.
-	if err := db.readItem(); err != nil {
+// decodeFreqs decodes a sparse BOW into freqs, which must have length equal
+// to the size of the database's fragment library and must be all zeros.
+func (db *DB) decodeFreqs(buf []byte, freqs []float32) error {
+	if db.Encoding == bow.EncodingScalar8 && len(buf) < 4 {
+		return fmt.Errorf("Quantized BOW is missing its scale.")
+	}
+	sparseFreqs(db.Encoding, buf, func(frag int, freq float32) {
+		freqs[frag] = freq
+	})
+	return nil
+}
+
+// readQuantized is like read, except the BOW of the entry is kept in the
+// database's quantized encoding.
+func (db *DB) readQuantized() (*bow.QuantizedBowed, error) {
+	id, rawData, rawBow, err := db.readRaw()
+	if err != nil {
 		return nil, err
 	}
-	data := db.newData(len(db.entryBuf))
-	copy(data, db.entryBuf)
+	data := db.newData(len(rawData))
+	copy(data, rawData)
 
-	// Now read in the BOW.
-	if err := db.readItem(); err != nil {
+	q, err := db.decodeQuantized(rawBow)
+	if err != nil {
 		return nil, err
 	}
+	return &bow.QuantizedBowed{Id: id, Data: data, Bow: q}, nil
+}
 
-	freqs := db.newBow()
-	// Advance 6 bytes at a time. 2 bytes for the fragment index and
-	// 4 bytes for the fragment frequency.
-	for i := 0; i < len(db.entryBuf); i += 6 {
-		fragi := binary.BigEndian.Uint16(db.entryBuf[i : i+2])
-		freqs[fragi] = math.Float32frombits(
-			binary.BigEndian.Uint32(db.entryBuf[i+2 : i+6]))
+// decodeQuantized decodes a sparse quantized BOW. Every pair has 2 bytes for
+// the fragment index followed by 1 byte (EncodingUint8 and EncodingScalar8)
+// or 2 bytes (EncodingUint16) for the quantized frequency. With
+// EncodingScalar8, the pairs are preceded by a 4 byte float32 scale. Pairs
+// are written in order of fragment index, so they are kept in that order.
+func (db *DB) decodeQuantized(buf []byte) (bow.QuantizedBow, error) {
+	q := bow.QuantizedBow{
+		Encoding: db.Encoding,
+		Scale:    1,
+		Size:     db.Lib.Size(),
+	}
+	switch db.Encoding {
+	case bow.EncodingUint8, bow.EncodingScalar8:
+		if db.Encoding == bow.EncodingScalar8 {
+			if len(buf) < 4 {
+				return q, fmt.Errorf("Quantized BOW is missing its scale.")
+			}
+			q.Scale = math.Float32frombits(binary.BigEndian.Uint32(buf[0:4]))
+			buf = buf[4:]
+		}
+		n := len(buf) / 3
+		q.Frags, q.U8 = db.newU16(n), db.newU8(n)
+		for k := 0; k < n; k++ {
+			q.Frags[k] = binary.BigEndian.Uint16(buf[3*k : 3*k+2])
+			q.U8[k] = buf[3*k+2]
+		}
+	case bow.EncodingUint16:
+		n := len(buf) / 4
+		q.Frags, q.U16 = db.newU16(n), db.newU16(n)
+		for k := 0; k < n; k++ {
+			q.Frags[k] = binary.BigEndian.Uint16(buf[4*k : 4*k+2])
+			q.U16[k] = binary.BigEndian.Uint16(buf[4*k+2 : 4*k+4])
+		}
+	default:
+		panic(fmt.Sprintf("Unrecognized quantized encoding: %s", db.Encoding))
 	}
-	return &bow.Bowed{Id: id, Data: data, Bow: bow.Bow{freqs}}, nil
+	return q, nil
 }
 
 func (db *DB) newBow() []float32 {
@@ -304,6 +766,29 @@ func (db *DB) newBow() []float32 {
 	return b
 }
 
+// newU8 and newU16 return slices of quantized values for n non-zero
+// fragment frequencies from a memory pool. The pools are sized so that each
+// holds the sparse frequencies of many entries.
+func (db *DB) newU8(n int) []uint8 {
+	if db.u8Last+n > cap(db.u8Pool) {
+		db.u8Pool = make([]uint8, max(n, db.Lib.Size()*1000))
+		db.u8Last = 0
+	}
+	b := db.u8Pool[db.u8Last : db.u8Last+n : db.u8Last+n]
+	db.u8Last += n
+	return b
+}
+
+func (db *DB) newU16(n int) []uint16 {
+	if db.u16Last+n > cap(db.u16Pool) {
+		db.u16Pool = make([]uint16, max(n, db.Lib.Size()*1000))
+		db.u16Last = 0
+	}
+	b := db.u16Pool[db.u16Last : db.u16Last+n : db.u16Last+n]
+	db.u16Last += n
+	return b
+}
+
 func (db *DB) newData(size int) []byte {
 	if size == 0 {
 		return nil
@@ -324,7 +809,7 @@ func (db *DB) readItem() error {
 	itemLen := binary.BigEndian.Uint32(db.entryBuf)
 
 	if err := db.readNBytes(int(itemLen)); err != nil {
-		return err
+		return truncated(err)
 	}
 	return nil
 }
@@ -338,7 +823,10 @@ func (db *DB) readNBytes(n int) error {
 	nread := 0
 	for nread < n {
 		if thisn, err := db.fileBuf.Read(db.entryBuf[nread:]); err != nil {
-			if err == io.EOF {
+			if err == io.EOF && nread+thisn > 0 {
+				// The item was cut short.
+				return io.ErrUnexpectedEOF
+			} else if err == io.EOF {
 				return io.EOF
 			}
 			return fmt.Errorf("Error reading item: %s", err)
@@ -355,6 +843,25 @@ func (db *DB) readNBytes(n int) error {
 func (db *DB) write(entry bow.Bowed) error {
 	libSize := db.Lib.Size()
 
+	// Quantize first, so that nothing is written for entries that cannot
+	// be represented with the database's encoding.
+	// Only positive frequencies are stored, so a BOW with a negative
+	// frequency (e.g., from bow.Bow.Sub) is rejected rather than changed.
+	var q bow.QuantizedBow
+	if db.Encoding != bow.EncodingFloat32 {
+		var err error
+		if q, err = bow.Quantize(entry.Bow, db.Encoding); err != nil {
+			return fmt.Errorf("Error writing BOW '%s': %s", entry.Id, err)
+		}
+	} else {
+		for i, f := range entry.Bow.Freqs {
+			if f < 0 {
+				return fmt.Errorf("Error writing BOW '%s': Frequency %f of "+
+					"fragment %d is negative.", entry.Id, f, i)
+			}
+		}
+	}
+
 	db.writeBuf.WriteString(entry.Id)
 	if err := db.writeItem(); err != nil {
 		return err
@@ -366,6 +873,12 @@ func (db *DB) write(entry bow.Bowed) error {
 	}
 
 	// Store BOWs as sparse frequency vectors.
+	if db.Encoding != bow.EncodingFloat32 {
+		if err := db.writeQuantized(entry.Id, q); err != nil {
+			return err
+		}
+		return db.writeItem()
+	}
 	for i := 0; i < libSize; i++ {
 		f := entry.Bow.Freqs[i]
 		if f > 0 {
@@ -383,12 +896,35 @@ func (db *DB) write(entry bow.Bowed) error {
 	return nil
 }
 
+// writeQuantized writes a quantized BOW to the write buffer. (See decodeQuantized for the format.)
+func (db *DB) writeQuantized(id string, q bow.QuantizedBow) error {
+	if db.Encoding == bow.EncodingScalar8 {
+		if err := binw(db.writeBuf, q.Scale); err != nil {
+			return fmt.Errorf("Error writing BOW '%s': %s", id, err)
+		}
+	}
+	for k, frag := range q.Frags {
+		err := binw(db.writeBuf, frag)
+		if err == nil {
+			if db.Encoding == bow.EncodingUint16 {
+				err = binw(db.writeBuf, q.U16[k])
+			} else {
+				err = binw(db.writeBuf, q.U8[k])
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Error writing BOW '%s': %s", id, err)
+		}
+	}
+	return nil
+}
+
 func (db *DB) writeItem() error {
 	itemLen := uint32(db.writeBuf.Len())
-	if err := binw(db.saveBuf, itemLen); err != nil {
+	if err := binw(db.segment, itemLen); err != nil {
 		return fmt.Errorf("Could not write item size: %s", err)
 	}
-	if _, err := db.saveBuf.Write(db.writeBuf.Bytes()); err != nil {
+	if _, err := db.segment.Write(db.writeBuf.Bytes()); err != nil {
 		return fmt.Errorf("Could not write item: %s", err)
 	}
 	db.writeBuf.Reset()
@@ -0,0 +1,167 @@
+package bowdb
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag"
+	"github.com/yunwilliamyu/esfragbag/bow"
+	"github.com/yunwilliamyu/esfragbag/internal/fragtest"
+)
+
+// testEncodings is every encoding of fragment frequencies.
+var testEncodings = []bow.Encoding{
+	bow.EncodingFloat32, bow.EncodingUint8,
+	bow.EncodingUint16, bow.EncodingScalar8,
+}
+
+// testLibrary returns the structure library used by the tests in this
+// repository, or skips the test if FRAGLIB_PATH isn't set.
+func testLibrary(t *testing.T) fragbag.Library {
+	return fragtest.Library(t)
+}
+
+// testEntries returns entries over every fragment of the library, with
+// frequencies that every encoding can represent exactly.
+func testEntries(rng *rand.Rand, n int, lib fragbag.Library) []bow.Bowed {
+	entries := randomEntries(rng, n, lib.Size())
+	for i := range entries {
+		if i%3 == 0 {
+			entries[i].Data = []byte{byte(i), byte(i >> 8)}
+		}
+	}
+	return entries
+}
+
+// createTestDB writes a BOW database with the entries given to fpath.
+func createTestDB(
+	t *testing.T,
+	lib fragbag.Library,
+	fpath string,
+	enc bow.Encoding,
+	entries []bow.Bowed,
+) {
+	db, err := CreateEncoded(lib, fpath, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		db.Add(e)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deleteIds deletes the IDs given from the database at fpath in a new
+// session.
+func deleteIds(t *testing.T, lib fragbag.Library, fpath string, ids ...string) {
+	db, err := OpenAppend(lib, fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		db.Delete(id)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readAll returns every entry of the database at fpath.
+func readAll(t *testing.T, fpath string) []bow.Bowed {
+	db, err := Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	entries, err := db.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestNegativeFrequencies(t *testing.T) {
+	lib := testLibrary(t)
+	pos := bow.NewBow(lib.Size())
+	pos.Freqs[0], pos.Freqs[1] = 255, 1
+	neg := bow.NewBow(lib.Size()).Sub(pos)
+
+	for _, enc := range testEncodings {
+		dir, err := ioutil.TempDir("", "bowdb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		dbPath := filepath.Join(dir, "test.bowdb")
+		createTestDB(t, lib, dbPath, enc, []bow.Bowed{
+			{Id: "neg", Bow: neg},
+			{Id: "pos", Bow: pos},
+		})
+
+		got := readAll(t, dbPath)
+		if len(got) != 1 || got[0].Id != "pos" || !got[0].Bow.Equal(pos) {
+			t.Fatalf("%s: expected only the positive BOW, but got %v.",
+				enc, got)
+		}
+	}
+}
+
+func TestReadAllQuantized(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(rand.New(rand.NewSource(3)), 500, lib)
+
+	encodings := []bow.Encoding{
+		bow.EncodingUint8, bow.EncodingUint16, bow.EncodingScalar8,
+	}
+	for _, enc := range encodings {
+		dir, err := ioutil.TempDir("", "bowdb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		dbPath := filepath.Join(dir, "test.bowdb")
+		createTestDB(t, lib, dbPath, enc, entries)
+
+		db, err := Open(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		qs, err := db.ReadAllQuantized()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+		if len(qs) != len(entries) {
+			t.Fatalf("%s: expected %d entries but got %d.",
+				enc, len(entries), len(qs))
+		}
+		full := readAll(t, dbPath)
+		for i, q := range qs {
+			e := entries[i]
+			want, err := bow.Quantize(e.Bow, enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Id != e.Id || !reflect.DeepEqual(q.Data, e.Data) {
+				t.Fatalf("%s: expected entry '%s' but got '%s'.",
+					enc, e.Id, q.Id)
+			}
+			if !reflect.DeepEqual(q.Bow, want) {
+				t.Fatalf("%s: entry '%s' has BOW %+v, but expected %+v.",
+					enc, e.Id, q.Bow, want)
+			}
+			if !reflect.DeepEqual(q.Bowed(), full[i]) {
+				t.Fatalf("%s: entry '%s' differs from the entry read by "+
+					"ReadAll.", enc, e.Id)
+			}
+		}
+	}
+}
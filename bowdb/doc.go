@@ -4,13 +4,54 @@ of Bowed values. Bowed values correspond to a bag-of-words (BOW) along with
 meta data about the value on which the BOW was computed (like a PDB chain
 identifier or SCOP domain).
 
-While reading a database and searching it has been heavily optimized, the
-search itself is still exhaustive. No attempt has been made yet at constructing
-a reverse index.
+While reading a database and searching it has been heavily optimized, Search
+itself is exhaustive. SearchIndexed returns the same results using an inverted
+index (fragment number to the entries it occurs in), which is either built in
+memory or stored in the database with WriteInvertedIndex.
 
 Every BOW database is associated with one and only one fragment library. When a
 BOW database is saved, a copy of the fragment library is embedded into the
 database. This library---and only this library---should be used to compute
 Bowed values for use with the Search function.
+
+Fragment frequencies are stored as float32 values by default. Databases made
+with CreateEncoded may instead store them with a quantized encoding (see
+bow.Encoding), which is recorded in the database and lets large databases fit
+in memory.
+
+Search results can be restricted with a Filter in the search options (e.g.,
+to exclude hits from the same PDB entry as the query). Filters are applied
+while searching, so they never take up room in a search's Limit.
+
+The statistical significance of hits (a z-score, p-value and E-value) can be
+estimated by fitting a null distribution of distances to each query, either
+from the distances to every entry or from shuffled decoys of the query (see
+NullModel). Results can then be thresholded by E-value (MaxEValue) and the
+results of different searches sorted with SortBySignificance.
+
+Many queries can be run at once with SearchBatch, and the distances between
+every pair of entries can be written with WriteDistanceMatrix.
+
+Entries can be looked up by ID (Get, Has and WithPrefix), and any entry can
+be used as a query without recomputing its BOW (SearchId).
+
+An existing database can be modified with OpenAppend, which appends new
+entries and records the IDs of deleted entries (tombstones) at the end of the
+database. Compact rewrites a database without its deleted entries.
+
+A database can be converted to format version 2 with Convert. A version 2
+database is a single file that is mapped into memory, so it opens almost
+instantly and can be searched without reading its entries. It cannot be
+modified. Open reads both versions.
+
+Every append to a version 1 database ends with a manifest that records the
+format version, the number of entries added and a checksum of every member
+written. Open rejects databases that are truncated, have an unknown format
+version or whose members don't match their manifests, and entries are checked
+against their manifest as they are read. DB.Verify checks an entire database
+of either version without loading it.
+
+Databases too big to fit in memory can be scanned one entry at a time with
+an Iterator (see DB.Iter) and searched with SearchStream.
 */
 package bowdb
@@ -0,0 +1,116 @@
+package bowdb
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// Filter restricts the entries that may be returned by a search. Filters are
+// evaluated while the database is scanned, so that entries rejected by a
+// filter never count toward a search's Limit.
+//
+// The zero value of Filter accepts every entry. When more than one criterion
+// is set, an entry must satisfy all of them.
+type Filter struct {
+	// ExcludeSelf excludes entries with the same ID as the query.
+	ExcludeSelf bool
+
+	// ExcludeSameEntry excludes entries from the same PDB entry as the
+	// query. (e.g., "1ctfA" excludes "1ctfB" and "1ctfA00".) See PdbEntry.
+	ExcludeSameEntry bool
+
+	// Include, when not nil, excludes every entry whose ID it doesn't match.
+	Include *regexp.Regexp
+
+	// Exclude, when not nil, excludes every entry whose ID it matches.
+	Exclude *regexp.Regexp
+
+	// MinFragments excludes entries whose BOW has fewer than MinFragments
+	// distinct fragments (i.e., non-zero frequencies).
+	MinFragments int
+
+	// MaxFragments excludes entries whose BOW has more than MaxFragments
+	// distinct fragments. A value of 0 means there is no maximum.
+	MaxFragments int
+
+	// Data, when not nil, excludes every entry for which it returns false
+	// when given the entry's Data. It must be safe to call from multiple
+	// goroutines, and it must not modify or retain the slice given.
+	Data func(data []byte) bool
+}
+
+// isZero returns true if the filter accepts every entry.
+func (f Filter) isZero() bool {
+	return !f.ExcludeSelf && !f.ExcludeSameEntry &&
+		f.Include == nil && f.Exclude == nil &&
+		f.MinFragments <= 0 && f.MaxFragments <= 0 &&
+		f.Data == nil
+}
+
+// accepter returns a function that reports whether an entry passes the
+// filter for the query given, or nil if every entry passes. Cheap criteria
+// are checked first.
+func (f Filter) accepter(query bow.Bowed) func(entry bow.Bowed) bool {
+	if f.isZero() {
+		return nil
+	}
+	queryEntry := PdbEntry(query.Id)
+	return func(entry bow.Bowed) bool {
+		if f.ExcludeSelf && entry.Id == query.Id {
+			return false
+		}
+		if f.ExcludeSameEntry && len(queryEntry) > 0 &&
+			PdbEntry(entry.Id) == queryEntry {
+			return false
+		}
+		if f.MinFragments > 0 || f.MaxFragments > 0 {
+			n := numFragments(entry.Bow)
+			if n < f.MinFragments {
+				return false
+			}
+			if f.MaxFragments > 0 && n > f.MaxFragments {
+				return false
+			}
+		}
+		if f.Include != nil && !f.Include.MatchString(entry.Id) {
+			return false
+		}
+		if f.Exclude != nil && f.Exclude.MatchString(entry.Id) {
+			return false
+		}
+		if f.Data != nil && !f.Data(entry.Data) {
+			return false
+		}
+		return true
+	}
+}
+
+// PdbEntry returns the lowercase four character PDB identifier of the ID of
+// a chain (e.g., "1ctfA"), a CATH domain (e.g., "1ctfA00") or a SCOP domain
+// (e.g., "d1ctfa_"). An empty string is returned if the ID is too short to
+// contain a PDB identifier.
+func PdbEntry(id string) string {
+	// SCOP domain identifiers start with a 'd' or an 'e', while PDB
+	// identifiers always start with a digit.
+	if len(id) > 4 && (id[0] == 'd' || id[0] == 'e') &&
+		id[1] >= '0' && id[1] <= '9' {
+		id = id[1:]
+	}
+	if len(id) < 4 {
+		return ""
+	}
+	return strings.ToLower(id[0:4])
+}
+
+// numFragments returns the number of fragments with a non-zero frequency.
+func numFragments(b bow.Bow) int {
+	n := 0
+	for _, f := range b.Freqs {
+		if f != 0 {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,113 @@
+package bowdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+func TestPdbEntry(t *testing.T) {
+	tests := []struct {
+		id, entry string
+	}{
+		{"1ctf", "1ctf"},
+		{"1CTFA", "1ctf"},
+		{"1ctfA00", "1ctf"},
+		{"d1ctfa_", "1ctf"},
+		{"e1ctfA1", "1ctf"},
+		{"dog", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := PdbEntry(test.id); got != test.entry {
+			t.Errorf("PdbEntry(%q) = %q, want %q", test.id, got, test.entry)
+		}
+	}
+}
+
+func TestSearchFilter(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	entries := randomEntries(rng, 300, 10)
+	for i := range entries {
+		entries[i].Id = fmt.Sprintf("%d%03d%c", 1+i%7, i/3, 'A'+byte(i%3))
+		entries[i].Data = []byte{byte(i % 4)}
+	}
+	query := entries[5]
+
+	tests := []struct {
+		name   string
+		filter Filter
+		accept func(e bow.Bowed) bool
+	}{
+		{
+			"self",
+			Filter{ExcludeSelf: true},
+			func(e bow.Bowed) bool { return e.Id != query.Id },
+		},
+		{
+			"same entry",
+			Filter{ExcludeSameEntry: true},
+			func(e bow.Bowed) bool { return e.Id[:4] != query.Id[:4] },
+		},
+		{
+			"include",
+			Filter{Include: regexp.MustCompile("^[12]")},
+			func(e bow.Bowed) bool { return e.Id[0] == '1' || e.Id[0] == '2' },
+		},
+		{
+			"exclude",
+			Filter{Exclude: regexp.MustCompile("B$")},
+			func(e bow.Bowed) bool { return e.Id[len(e.Id)-1] != 'B' },
+		},
+		{
+			"fragments",
+			Filter{MinFragments: 2, MaxFragments: 2},
+			func(e bow.Bowed) bool { return numFragments(e.Bow) == 2 },
+		},
+		{
+			"data",
+			Filter{Data: func(d []byte) bool { return bytes.Equal(d, []byte{1}) }},
+			func(e bow.Bowed) bool { return e.Data[0] == 1 },
+		},
+	}
+	for _, test := range tests {
+		for _, limit := range []int{-1, 1, 10, 1000} {
+			opts := SearchOptions{
+				Limit:   limit,
+				Min:     0,
+				Max:     math.MaxFloat64,
+				SortBy:  SortByCosine,
+				Order:   OrderAsc,
+				Workers: 4,
+			}
+
+			// The expected results are found by over-fetching every entry
+			// and filtering afterwards.
+			unlimited := opts
+			unlimited.Limit = -1
+			all := bruteForce(unlimited, query, entries)
+			var want []SearchResult
+			for _, r := range all {
+				if test.accept(r.Bowed) && (limit < 0 || len(want) < limit) {
+					want = append(want, r)
+				}
+			}
+			if len(want) == 0 {
+				t.Fatalf("Filter '%s' rejects every entry.", test.name)
+			}
+
+			opts.Filter = test.filter
+			got := SearchEntries(opts, query, entries)
+			if !sameResults(want, got) {
+				t.Fatalf("Search with filter '%s' (limit %d) differs from "+
+					"filtering afterwards.\nwant: %s\ngot:  %s",
+					test.name, limit, resultIds(want), resultIds(got))
+			}
+		}
+	}
+}
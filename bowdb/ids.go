@@ -0,0 +1,134 @@
+package bowdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// idIndex maps entry IDs to their positions in a database. It is sorted by
+// ID (and then by position) so that it supports both exact and prefix
+// lookups with a binary search.
+type idIndex []idPos
+
+type idPos struct {
+	id  string
+	pos int
+}
+
+func (ids idIndex) Len() int      { return len(ids) }
+func (ids idIndex) Swap(i, j int) { ids[i], ids[j] = ids[j], ids[i] }
+func (ids idIndex) Less(i, j int) bool {
+	if ids[i].id == ids[j].id {
+		return ids[i].pos < ids[j].pos
+	}
+	return ids[i].id < ids[j].id
+}
+
+// first returns the index of the first ID not less than key.
+func (ids idIndex) first(key string) int {
+	return sort.Search(len(ids), func(i int) bool { return ids[i].id >= key })
+}
+
+// loaded reads every entry into memory if necessary, and returns the number
+// of entries along with a function returning the i'th entry. Entries are read
+// in the same way as Search reads them: the entries of a version 2 database
+// are decoded from the mapped file. If the entries cannot be read, there are
+// no entries and the error is returned.
+func (db *DB) loaded() (int, func(i int) bow.Bowed, error) {
+	if db.mapped() {
+		return db.v2.n, db.v2Entry, nil
+	}
+	if db.Encoding == bow.EncodingFloat32 {
+		entries, err := db.ReadAll()
+		return len(entries), func(i int) bow.Bowed { return entries[i] }, err
+	}
+	entries, err := db.ReadAllQuantized()
+	entryAt := func(i int) bow.Bowed { return entries[i].Bowed() }
+	return len(entries), entryAt, err
+}
+
+// ids returns the ID index of the database, building it on first use. The
+// index is empty if the entries cannot be read.
+func (db *DB) ids() (idIndex, func(i int) bow.Bowed) {
+	n, entryAt, err := db.loaded()
+	if err != nil {
+		return nil, entryAt
+	}
+	db.idsOnce.Do(func() {
+		idAt := func(i int) string { return entryAt(i).Id }
+		if db.mapped() {
+			// Only the IDs are needed, so BOWs aren't decoded.
+			idAt = func(i int) string {
+				id, _, _ := db.v2.block(i)
+				return string(id)
+			}
+		}
+		db.idIndex = make(idIndex, n)
+		for i := 0; i < n; i++ {
+			db.idIndex[i] = idPos{idAt(i), i}
+		}
+		sort.Sort(db.idIndex)
+	})
+	return db.idIndex, entryAt
+}
+
+// Get returns the entry with the ID given. If there is more than one such
+// entry, the one added first is returned. If there is no such entry, the
+// second return value is false.
+//
+// Like Search, Get reads every entry of a version 1 database into memory if
+// that hasn't been done already. (Entries of a version 2 database are read
+// from the mapped file.) If they cannot be read, no entry is found and Err
+// returns the error. An index of IDs is built the first time Get, Has or
+// WithPrefix is called, which makes subsequent lookups fast. It is safe to
+// call these methods from multiple goroutines.
+func (db *DB) Get(id string) (bow.Bowed, bool) {
+	ids, entryAt := db.ids()
+	if i := ids.first(id); i < len(ids) && ids[i].id == id {
+		return entryAt(ids[i].pos), true
+	}
+	return bow.Bowed{}, false
+}
+
+// Has returns true if there is an entry with the ID given.
+func (db *DB) Has(id string) bool {
+	ids, _ := db.ids()
+	i := ids.first(id)
+	return i < len(ids) && ids[i].id == id
+}
+
+// WithPrefix returns every entry whose ID starts with the prefix given,
+// sorted by ID. For example, the prefix "1ctf" returns every chain of the PDB
+// entry 1ctf in a database of chains.
+func (db *DB) WithPrefix(prefix string) []bow.Bowed {
+	ids, entryAt := db.ids()
+	var entries []bow.Bowed
+	for i := ids.first(prefix); i < len(ids); i++ {
+		if !strings.HasPrefix(ids[i].id, prefix) {
+			break
+		}
+		entries = append(entries, entryAt(ids[i].pos))
+	}
+	return entries
+}
+
+// SearchId is like Search, except the query is the entry in this database
+// with the ID given. (Its BOW is not recomputed.) The query itself is
+// included in the results if it satisfies the search options.
+//
+// An error is returned if there is no entry with the ID given, or if the
+// entries of the database cannot be read.
+func (db *DB) SearchId(opts SearchOptions, id string) ([]SearchResult, error) {
+	query, ok := db.Get(id)
+	if err := db.Err(); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("No entry with ID '%s' in BOW database '%s'.",
+			id, db.Name)
+	}
+	return db.Search(opts, query), nil
+}
@@ -0,0 +1,110 @@
+package bowdb
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+func TestIds(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(rand.New(rand.NewSource(10)), 8, lib)
+	for i, id := range []string{
+		"1ctfB", "1ctfA", "1ctg", "1ctfA", "1ct", "2abc", "1ctf", "1cte",
+	} {
+		entries[i].Id = id
+	}
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var paths []string
+	for _, enc := range []bow.Encoding{bow.EncodingFloat32, bow.EncodingUint8} {
+		fpath := filepath.Join(dir, enc.String()+".bowdb")
+		createTestDB(t, lib, fpath, enc, entries)
+		deleteIds(t, lib, fpath, "2abc")
+		v2Path := filepath.Join(dir, enc.String()+"-v2.bowdb")
+		if err := Convert(fpath, v2Path, 2); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, fpath, v2Path)
+	}
+	for _, fpath := range paths {
+		name := filepath.Base(fpath)
+		db, err := Open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The first entry added wins when IDs are duplicated.
+		got, ok := db.Get("1ctfA")
+		if !ok || !reflect.DeepEqual(got, entries[1]) {
+			t.Fatalf("%s: expected the first entry with ID '1ctfA'.", name)
+		}
+		for _, id := range []string{"2abc", "1ctfC", "1c", "", "zzz"} {
+			if _, ok := db.Get(id); ok {
+				t.Fatalf("%s: got an entry for ID '%s'.", name, id)
+			}
+			if db.Has(id) {
+				t.Fatalf("%s: database has an entry for ID '%s'.", name, id)
+			}
+		}
+		for _, id := range []string{"1ct", "1ctf", "1ctfB", "1cte"} {
+			if !db.Has(id) {
+				t.Fatalf("%s: database has no entry for ID '%s'.", name, id)
+			}
+		}
+
+		prefixes := []struct {
+			prefix string
+			want   []bow.Bowed
+		}{
+			{"1ctf", []bow.Bowed{
+				entries[6], entries[1], entries[3], entries[0],
+			}},
+			{"1ct", []bow.Bowed{
+				entries[4], entries[7], entries[6], entries[1], entries[3],
+				entries[0], entries[2],
+			}},
+			{"1ctfA", []bow.Bowed{entries[1], entries[3]}},
+			{"2", nil},
+			{"1cu", nil},
+		}
+		for _, test := range prefixes {
+			got := db.WithPrefix(test.prefix)
+			if !reflect.DeepEqual(test.want, got) {
+				t.Fatalf("%s: prefix '%s': expected %d entries but got %d.",
+					name, test.prefix, len(test.want), len(got))
+			}
+		}
+
+		opts := SearchOptions{Limit: -1, Min: 0, Max: 2, SortBy: SortByCosine}
+		results, err := db.SearchId(opts, "1ctfA")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := db.Search(opts, entries[1]); !sameResults(want, results) {
+			t.Fatalf("%s: SearchId differs from Search:\nwant: %s\ngot:  %s",
+				name, resultIds(want), resultIds(results))
+		}
+		if _, err := db.SearchId(opts, "2abc"); err == nil {
+			t.Fatalf("%s: expected an error searching for a deleted ID.", name)
+		}
+		if _, err := db.SearchId(opts, "missing"); err == nil {
+			t.Fatalf("%s: expected an error searching for a missing ID.", name)
+		}
+		if db.Version == 2 &&
+			(db.Entries != nil || db.QuantizedEntries != nil) {
+			t.Fatalf("%s: looking up IDs read every entry.", name)
+		}
+		db.Close()
+	}
+}
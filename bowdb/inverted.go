@@ -0,0 +1,376 @@
+package bowdb
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	path "path/filepath"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+const fileInverted = "inverted"
+
+// Posting is a single entry in the posting list of a fragment.
+type Posting struct {
+	// The position of the entry in the database. (i.e., its index in
+	// Entries or QuantizedEntries.)
+	Entry int
+
+	// The frequency of the fragment in the entry's BOW.
+	Freq float32
+}
+
+// InvertedIndex maps every fragment in a database's fragment library to the
+// entries in which it occurs.
+type InvertedIndex struct {
+	// The number of entries indexed.
+	NumEntries int
+
+	// Postings has a posting list for every fragment in the library, where
+	// the fragment number is the index. Each posting list contains every
+	// entry with a non-zero frequency of the fragment, sorted by position.
+	Postings [][]Posting
+
+	session int       // The last session of the database when indexed.
+	norms   []float64 // The magnitude of every entry's BOW.
+}
+
+// newInvertedIndex builds an inverted index over numEntries entries, where
+// entryAt returns the i'th entry.
+func newInvertedIndex(
+	size, numEntries int,
+	entryAt func(i int) bow.Bowed,
+) *InvertedIndex {
+	idx := &InvertedIndex{
+		NumEntries: numEntries,
+		Postings:   make([][]Posting, size),
+	}
+	for i := 0; i < numEntries; i++ {
+		for frag, f := range entryAt(i).Bow.Freqs {
+			if f > 0 {
+				idx.Postings[frag] = append(idx.Postings[frag], Posting{i, f})
+			}
+		}
+	}
+	idx.computeNorms()
+	return idx
+}
+
+func (idx *InvertedIndex) computeNorms() {
+	idx.norms = make([]float64, idx.NumEntries)
+	for _, postings := range idx.Postings {
+		for _, p := range postings {
+			idx.norms[p.Entry] += float64(p.Freq) * float64(p.Freq)
+		}
+	}
+	for i := range idx.norms {
+		idx.norms[i] = math.Sqrt(idx.norms[i])
+	}
+}
+
+// Containing returns the position of every entry in which the given fragment
+// occurs at least min times, in order of position.
+func (idx *InvertedIndex) Containing(frag int, min float32) []int {
+	var entries []int
+	for _, p := range idx.Postings[frag] {
+		if p.Freq >= min {
+			entries = append(entries, p.Entry)
+		}
+	}
+	return entries
+}
+
+// InvertedIndex returns the inverted index of the database. If the database
+// has an up to date index stored in its archive (see WriteInvertedIndex),
+// then it is read from disk. Otherwise, it is built from the entries of the
+// database, which are read into memory if necessary. The index is only
+// computed once.
+func (db *DB) InvertedIndex() (*InvertedIndex, error) {
+	if db.readAllLock == nil {
+		panic("DB.InvertedIndex cannot be called when the database is " +
+			"being written")
+	}
+
+	db.invertedLock.Lock()
+	defer db.invertedLock.Unlock()
+
+	if db.inverted != nil {
+		return db.inverted, nil
+	}
+	if db.invertedStored {
+		idx, err := db.readInverted()
+		if err != nil {
+			return nil, err
+		}
+		if idx != nil && idx.session == db.lastSession {
+			db.inverted = idx
+			return idx, nil
+		}
+	}
+	n, entryAt, err := db.loaded()
+	if err != nil {
+		return nil, err
+	}
+	db.inverted = newInvertedIndex(db.Lib.Size(), n, entryAt)
+	db.inverted.session = db.lastSession
+	return db.inverted, nil
+}
+
+// WithFragment returns every entry in which the given fragment occurs at
+// least min times.
+func (db *DB) WithFragment(frag int, min float32) ([]bow.Bowed, error) {
+	if frag < 0 || frag >= db.Lib.Size() {
+		return nil, fmt.Errorf("Fragment %d is not in the fragment library "+
+			"'%s' (which has %d fragments).", frag, db.Lib.Name(),
+			db.Lib.Size())
+	}
+	idx, err := db.InvertedIndex()
+	if err != nil {
+		return nil, err
+	}
+	_, entryAt, err := db.loaded()
+	if err != nil {
+		return nil, err
+	}
+	var entries []bow.Bowed
+	for _, i := range idx.Containing(frag, min) {
+		entries = append(entries, entryAt(i))
+	}
+	return entries, nil
+}
+
+// cosineSlack bounds the difference between the cosine distance computed
+// from an inverted index and the cosine distance computed by Search, which
+// accumulates in float32.
+const cosineSlack = 1e-4
+
+// SearchIndexed returns exactly the same results as Search, but uses the
+// database's inverted index to avoid computing the distance between the
+// query and most entries.
+//
+// Dot products with the query are accumulated term-at-a-time from the
+// posting lists of the query's fragments. Entries that share no fragments
+// with the query have a cosine distance of exactly 1, and entries whose
+// approximate distance cannot place them in the result set are skipped.
+// Every other entry is scored exactly as Search would score it.
+//
+// Only cosine distance benefits from the index. Searches sorted by Euclidean
+// distance are delegated to Search. Estimating significance (see
+// SearchOptions.Null) still requires comparing the query with every entry.
+func (db *DB) SearchIndexed(
+	opts SearchOptions,
+	query bow.Bowed,
+) ([]SearchResult, error) {
+	if opts.SortBy != SortByCosine {
+		return db.Search(opts, query), db.Err()
+	}
+	idx, err := db.InvertedIndex()
+	if err != nil {
+		return nil, err
+	}
+	numEntries, distance, entryAt, err := db.scan(opts, query)
+	if err != nil {
+		return nil, err
+	}
+	if idx.NumEntries != numEntries {
+		return nil, fmt.Errorf("Inverted index has %d entries but BOW "+
+			"database '%s' has %d entries.", idx.NumEntries, db.Name,
+			numEntries)
+	}
+
+	opts, null := significant(opts, query, numEntries, db.distanceTo(opts))
+
+	// Term-at-a-time accumulation of dot products.
+	dots := make([]float64, numEntries)
+	overlaps := make([]bool, numEntries)
+	for frag, qf := range query.Bow.Freqs {
+		if qf == 0 {
+			continue
+		}
+		for _, p := range idx.Postings[frag] {
+			dots[p.Entry] += float64(qf) * float64(p.Freq)
+			overlaps[p.Entry] = true
+		}
+	}
+	qnorm := query.Bow.Magnitude()
+
+	hits := newCollector(opts, query)
+	hits.null = null
+	for i := 0; i < numEntries; i++ {
+		dist := 1.0
+		if overlaps[i] {
+			approx := 1.0 - dots[i]/(qnorm*idx.norms[i])
+			if !math.IsNaN(approx) && hits.beaten(approx-cosineSlack) {
+				continue
+			}
+			dist = distance(i)
+		}
+		if hits.wants(dist, i) {
+			hits.insert(entryAt(i), dist, i)
+		}
+	}
+	return hits.results(), nil
+}
+
+// WriteInvertedIndex builds an inverted index of the BOW database at fpath
+// and stores it in the database's archive, so that it doesn't need to be
+// rebuilt every time the database is opened. The index is written in a new
+// session (see OpenAppend), whose manifest records its checksum.
+//
+// A stored index becomes out of date when the database is modified with
+// OpenAppend, in which case InvertedIndex ignores it. (Compact rebuilds a
+// stored index.)
+func WriteInvertedIndex(fpath string) error {
+	db, err := Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if db.Version != 1 {
+		return errReadOnly(fpath, db.Version)
+	}
+	n, entryAt, err := db.loaded()
+	if err != nil {
+		return err
+	}
+	idx := newInvertedIndex(db.Lib.Size(), n, entryAt)
+
+	out, err := OpenAppend(db.Lib, fpath)
+	if err != nil {
+		return err
+	}
+	idx.session = out.session
+	if err := out.writeInverted(idx); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeInverted writes an inverted index as a member of the session being
+// written. The index must describe the entries of the database once the
+// session is closed.
+func (db *DB) writeInverted(idx *InvertedIndex) error {
+	buf := new(bytes.Buffer)
+	if err := idx.write(buf); err != nil {
+		return err
+	}
+	name := memberName(fileInverted, db.session)
+	if err := db.writeMember(name, buf.Bytes()); err != nil {
+		return fmt.Errorf("Could not write inverted index: %s", err)
+	}
+	return nil
+}
+
+// write writes the inverted index in a binary format: the session, the number
+// of entries and the number of fragments as uint32s, followed by the posting
+// list of every fragment. A posting list is its length as a uint32 followed
+// by (uint32 entry, float32 frequency) pairs.
+func (idx *InvertedIndex) write(w io.Writer) error {
+	header := []uint32{
+		uint32(idx.session), uint32(idx.NumEntries), uint32(len(idx.Postings)),
+	}
+	if err := binw(w, header); err != nil {
+		return err
+	}
+	for _, postings := range idx.Postings {
+		if err := binw(w, uint32(len(postings))); err != nil {
+			return err
+		}
+		for _, p := range postings {
+			if err := binw(w, uint32(p.Entry)); err != nil {
+				return err
+			}
+			if err := binw(w, p.Freq); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readInvertedIndex reads an inverted index written by write.
+func readInvertedIndex(r io.Reader) (*InvertedIndex, error) {
+	var header [3]uint32
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	idx := &InvertedIndex{
+		session:    int(header[0]),
+		NumEntries: int(header[1]),
+		Postings:   make([][]Posting, header[2]),
+	}
+
+	var pair [8]byte
+	for frag := range idx.Postings {
+		var count uint32
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			return nil, err
+		}
+		postings := make([]Posting, count)
+		for i := range postings {
+			if _, err := io.ReadFull(r, pair[:]); err != nil {
+				return nil, err
+			}
+			entry := int(binary.BigEndian.Uint32(pair[0:4]))
+			if entry >= idx.NumEntries {
+				return nil, fmt.Errorf("Inverted index refers to entry %d, "+
+					"but only has %d entries.", entry, idx.NumEntries)
+			}
+			postings[i] = Posting{
+				Entry: entry,
+				Freq:  math.Float32frombits(binary.BigEndian.Uint32(pair[4:8])),
+			}
+		}
+		idx.Postings[frag] = postings
+	}
+	idx.computeNorms()
+	return idx, nil
+}
+
+// readInverted reads the last inverted index stored in the database's
+// archive, or returns nil if there isn't one. Every stored index is checked
+// against the manifest of the session that wrote it.
+func (db *DB) readInverted() (*InvertedIndex, error) {
+	f, err := os.Open(db.file.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var idx *InvertedIndex
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return idx, nil
+		} else if err != nil {
+			return nil, err
+		}
+		name := path.Base(hdr.Name)
+		session, ok := sessionOf(name, fileInverted)
+		if !ok {
+			continue
+		}
+
+		crc := crc32.New(crcTable)
+		r := bufio.NewReader(io.TeeReader(tr, crc))
+		if idx, err = readInvertedIndex(r); err != nil {
+			return nil, fmt.Errorf("Could not read inverted index: %s", err)
+		}
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			return nil, err
+		}
+		if err := db.checkMember(session, name, crc.Sum32()); err != nil {
+			return nil, err
+		}
+	}
+}
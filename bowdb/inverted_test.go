@@ -0,0 +1,234 @@
+package bowdb
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+func TestSearchIndexed(t *testing.T) {
+	lib := testLibrary(t)
+	rng := rand.New(rand.NewSource(11))
+	entries := testEntries(rng, 400, lib)
+	queries := append(testEntries(rng, 4, lib), entries[10], entries[20])
+	empty := bow.Bowed{Id: "empty", Bow: bow.NewBow(lib.Size())}
+	queries = append(queries, empty)
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var options []SearchOptions
+	for _, order := range []int{OrderAsc, OrderDesc} {
+		for _, limit := range []int{-1, 0, 1, 10, 1000} {
+			options = append(options, SearchOptions{
+				Limit: limit, Min: 0, Max: 2,
+				SortBy: SortByCosine, Order: order,
+			})
+		}
+	}
+	options = append(options,
+		SearchOptions{Limit: -1, Min: 0.2, Max: 0.6, SortBy: SortByCosine},
+		SearchOptions{Limit: 10, Min: 0.5, Max: 1, SortBy: SortByCosine,
+			Order: OrderDesc},
+		SearchOptions{Limit: 10, Min: 0, Max: 0.99, SortBy: SortByCosine},
+		SearchOptions{Limit: 10, Min: 0, Max: 2, SortBy: SortByCosine,
+			Filter: Filter{
+				ExcludeSelf:  true,
+				Exclude:      regexp.MustCompile("1$"),
+				MinFragments: 2,
+			}},
+		SearchOptions{Limit: 10, Min: 0, Max: 2, SortBy: SortByCosine,
+			Filter: Filter{Include: regexp.MustCompile("^entry1")}},
+		SearchOptions{Limit: 5, Min: 0, Max: 100, SortBy: SortByEuclid},
+	)
+
+	for _, enc := range testEncodings {
+		fpath := filepath.Join(dir, enc.String()+".bowdb")
+		createTestDB(t, lib, fpath, enc, entries[:300])
+		if err := WriteInvertedIndex(fpath); err != nil {
+			t.Fatal(err)
+		}
+
+		check := func(state string, stale bool) {
+			db, err := Open(fpath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			stored, err := db.readInverted()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored == nil || (stored.session != db.lastSession) != stale {
+				t.Fatalf("%s: %s: expected a stored index that is stale: %v.",
+					enc, state, stale)
+			}
+			for _, opts := range options {
+				for _, query := range queries {
+					got, err := db.SearchIndexed(opts, query)
+					if err != nil {
+						t.Fatal(err)
+					}
+					want := db.Search(opts, query)
+					if !sameResults(want, got) {
+						t.Fatalf("%s: %s: SearchIndexed (%+v) differs from "+
+							"Search for query '%s':\nwant: %s\ngot:  %s",
+							enc, state, opts, query.Id,
+							resultIds(want), resultIds(got))
+					}
+				}
+			}
+		}
+		check("stored index", false)
+
+		// Modifying the database makes the stored index stale.
+		appendEntries(t, lib, fpath, func(db *DB) {
+			db.Delete(entries[0].Id)
+			db.Delete(entries[150].Id)
+			for _, e := range entries[300:] {
+				db.Add(e)
+			}
+		})
+		check("stale index", true)
+	}
+}
+
+func TestWithFragment(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(rand.New(rand.NewSource(12)), 200, lib)
+	deleted := entries[3].Id
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, enc := range []bow.Encoding{bow.EncodingFloat32, bow.EncodingUint8} {
+		fpath := filepath.Join(dir, enc.String()+".bowdb")
+		createTestDB(t, lib, fpath, enc, entries)
+		deleteIds(t, lib, fpath, deleted)
+
+		db, err := Open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all := readAll(t, fpath)
+		idx, err := db.InvertedIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for frag := 0; frag < lib.Size(); frag++ {
+			for _, min := range []float32{0, 1, 2, 3} {
+				var wantPos []int
+				var want []bow.Bowed
+				for i, e := range all {
+					if f := e.Bow.Freqs[frag]; f > 0 && f >= min {
+						wantPos = append(wantPos, i)
+						want = append(want, e)
+					}
+				}
+				gotPos := idx.Containing(frag, min)
+				if !reflect.DeepEqual(wantPos, gotPos) {
+					t.Fatalf("%s: fragment %d, min %f: expected positions %v "+
+						"but got %v.", enc, frag, min, wantPos, gotPos)
+				}
+				got, err := db.WithFragment(frag, min)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(want, got) {
+					t.Fatalf("%s: fragment %d, min %f: expected %d entries "+
+						"but got %d.", enc, frag, min, len(want), len(got))
+				}
+				for _, e := range got {
+					if e.Id == deleted {
+						t.Fatalf("%s: WithFragment returned deleted entry "+
+							"'%s'.", enc, deleted)
+					}
+				}
+			}
+		}
+		for _, frag := range []int{-1, lib.Size()} {
+			if _, err := db.WithFragment(frag, 1); err == nil {
+				t.Fatalf("%s: expected an error for fragment %d.", enc, frag)
+			}
+		}
+		db.Close()
+	}
+}
+
+// TestInvertedManifest checks that a stored inverted index is recorded in
+// the manifest of the session that wrote it, and that it is checked against
+// the manifest when it is read.
+func TestInvertedManifest(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(rand.New(rand.NewSource(13)), 100, lib)
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "good.bowdb")
+	createTestDB(t, lib, fpath, bow.EncodingUint16, entries)
+	if err := WriteInvertedIndex(fpath); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(fpath); err != nil {
+		t.Fatalf("Database with an inverted index failed to verify: %s", err)
+	}
+	name := memberName(fileInverted, 1)
+	if !strings.Contains(strings.Join(memberNames(t, fpath), " "), name) {
+		t.Fatalf("Database has no member '%s'.", name)
+	}
+
+	db, err := Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := db.manifests[1]
+	listed := false
+	for _, member := range m.Members {
+		listed = listed || member.Name == name
+	}
+	if !listed {
+		t.Fatalf("Manifest of session 1 does not list '%s': %+v", name, m)
+	}
+	db.Close()
+
+	// Corrupt the session recorded in the index, which still parses.
+	good, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), good...)
+	corrupt[memberOffset(t, good, name)+3] ^= 0x10
+	bad := filepath.Join(dir, "bad.bowdb")
+	if err := ioutil.WriteFile(bad, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(bad); err == nil || !strings.Contains(err.Error(), name) {
+		t.Fatalf("Expected a checksum error for '%s', but got: %v", name, err)
+	}
+	db, err = Open(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InvertedIndex(); err == nil {
+		t.Fatalf("Read a corrupt inverted index.")
+	}
+	db.Close()
+}
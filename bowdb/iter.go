@@ -0,0 +1,222 @@
+package bowdb
+
+import (
+	"io"
+	"os"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// Iterator decodes the entries of a BOW database one at a time, without
+// loading the entire database into memory. It is created with DB.Iter.
+//
+// An iterator is used like a bufio.Scanner:
+//
+//	it, err := db.Iter()
+//	if err != nil {
+//		...
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		entry := it.Entry()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	r     *DB // A database used only for reading this iterator's entries.
+	entry bow.Bowed
+	data  []byte
+	err   error
+}
+
+// Iter returns a new iterator over every entry in the database, in the order
+// in which they were added. Deleted entries are skipped. Every iterator reads
+// from its own handle to the database file, so it is safe to use multiple
+// iterators at once (and to use an iterator along with ReadAll or Search).
+// Iterators over a version 2 database read from its memory mapping, and must
+// not be used after the database is closed.
+//
+// Iter will panic if it is called on a database that was made with the
+// Create function.
+func (db *DB) Iter() (*Iterator, error) {
+	if db.readAllLock == nil {
+		panic("DB.Iter cannot be called when the database is being written")
+	}
+
+	f, err := os.Open(db.file.Name())
+	if err != nil {
+		return nil, err
+	}
+	r := &DB{
+		Lib:        db.Lib,
+		Name:       db.Name,
+		Encoding:   db.Encoding,
+		Version:    db.Version,
+		tombstones: db.tombstones,
+		manifests:  db.manifests,
+		file:       f,
+		v2:         db.v2,
+	}
+	if r.v2 == nil {
+		if err := r.rewind(f, 1<<16); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	it := &Iterator{
+		r:     r,
+		entry: bow.Bowed{Bow: bow.NewBow(db.Lib.Size())},
+	}
+	return it, nil
+}
+
+// Next decodes the next entry in the database, which is then available
+// through the Entry method. It returns false when there are no more entries
+// or when an error occurs, at which point the iterator is closed. Err should
+// be consulted to distinguish between the two.
+func (it *Iterator) Next() bool {
+	if it.r == nil {
+		return false
+	}
+	r := it.r
+
+	id, rawData, rawBow, err := r.readRaw()
+	if err != nil {
+		it.finish(err)
+		return false
+	}
+	it.entry.Id = id
+	it.data = append(it.data[:0], rawData...)
+	it.entry.Data = nil
+	if len(it.data) > 0 {
+		it.entry.Data = it.data
+	}
+
+	freqs := it.entry.Bow.Freqs
+	for i := range freqs {
+		freqs[i] = 0
+	}
+	if err := r.decodeFreqs(rawBow, freqs); err != nil {
+		it.finish(err)
+		return false
+	}
+	return true
+}
+
+// Entry returns the entry most recently decoded by Next. The entry returned
+// (including its Data and Bow) is only valid until the next call to Next.
+// Entries that are kept must be copied (see bow.Bowed.Copy).
+func (it *Iterator) Entry() bow.Bowed {
+	return it.entry
+}
+
+// Err returns the first error encountered by the iterator. Reaching the end
+// of the database is not an error.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator's handle to the database file. It is only
+// necessary to call Close when an iterator is abandoned before Next returns
+// false.
+func (it *Iterator) Close() error {
+	if it.r == nil {
+		return nil
+	}
+	err := it.r.file.Close()
+	it.r = nil
+	return err
+}
+
+func (it *Iterator) finish(err error) {
+	if err != io.EOF {
+		it.err = err
+	}
+	it.Close()
+}
+
+// SearchStream is like Search, except entries are decoded one at a time
+// from disk with an Iterator, and only the best hits are kept in memory.
+// This is useful for one-off queries against databases that are too big to
+// fit into memory. (When many queries are run, Search is much faster since it
+// only reads the database once.)
+//
+// When significance is estimated (see SearchOptions.Null), the database is
+// read twice: once to fit the null distribution and once to search.
+func (db *DB) SearchStream(
+	opts SearchOptions,
+	query bow.Bowed,
+) ([]SearchResult, error) {
+	var null *NullDistribution
+	if opts.Null != NullNone {
+		fit, err := db.fitNullStream(opts, query)
+		if err != nil {
+			return nil, err
+		}
+		null = &fit
+		opts = null.bound(opts)
+	}
+
+	it, err := db.Iter()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	hits := newCollector(opts, query)
+	hits.null = null
+	for pos := 0; it.Next(); pos++ {
+		entry := it.Entry()
+		dist := streamDistance(opts, query, entry)
+		if hits.wants(dist, pos) {
+			hits.insert(entry.Copy(), dist, pos)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return hits.results(), nil
+}
+
+// fitNullStream is like fitNull, except entries are read with an Iterator.
+// Distances are summed in the same chunks as fitNull.
+func (db *DB) fitNullStream(
+	opts SearchOptions,
+	query bow.Bowed,
+) (NullDistribution, error) {
+	it, err := db.Iter()
+	if err != nil {
+		return NullDistribution{}, err
+	}
+	defer it.Close()
+
+	queries := nullQueries(opts, query)
+	var fit, chunk nullFit
+	n := 0
+	for ; it.Next(); n++ {
+		if n > 0 && n%nullChunkSize == 0 {
+			fit.merge(chunk)
+			chunk = nullFit{}
+		}
+		entry := it.Entry()
+		for _, q := range queries {
+			chunk.add(streamDistance(opts, q, entry))
+		}
+	}
+	if err := it.Err(); err != nil {
+		return NullDistribution{}, err
+	}
+	fit.merge(chunk)
+	return fit.distribution(n), nil
+}
+
+// streamDistance returns the distance between the query and an entry read
+// by an Iterator.
+func streamDistance(opts SearchOptions, query, entry bow.Bowed) float64 {
+	if opts.SortBy == SortByCosine {
+		return query.Bow.Cosine(entry.Bow)
+	}
+	return query.Bow.Euclid(entry.Bow)
+}
@@ -0,0 +1,133 @@
+package bowdb
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// iterAll returns a copy of every entry yielded by an iterator over the
+// database at fpath.
+func iterAll(t *testing.T, fpath string) []bow.Bowed {
+	db, err := Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	it, err := db.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	var entries []bow.Bowed
+	for it.Next() {
+		entries = append(entries, it.Entry().Copy())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestIter(t *testing.T) {
+	lib := testLibrary(t)
+	entries := testEntries(rand.New(rand.NewSource(7)), 300, lib)
+	deleted := map[string]bool{
+		entries[0].Id: true, entries[150].Id: true, entries[299].Id: true,
+	}
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, enc := range []bow.Encoding{bow.EncodingFloat32, bow.EncodingUint8} {
+		v1Path := filepath.Join(dir, enc.String()+".bowdb")
+		createTestDB(t, lib, v1Path, enc, entries)
+		for id := range deleted {
+			deleteIds(t, lib, v1Path, id)
+		}
+		v2Path := filepath.Join(dir, enc.String()+"-v2.bowdb")
+		if err := Convert(v1Path, v2Path, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		want := readAll(t, v1Path)
+		if len(want) != len(entries)-len(deleted) {
+			t.Fatalf("%s: expected %d entries but ReadAll returned %d.",
+				enc, len(entries)-len(deleted), len(want))
+		}
+		for _, e := range want {
+			if deleted[e.Id] {
+				t.Fatalf("%s: ReadAll returned deleted entry '%s'.", enc, e.Id)
+			}
+		}
+		for _, fpath := range []string{v1Path, v2Path} {
+			if got := iterAll(t, fpath); !reflect.DeepEqual(want, got) {
+				t.Fatalf("%s: iterating over '%s' differs from ReadAll:\n"+
+					"want: %d entries\ngot:  %d entries",
+					enc, filepath.Base(fpath), len(want), len(got))
+			}
+		}
+	}
+}
+
+func TestSearchStream(t *testing.T) {
+	lib := testLibrary(t)
+	rng := rand.New(rand.NewSource(8))
+	entries := testEntries(rng, 400, lib)
+	queries := append(testEntries(rng, 3, lib), entries[10], entries[20])
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := []SearchOptions{
+		SearchDefault,
+		{Limit: -1, Min: 0, Max: 0.5, SortBy: SortByCosine, Order: OrderDesc},
+		{Limit: 5, Min: 0, Max: 100, SortBy: SortByEuclid, Order: OrderAsc},
+		{Limit: 10, Min: 0, Max: 2, SortBy: SortByCosine, Workers: 4,
+			Filter: Filter{
+				ExcludeSelf: true,
+				Exclude:     regexp.MustCompile("1$"),
+			}},
+		{Limit: 10, Min: 0, Max: 2, SortBy: SortByCosine, Null: NullScores},
+		{Limit: 10, Min: 0, Max: 100, SortBy: SortByEuclid,
+			Null: NullDecoys, Decoys: 3},
+	}
+	for _, enc := range testEncodings {
+		fpath := filepath.Join(dir, enc.String()+".bowdb")
+		createTestDB(t, lib, fpath, enc, entries)
+		deleteIds(t, lib, fpath, entries[20].Id, entries[30].Id)
+
+		db, err := Open(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opts := range options {
+			for _, query := range queries {
+				got, err := db.SearchStream(opts, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := db.Search(opts, query)
+				if !reflect.DeepEqual(want, got) {
+					t.Fatalf("%s: SearchStream (%+v) differs from Search:\n"+
+						"want: %s\ngot:  %s", enc, opts,
+						resultIds(want), resultIds(got))
+				}
+			}
+		}
+		db.Close()
+	}
+}
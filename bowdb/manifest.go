@@ -0,0 +1,270 @@
+package bowdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Every session of a version 1 database ends with a manifest member, which
+// records the format version of the archive, the number of entries written
+// in the session and the size and checksum (CRC-32C) of every member
+// written in the session. The manifest of session 0 is named "manifest",
+// while the manifest of session N is named "manifest.N".
+//
+// Databases written before manifests were introduced have none. Their
+// members are not checked, except that the archive must not be truncated.
+const (
+	fileManifest = "manifest"
+
+	// archiveVersion is the format version recorded in manifests.
+	archiveVersion = 1
+
+	// tarTrailerSize is the size of the two zero blocks that end a TAR
+	// archive.
+	tarTrailerSize = 1024
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// manifest describes a session of a version 1 database.
+type manifest struct {
+	Version int         `json:"version"`
+	Session int         `json:"session"`
+	Entries int         `json:"entries"`
+	Members []memberSum `json:"members"`
+}
+
+// memberSum is the size and checksum of an archive member.
+type memberSum struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	CRC32C uint32 `json:"crc32c"`
+}
+
+func newMemberSum(name string, data []byte) memberSum {
+	return memberSum{
+		Name:   name,
+		Size:   int64(len(data)),
+		CRC32C: crc32.Checksum(data, crcTable),
+	}
+}
+
+// writeMember writes an archive member and records its checksum for the
+// manifest of this session.
+func (db *DB) writeMember(name string, data []byte) error {
+	if err := db.tw.WriteHeader(db.newHdr(name, len(data))); err != nil {
+		return err
+	}
+	if _, err := db.tw.Write(data); err != nil {
+		return err
+	}
+	db.written = append(db.written, newMemberSum(name, data))
+	return nil
+}
+
+// writeManifest writes the manifest of this session. It must be the last
+// member written in the session.
+func (db *DB) writeManifest() error {
+	m := manifest{
+		Version: archiveVersion,
+		Session: db.session,
+		Entries: db.numWritten,
+		Members: db.written,
+	}
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	name := memberName(fileManifest, db.session)
+	if err := db.writeMember(name, append(data, '\n')); err != nil {
+		return fmt.Errorf("Could not write manifest: %s", err)
+	}
+	return nil
+}
+
+// readManifest reads the manifest of the given session.
+func (db *DB) readManifest(data []byte, session int) error {
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("Could not read manifest of BOW database '%s': %s",
+			db.Name, err)
+	}
+	if m.Version != archiveVersion {
+		return fmt.Errorf("BOW database '%s' has unknown format version %d. "+
+			"It may have been written by a newer version of this package.",
+			db.Name, m.Version)
+	}
+	if m.Session != session {
+		return fmt.Errorf("Manifest '%s' of BOW database '%s' is for "+
+			"session %d.", memberName(fileManifest, session), db.Name,
+			m.Session)
+	}
+	db.manifests[session] = &m
+	return nil
+}
+
+// checkManifests checks the members found in the archive against the
+// manifests read. The checksums of segments and inverted indexes are not
+// checked, since they aren't read until later (see endSegment and
+// readInverted).
+func (db *DB) checkManifests(found map[string]memberSum, segments []int) error {
+	if len(db.manifests) == 0 {
+		return nil
+	}
+
+	// Every session since the first one with a manifest must have one.
+	// Since the manifest is written last, a missing manifest means the
+	// session was cut short.
+	first := -1
+	for session := range db.manifests {
+		if first == -1 || session < first {
+			first = session
+		}
+	}
+	for _, session := range segments {
+		if session >= first && db.manifests[session] == nil {
+			return fmt.Errorf("Segment '%s' of BOW database '%s' has no "+
+				"manifest. The database is probably truncated.",
+				memberName(fileBowDB, session), db.Name)
+		}
+	}
+
+	for _, m := range db.manifests {
+		for _, want := range m.Members {
+			got, ok := found[want.Name]
+			if !ok {
+				return fmt.Errorf("BOW database '%s' is missing member '%s'.",
+					db.Name, want.Name)
+			}
+			if got.Size != want.Size {
+				return fmt.Errorf("Member '%s' of BOW database '%s' has %d "+
+					"bytes, but its manifest lists %d.",
+					want.Name, db.Name, got.Size, want.Size)
+			}
+			if _, ok := sessionOf(want.Name, fileBowDB); ok {
+				continue
+			}
+			if _, ok := sessionOf(want.Name, fileInverted); ok {
+				continue
+			}
+			if got.CRC32C != want.CRC32C {
+				return errChecksum(db.Name, want.Name, got.CRC32C, want.CRC32C)
+			}
+		}
+	}
+	return nil
+}
+
+// startSegment prepares for checking the segment of the current session
+// against its manifest while its entries are read.
+func (db *DB) startSegment() {
+	db.segEntries = 0
+	db.segCRC = nil
+	if db.manifests[db.session] == nil {
+		db.fileBuf.Reset(db.tr)
+		return
+	}
+	db.segCRC = crc32.New(crcTable)
+	db.fileBuf.Reset(io.TeeReader(db.tr, db.segCRC))
+}
+
+// endSegment checks the number of entries and the checksum of the segment
+// just read against its manifest.
+func (db *DB) endSegment() error {
+	m := db.manifests[db.session]
+	if m == nil || db.segCRC == nil {
+		return nil
+	}
+	name := memberName(fileBowDB, db.session)
+	if db.segEntries != m.Entries {
+		return fmt.Errorf("Segment '%s' of BOW database '%s' has %d entries, "+
+			"but its manifest lists %d.", name, db.Name, db.segEntries,
+			m.Entries)
+	}
+	return db.checkMember(db.session, name, db.segCRC.Sum32())
+}
+
+// checkMember checks the checksum of a member that is read after the
+// database is opened against the manifest of its session, if any.
+func (db *DB) checkMember(session int, name string, crc uint32) error {
+	m := db.manifests[session]
+	if m == nil {
+		return nil
+	}
+	for _, want := range m.Members {
+		if want.Name == name && want.CRC32C != crc {
+			return errChecksum(db.Name, name, crc, want.CRC32C)
+		}
+	}
+	return nil
+}
+
+func errChecksum(dbName, member string, got, want uint32) error {
+	return fmt.Errorf("Member '%s' of BOW database '%s' is corrupt: its "+
+		"checksum is %08x, but its manifest lists %08x.",
+		member, dbName, got, want)
+}
+
+// checkTrailer returns an error if the archive doesn't end with a complete
+// TAR trailer after its last member, which ends at the offset given. (The
+// TAR reader cannot tell an archive that ends with a trailer from one that
+// was truncated between two members.)
+func checkTrailer(f *os.File, name string, end int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < end+tarTrailerSize {
+		return fmt.Errorf("BOW database '%s' is truncated: it ends at byte "+
+			"%d, but its last member ends at byte %d.",
+			name, info.Size(), end)
+	}
+	return nil
+}
+
+// Verify reads every entry of the database and checks it against the
+// checksums and entry counts recorded when the database was written. A
+// stored inverted index is checked too.
+// (Open only checks the fragment library, the encoding and the deleted IDs.
+// Entries are checked as they are read, e.g., by ReadAll or an Iterator, but
+// Verify checks them without keeping them in memory.)
+//
+// Databases written before checksums were recorded can only be checked for
+// truncation.
+func (db *DB) Verify() error {
+	if db.v2 != nil {
+		return db.v2.verify()
+	}
+	if db.readAllLock == nil {
+		panic("DB.Verify cannot be called when the database is being written")
+	}
+	f, err := os.Open(db.file.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := &DB{
+		Name:       db.Name,
+		tombstones: db.tombstones,
+		manifests:  db.manifests,
+	}
+	if err := r.rewind(f, 1<<16); err != nil {
+		return err
+	}
+	for {
+		if _, _, _, err := r.readRaw(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if db.invertedStored {
+		if _, err := db.readInverted(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
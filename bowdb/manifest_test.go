@@ -0,0 +1,305 @@
+package bowdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+func TestIntegrity(t *testing.T) {
+	lib := testLibrary(t)
+	rng := rand.New(rand.NewSource(6))
+	entries := testEntries(rng, 200, lib)
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A database with two sessions.
+	fpath := filepath.Join(dir, "good.bowdb")
+	createTestDB(t, lib, fpath, bow.EncodingUint16, entries[:150])
+	appendEntries(t, lib, fpath, func(db *DB) {
+		db.Delete(entries[0].Id)
+		for _, e := range entries[150:] {
+			db.Add(e)
+		}
+	})
+	if err := verify(fpath); err != nil {
+		t.Fatalf("Intact database failed to verify: %s", err)
+	}
+	want := len(readAll(t, fpath))
+	good, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every truncation must be detected, either by Open or by reading the
+	// entries.
+	bad := filepath.Join(dir, "bad.bowdb")
+	for size := len(good) - 1; size > 0; size -= 1009 {
+		if err := ioutil.WriteFile(bad, good[:size], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := verify(bad); err == nil {
+			t.Fatalf("Database truncated to %d of %d bytes verified.",
+				size, len(good))
+		}
+	}
+
+	// So must every corrupt byte of the entries.
+	segment := memberOffset(t, good, fileBowDB)
+	for _, off := range []int64{3, 100, 1000} {
+		corrupt := append([]byte(nil), good...)
+		corrupt[segment+off] ^= 0x10
+		if err := ioutil.WriteFile(bad, corrupt, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := verify(bad); err == nil {
+			t.Fatalf("Database with corrupt byte %d of its entries verified.",
+				off)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		member string
+		edit   func(m *manifest)
+		ok     bool
+		errMsg string
+	}{
+		{"version", fileManifest, func(m *manifest) { m.Version = 99 },
+			false, "unknown format version 99"},
+		{"entries", "manifest.1", func(m *manifest) { m.Entries++ },
+			false, "but its manifest lists 51"},
+		{"size", fileManifest, func(m *manifest) { m.Members[0].Size++ },
+			false, "bytes, but its manifest lists"},
+		{"checksum", fileManifest, func(m *manifest) { m.Members[0].CRC32C++ },
+			false, "is corrupt"},
+		{"last-missing", "manifest.1", nil, false, "has no manifest"},
+		{"legacy", "", nil, true, ""},
+	}
+	for _, test := range tests {
+		fpath := filepath.Join(dir, test.name+".bowdb")
+		rewriteArchive(t, good, fpath, func(name string, data []byte) []byte {
+			if test.member == "" && strings.HasPrefix(name, fileManifest) {
+				return nil
+			}
+			if name != test.member {
+				return data
+			}
+			if test.edit == nil {
+				return nil
+			}
+			var m manifest
+			if err := json.Unmarshal(data, &m); err != nil {
+				t.Fatal(err)
+			}
+			test.edit(&m)
+			data, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return data
+		})
+		err := verify(fpath)
+		switch {
+		case test.ok && err != nil:
+			t.Fatalf("%s: %s", test.name, err)
+		case !test.ok && err == nil:
+			t.Fatalf("%s: database verified.", test.name)
+		case !test.ok && !strings.Contains(err.Error(), test.errMsg):
+			t.Fatalf("%s: expected an error containing %q, but got: %s",
+				test.name, test.errMsg, err)
+		}
+		if test.ok {
+			if got := len(readAll(t, fpath)); got != want {
+				t.Fatalf("%s: read %d entries, but expected %d.",
+					test.name, got, want)
+			}
+		}
+	}
+
+	// Version 2 databases are checked by Verify.
+	v2Path := filepath.Join(dir, "good.v2.bowdb")
+	if err := Convert(fpath, v2Path, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(v2Path); err != nil {
+		t.Fatalf("Intact version 2 database failed to verify: %s", err)
+	}
+	v2, err := ioutil.ReadFile(v2Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2[len(v2)/2] ^= 0x10
+	if err := ioutil.WriteFile(bad, v2, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(bad); err == nil {
+		t.Fatalf("Corrupt version 2 database verified.")
+	}
+}
+
+// TestCorruptSearch checks that an error found while reading the entries of
+// a database is returned by every later read, and that searches do not use
+// the entries read before the error.
+func TestCorruptSearch(t *testing.T) {
+	lib := testLibrary(t)
+	rng := rand.New(rand.NewSource(16))
+	entries := testEntries(rng, 100, lib)
+	query := testEntries(rng, 1, lib)[0]
+
+	dir, err := ioutil.TempDir("", "bowdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Entries are read with ReadAll and with ReadAllQuantized.
+	encodings := []bow.Encoding{bow.EncodingFloat32, bow.EncodingUint16}
+	for _, enc := range encodings {
+		fpath := filepath.Join(dir, enc.String()+".bowdb")
+		createTestDB(t, lib, fpath, enc, entries)
+		good, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupt := append([]byte(nil), good...)
+		corrupt[memberOffset(t, good, fileBowDB)+100] ^= 0x10
+		bad := filepath.Join(dir, enc.String()+"-bad.bowdb")
+		if err := ioutil.WriteFile(bad, corrupt, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		db, err := Open(bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results := db.Search(SearchDefault, query); len(results) > 0 {
+			t.Fatalf("%s: searching a corrupt database returned %d results.",
+				enc, len(results))
+		}
+		if db.Err() == nil {
+			t.Fatalf("%s: searching a corrupt database set no error.", enc)
+		}
+		for i := 0; i < 2; i++ {
+			var err error
+			if enc == bow.EncodingFloat32 {
+				_, err = db.ReadAll()
+			} else {
+				_, err = db.ReadAllQuantized()
+			}
+			if err == nil {
+				t.Fatalf("%s: reading a corrupt database again (%d) "+
+					"returned no error.", enc, i)
+			}
+		}
+		if db.Entries != nil || db.QuantizedEntries != nil {
+			t.Fatalf("%s: entries of a corrupt database were kept.", enc)
+		}
+
+		for _, results := range db.SearchBatch(SearchDefault,
+			[]bow.Bowed{query, entries[5]}) {
+			if len(results) > 0 {
+				t.Fatalf("%s: SearchBatch returned results.", enc)
+			}
+		}
+		if _, ok := db.Get(entries[5].Id); ok {
+			t.Fatalf("%s: Get returned an entry.", enc)
+		}
+		if _, err := db.SearchId(SearchDefault, entries[5].Id); err == nil ||
+			strings.Contains(err.Error(), "No entry") {
+			t.Fatalf("%s: expected a read error from SearchId, but got: %v",
+				enc, err)
+		}
+		if _, err := db.SearchIndexed(SearchDefault, query); err == nil {
+			t.Fatalf("%s: SearchIndexed returned no error.", enc)
+		}
+		err = db.WriteDistanceMatrix(ioutil.Discard, MatrixDefault)
+		if err == nil {
+			t.Fatalf("%s: WriteDistanceMatrix returned no error.", enc)
+		}
+		db.Close()
+	}
+}
+
+// verify opens and verifies the database at fpath.
+func verify(fpath string) error {
+	db, err := Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Verify()
+}
+
+// memberOffset returns the offset of the data of the named member in a TAR
+// archive.
+func memberOffset(t *testing.T, archive []byte, name string) int64 {
+	r := bytes.NewReader(archive)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Could not find member '%s': %s", name, err)
+		}
+		if filepath.Base(hdr.Name) == name {
+			return int64(len(archive)) - int64(r.Len())
+		}
+	}
+}
+
+// rewriteArchive writes a copy of a TAR archive to fpath, with the data of
+// every member replaced by edit. Members for which edit returns nil are
+// dropped.
+func rewriteArchive(
+	t *testing.T,
+	archive []byte,
+	fpath string,
+	edit func(name string, data []byte) []byte,
+) {
+	out := new(bytes.Buffer)
+	tw := tar.NewWriter(out)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			if data = edit(filepath.Base(hdr.Name), data); data == nil {
+				continue
+			}
+			hdr.Size = int64(len(data))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fpath, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package bowdb
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// mapFile reads the entire file into memory on platforms without mmap.
+func mapFile(f *os.File) ([]byte, func() error, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadAll(io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,34 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package bowdb
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mapFile maps the entire file into memory, read only. The function returned
+// unmaps it.
+func mapFile(f *os.File) ([]byte, func() error, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, func() error { return nil }, nil
+	}
+	if int64(int(size)) != size {
+		return nil, nil, fmt.Errorf("File '%s' is too big to map into "+
+			"memory.", f.Name())
+	}
+	data, err := syscall.Mmap(
+		int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not map '%s' into memory: %s",
+			f.Name(), err)
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
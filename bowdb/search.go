@@ -1,8 +1,9 @@
 package bowdb
 
 import (
-	"fmt"
 	"math"
+	"runtime"
+	"sync"
 
 	"github.com/yunwilliamyu/esfragbag/bow"
 )
@@ -35,8 +36,36 @@ type SearchOptions struct {
 	SortBy int
 
 	// Order specifies whether the results are returned in ascending (OrderAsc)
-	// or descending (OrderDesc) order.
+	// or descending (OrderDesc) order. Entries with equal distances are
+	// always ordered by ID (and then by their position in the database), both
+	// when deciding which entries make the limit and in the results.
 	Order int
+
+	// Workers is the number of goroutines used to search a database. The
+	// entries are split evenly among the workers. The results are always
+	// identical to the results of a search with a single worker. A value
+	// less than 2 searches on the calling goroutine.
+	Workers int
+
+	// Filter restricts which entries may be returned. It is applied while
+	// searching, before Limit. The zero value accepts every entry.
+	Filter Filter
+
+	// Null specifies how the null distribution of distances to the query is
+	// estimated. When it is not NullNone, the significance of every hit
+	// (ZScore, PValue and EValue) is reported. This requires comparing every
+	// entry with the query (or Decoys decoys) before the search.
+	Null NullModel
+
+	// Decoys is the number of shuffled decoys of the query used by
+	// NullDecoys. When it is not positive, 10 decoys are used.
+	Decoys int
+
+	// MaxEValue, when positive, excludes hits with a greater E-value. It is
+	// ignored when Null is NullNone. Since the E-value of a hit grows with
+	// its distance, MaxEValue is applied as a per-query bound on distance
+	// (i.e., before Limit).
+	MaxEValue float64
 }
 
 // SearchDefault provides default search settings. Namely, it restricts the
@@ -48,6 +77,8 @@ var SearchDefault = SearchOptions{
 	Max:    math.MaxFloat64,
 	SortBy: SortByCosine,
 	Order:  OrderAsc,
+
+	Workers: runtime.NumCPU(),
 }
 
 // SearchClose provides search settings that limit results by closeness instead
@@ -58,14 +89,22 @@ var SearchClose = SearchOptions{
 	Max:    0.35,
 	SortBy: SortByCosine,
 	Order:  OrderAsc,
+
+	Workers: runtime.NumCPU(),
 }
 
 // SearchResult corresponds to a single result returned from a search.
 // It embeds a Bowed result (which includes meta data about the entry) along
 // with values for all distance metrics.
+//
+// When the search estimates significance (see SearchOptions.Null), the
+// z-score, p-value and E-value of the hit's distance with respect to the
+// null distribution are also set. Otherwise, they are zero.
 type SearchResult struct {
 	bow.Bowed
 	Cosine, Euclid float64
+
+	ZScore, PValue, EValue float64
 }
 
 func newSearchResult(query, entry bow.Bowed) SearchResult {
@@ -82,75 +121,162 @@ func newSearchResult(query, entry bow.Bowed) SearchResult {
 //
 // Note that if the ReadAll method hasn't been called before, Search will
 // call it for you. (This means that the first search could take longer than
-// one would otherwise expect.)
+// one would otherwise expect.) For a database with a quantized encoding,
+// ReadAllQuantized is called instead and distances are computed directly on
+// the quantized frequencies. Neither is called for a version 2 database,
+// whose entries are searched where they are mapped in memory.
+//
+// If the entries cannot be read, no results are returned and Err returns
+// the error.
 //
 // It is safe to call Search on the same database from multiple goroutines.
+// To search a database without reading it into memory, use SearchStream.
 func (db *DB) Search(opts SearchOptions, query bow.Bowed) []SearchResult {
-	tree := new(bst)
+	numEntries, distance, entryAt, err := db.scan(opts, query)
+	if err != nil {
+		return nil
+	}
+	opts, null := significant(opts, query, numEntries, db.distanceTo(opts))
+	return search(opts, query, numEntries, distance, entryAt, null)
+}
 
-	if db.Entries == nil {
-		db.ReadAll()
+// SearchEntries is like Search, except the entries given are searched
+// instead of a database. This is useful for reranking a set of candidates
+// with exact distances.
+func SearchEntries(
+	opts SearchOptions,
+	query bow.Bowed,
+	entries []bow.Bowed,
+) []SearchResult {
+	distance, entryAt := bowedScan(opts, query, entries)
+	distanceTo := func(q bow.Bowed) func(i int) float64 {
+		distance, _ := bowedScan(opts, q, entries)
+		return distance
 	}
-	for _, entry := range db.Entries {
-		// Compute the distance between the query and the target.
-		var dist float64
-		switch opts.SortBy {
-		case SortByCosine:
-			dist = query.Bow.Cosine(entry.Bow)
-		case SortByEuclid:
-			dist = query.Bow.Euclid(entry.Bow)
-		default:
-			panic(fmt.Sprintf("Unrecognized SortBy value: %d", opts.SortBy))
-		}
+	opts, null := significant(opts, query, len(entries), distanceTo)
+	return search(opts, query, len(entries), distance, entryAt, null)
+}
 
-		// If the distance isn't in the min/max thresholds specified, skip it.
-		if dist > opts.Max || dist < opts.Min {
-			continue
-		}
+// scan reads every entry into memory if necessary, and returns the number of
+// entries along with functions returning the distance between the query and
+// the i'th entry and the i'th entry itself. Distances are computed on the
+// quantized frequencies for databases with a quantized encoding. The entries
+// of a version 2 database are only read into memory if that has already
+// been done. If the entries cannot be read, there are no entries and the
+// error is returned.
+func (db *DB) scan(
+	opts SearchOptions,
+	query bow.Bowed,
+) (int, func(i int) float64, func(i int) bow.Bowed, error) {
+	if db.mapped() {
+		numEntries, distance, entryAt := db.scanV2(opts, query)
+		return numEntries, distance, entryAt, nil
+	}
+	if db.Encoding == bow.EncodingFloat32 {
+		entries, err := db.ReadAll()
+		distance, entryAt := bowedScan(opts, query, entries)
+		return len(entries), distance, entryAt, err
+	}
 
-		// If there is a limit and we're already at that limit, then
-		// we'll skip inserting this element if it's not better than the
-		// worst hit.
-		if tree.size == opts.Limit {
-			if opts.Order == OrderAsc && dist >= tree.max.distance {
-				continue
-			} else if opts.Order == OrderDesc && dist <= tree.min.distance {
-				continue
-			}
+	entries, err := db.ReadAllQuantized()
+	distance := func(i int) float64 {
+		if opts.SortBy == SortByCosine {
+			return entries[i].Bow.Cosine(query.Bow)
 		}
+		return entries[i].Bow.Euclid(query.Bow)
+	}
+	entryAt := func(i int) bow.Bowed {
+		return entries[i].Bowed()
+	}
+	return len(entries), distance, entryAt, err
+}
 
-		// This target is good enough, add it to our results.
-		tree.insert(entry, dist)
-
-		// This element is good enough, so lets throw away the worst
-		// result we have.
-		if opts.Limit >= 0 && tree.size == opts.Limit+1 {
-			if opts.Order == OrderAsc {
-				tree.deleteMax()
-			} else {
-				tree.deleteMin()
-			}
+func bowedScan(
+	opts SearchOptions,
+	query bow.Bowed,
+	entries []bow.Bowed,
+) (func(i int) float64, func(i int) bow.Bowed) {
+	distance := func(i int) float64 {
+		if opts.SortBy == SortByCosine {
+			return query.Bow.Cosine(entries[i].Bow)
 		}
+		return query.Bow.Euclid(entries[i].Bow)
+	}
+	entryAt := func(i int) bow.Bowed {
+		return entries[i]
+	}
+	return distance, entryAt
+}
 
-		// Sanity check.
-		if opts.Limit >= 0 && tree.size > opts.Limit {
-			panic(fmt.Sprintf("Tree size (%d) is bigger than limit (%d).",
-				tree.size, opts.Limit))
-		}
+// search performs an exhaustive search over numEntries entries, where
+// distance returns the distance between the query and the i'th entry and
+// entryAt returns the i'th entry. Both functions must be safe to call from
+// multiple goroutines. The null distribution, if not nil, is used to set the
+// significance of every result.
+//
+// When opts.Workers > 1, the entries are split into contiguous chunks that
+// are searched concurrently, each with its own collector. The hits of every
+// chunk are then merged into a single collector. Since hits are totally
+// ordered by distance, ID and position, the merged result is identical to
+// the result of a serial search.
+func search(
+	opts SearchOptions,
+	query bow.Bowed,
+	numEntries int,
+	distance func(i int) float64,
+	entryAt func(i int) bow.Bowed,
+	null *NullDistribution,
+) []SearchResult {
+	workers := opts.Workers
+	if workers > numEntries {
+		workers = numEntries
+	}
+	if workers < 2 {
+		hits := searchRange(opts, query, 0, numEntries, distance, entryAt)
+		hits.null = null
+		return hits.results()
 	}
 
-	results := make([]SearchResult, tree.size)
-	i := 0
-	if opts.Order == OrderAsc {
-		tree.root.inorder(func(n *node) {
-			results[i] = newSearchResult(query, n.Bowed)
-			i += 1
-		})
-	} else {
-		tree.root.inorderReverse(func(n *node) {
-			results[i] = newSearchResult(query, n.Bowed)
-			i += 1
+	chunks := make([]*collector, workers)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			start := w * numEntries / workers
+			end := (w + 1) * numEntries / workers
+			chunks[w] = searchRange(opts, query, start, end, distance, entryAt)
+		}(w)
+	}
+	wg.Wait()
+
+	merged := newCollector(opts, query)
+	merged.null = null
+	for _, chunk := range chunks {
+		chunk.each(func(h *hit) {
+			if merged.wants(h.distance, h.pos) {
+				merged.add(*h)
+			}
 		})
 	}
-	return results
+	return merged.results()
+}
+
+// searchRange collects the best hits among the entries in [start, end).
+func searchRange(
+	opts SearchOptions,
+	query bow.Bowed,
+	start, end int,
+	distance func(i int) float64,
+	entryAt func(i int) bow.Bowed,
+) *collector {
+	hits := newCollector(opts, query)
+	for i := start; i < end; i++ {
+		// Compute the distance between the query and the target.
+		dist := distance(i)
+		if hits.wants(dist, i) {
+			hits.insert(entryAt(i), dist, i)
+		}
+	}
+	return hits
 }
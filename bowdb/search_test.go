@@ -0,0 +1,183 @@
+package bowdb
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// randomEntries returns entries with small integer frequencies over a few
+// fragments, so that many entries have equal distances to a query.
+func randomEntries(rng *rand.Rand, n, size int) []bow.Bowed {
+	entries := make([]bow.Bowed, n)
+	for i := range entries {
+		b := bow.NewBow(size)
+		for j := 0; j < 3; j++ {
+			b.Freqs[rng.Intn(size)] += float32(1 + rng.Intn(2))
+		}
+		entries[i] = bow.Bowed{Id: fmt.Sprintf("entry%d", i), Bow: b}
+	}
+	return entries
+}
+
+func TestSearchParallel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	entries := randomEntries(rng, 1000, 8)
+	queries := randomEntries(rng, 10, 8)
+
+	for _, sortBy := range []int{SortByCosine, SortByEuclid} {
+		for _, order := range []int{OrderAsc, OrderDesc} {
+			for _, limit := range []int{-1, 0, 1, 10, 100, 2000} {
+				opts := SearchOptions{
+					Limit:  limit,
+					Min:    0.0,
+					Max:    2.5,
+					SortBy: sortBy,
+					Order:  order,
+				}
+				for _, query := range queries {
+					opts.Workers = 1
+					serial := SearchEntries(opts, query, entries)
+					for _, workers := range []int{2, 3, 8, 32, 5000} {
+						opts.Workers = workers
+						parallel := SearchEntries(opts, query, entries)
+						if !sameResults(serial, parallel) {
+							t.Fatalf("Search with %d workers differs from "+
+								"serial search (options: %+v).",
+								workers, opts)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// bruteForce returns the results of a search by computing the distance to
+// every entry and sorting all of them.
+func bruteForce(
+	opts SearchOptions,
+	query bow.Bowed,
+	entries []bow.Bowed,
+) []SearchResult {
+	type scored struct {
+		entry bow.Bowed
+		dist  float64
+		pos   int
+	}
+	var all []scored
+	for i, entry := range entries {
+		var dist float64
+		if opts.SortBy == SortByCosine {
+			dist = query.Bow.Cosine(entry.Bow)
+		} else {
+			dist = query.Bow.Euclid(entry.Bow)
+		}
+		if dist >= opts.Min && dist <= opts.Max {
+			all = append(all, scored{entry, dist, i})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.dist != b.dist {
+			if opts.Order == OrderDesc {
+				return a.dist > b.dist
+			}
+			return a.dist < b.dist
+		}
+		if a.entry.Id != b.entry.Id {
+			return a.entry.Id < b.entry.Id
+		}
+		return a.pos < b.pos
+	})
+	if opts.Limit >= 0 && len(all) > opts.Limit {
+		all = all[:opts.Limit]
+	}
+	results := make([]SearchResult, len(all))
+	for i, s := range all {
+		results[i] = newSearchResult(query, s.entry)
+	}
+	return results
+}
+
+func TestSearchBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	entries := randomEntries(rng, 200, 6)
+
+	// Add copies of some entries under new IDs, and shuffle everything so
+	// that ties in distance can only be broken by ID.
+	for i := 0; i < 50; i++ {
+		dup := entries[rng.Intn(len(entries))]
+		dup.Id = fmt.Sprintf("copy%d", i)
+		entries = append(entries, dup)
+	}
+	rng.Shuffle(len(entries), func(i, j int) {
+		entries[i], entries[j] = entries[j], entries[i]
+	})
+	queries := randomEntries(rng, 5, 6)
+
+	n := len(entries)
+	windows := []struct{ min, max float64 }{
+		{0, math.MaxFloat64},
+		{0, 0.5},
+		{0.25, 0.75},
+		{0.5, 2.0},
+		{1.0, 1.0},
+		{0.9, 0.1},
+	}
+	tests := []SearchOptions{}
+	for _, sortBy := range []int{SortByCosine, SortByEuclid} {
+		for _, order := range []int{OrderAsc, OrderDesc} {
+			for _, limit := range []int{-1, 0, 1, 5, n, n + 5} {
+				for _, w := range windows {
+					tests = append(tests, SearchOptions{
+						Limit:  limit,
+						Min:    w.min,
+						Max:    w.max,
+						SortBy: sortBy,
+						Order:  order,
+					})
+				}
+			}
+		}
+	}
+	for _, opts := range tests {
+		for _, workers := range []int{1, 4} {
+			opts.Workers = workers
+			for _, query := range queries {
+				want := bruteForce(opts, query, entries)
+				got := SearchEntries(opts, query, entries)
+				if !sameResults(want, got) {
+					t.Fatalf("Search differs from a brute force search "+
+						"(options: %+v).\nwant: %s\ngot:  %s",
+						opts, resultIds(want), resultIds(got))
+				}
+			}
+		}
+	}
+}
+
+func resultIds(rs []SearchResult) []string {
+	ids := make([]string, len(rs))
+	for i := range rs {
+		ids[i] = rs[i].Id
+	}
+	return ids
+}
+
+func sameResults(rs1, rs2 []SearchResult) bool {
+	if len(rs1) != len(rs2) {
+		return false
+	}
+	for i := range rs1 {
+		if rs1[i].Id != rs2[i].Id || rs1[i].Cosine != rs2[i].Cosine ||
+			rs1[i].Euclid != rs2[i].Euclid {
+			return false
+		}
+	}
+	return true
+}
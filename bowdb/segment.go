@@ -0,0 +1,72 @@
+package bowdb
+
+import (
+	"bufio"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+)
+
+// segmentWriter streams the entries written in a session to a temporary
+// file in the same directory as the database, so that writing a database
+// doesn't require memory proportional to its size. The temporary file is
+// copied into the archive as the session's segment when the database is
+// closed.
+type segmentWriter struct {
+	f    *os.File
+	buf  *bufio.Writer
+	crc  hash.Hash32
+	size int64
+}
+
+func newSegmentWriter(fpath string) (*segmentWriter, error) {
+	f, err := ioutil.TempFile(path.Dir(fpath), "."+path.Base(fpath)+".segment")
+	if err != nil {
+		return nil, err
+	}
+	crc := crc32.New(crcTable)
+	return &segmentWriter{
+		f:   f,
+		buf: bufio.NewWriterSize(io.MultiWriter(f, crc), 1<<16),
+		crc: crc,
+	}, nil
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	n, err := w.buf.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// remove closes and removes the temporary file.
+func (w *segmentWriter) remove() error {
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
+
+// writeSegment copies the segment of this session into the archive as the
+// member with the name given, and records its checksum for the manifest.
+func (db *DB) writeSegment(name string) error {
+	w := db.segment
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := db.tw.WriteHeader(db.newHdr(name, int(w.size))); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(db.tw, w.f, w.size); err != nil {
+		return err
+	}
+	db.written = append(db.written, memberSum{
+		Name:   name,
+		Size:   w.size,
+		CRC32C: w.crc.Sum32(),
+	})
+	return nil
+}
@@ -0,0 +1,298 @@
+package bowdb
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// NullModel specifies how the null distribution of distances to a query is
+// estimated when computing the statistical significance of search hits.
+type NullModel int
+
+const (
+	// NullNone disables significance estimates.
+	NullNone NullModel = iota
+
+	// NullScores fits the null distribution to the distances between the
+	// query and every entry in the database. Since the vast majority of
+	// entries are unrelated to any given query, the true hits barely
+	// affect the fit.
+	NullScores
+
+	// NullDecoys fits the null distribution to the distances between every
+	// entry in the database and decoy queries, which are made by shuffling
+	// the fragment frequencies of the query. Decoys keep the length and
+	// composition of the query while destroying its similarity to related
+	// entries.
+	NullDecoys
+)
+
+// defaultDecoys is the number of decoys used when SearchOptions.Decoys is not
+// positive.
+const defaultDecoys = 10
+
+// nullChunkSize is the number of entries whose distances are summed together
+// when fitting a null distribution. Chunks are summed in order, so that the
+// fit doesn't depend on the number of workers.
+const nullChunkSize = 4096
+
+// NullDistribution is a normal distribution fit to the distances between a
+// query and unrelated entries in a database of a particular size.
+type NullDistribution struct {
+	Mean, StdDev float64
+
+	// The number of entries in the database, which is the number of
+	// comparisons an E-value is corrected for.
+	Entries int
+}
+
+// ZScore returns the number of standard deviations by which dist is closer
+// to the query than the mean of the null distribution. Significant hits have
+// large positive z-scores.
+func (null NullDistribution) ZScore(dist float64) float64 {
+	z := (null.Mean - dist) / null.StdDev
+	if math.IsNaN(z) {
+		return 0
+	}
+	return z
+}
+
+// PValue returns the probability that an unrelated entry has a distance to
+// the query of at most dist.
+func (null NullDistribution) PValue(dist float64) float64 {
+	return 0.5 * math.Erfc(null.ZScore(dist)/math.Sqrt2)
+}
+
+// EValue returns the number of unrelated entries expected to have a distance
+// to the query of at most dist in a database of this size.
+func (null NullDistribution) EValue(dist float64) float64 {
+	return null.PValue(dist) * float64(null.Entries)
+}
+
+// maxDistance returns the greatest distance whose E-value is at most maxE.
+// The second return value is false if there is no such finite distance.
+func (null NullDistribution) maxDistance(maxE float64) (float64, bool) {
+	if null.Entries == 0 || null.StdDev == 0 || math.IsNaN(null.StdDev) {
+		return 0, false
+	}
+	p := maxE / float64(null.Entries)
+	if p >= 1 {
+		return math.Inf(1), true
+	}
+	d := null.Mean - math.Sqrt2*math.Erfcinv(2*p)*null.StdDev
+	if math.IsInf(d, 0) || math.IsNaN(d) {
+		return 0, false
+	}
+
+	// The inverse is not exact, so bracket the bound and bisect until the
+	// bracket is as narrow as floating point allows. Since 0 < p < 1, the
+	// E-value is below maxE far enough to the left and above it far enough
+	// to the right.
+	step := math.Abs(d)*1e-12 + math.SmallestNonzeroFloat64
+	lo, hi := d, d
+	if null.EValue(d) <= maxE {
+		for hi = d + step; null.EValue(hi) <= maxE; hi = lo + step {
+			lo, step = hi, step*2
+		}
+	} else {
+		for lo = d - step; null.EValue(lo) > maxE; lo = hi - step {
+			hi, step = lo, step*2
+		}
+	}
+	for {
+		mid := lo + (hi-lo)/2
+		if mid == lo || mid == hi {
+			break
+		}
+		if null.EValue(mid) <= maxE {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	d = lo
+	return d, true
+}
+
+// nullFit accumulates distances to fit a null distribution.
+type nullFit struct {
+	n          int
+	sum, sumSq float64
+}
+
+func (f *nullFit) add(dist float64) {
+	f.n++
+	f.sum += dist
+	f.sumSq += dist * dist
+}
+
+func (f *nullFit) merge(g nullFit) {
+	f.n += g.n
+	f.sum += g.sum
+	f.sumSq += g.sumSq
+}
+
+func (f nullFit) distribution(entries int) NullDistribution {
+	if f.n == 0 {
+		return NullDistribution{Entries: entries}
+	}
+	mean := f.sum / float64(f.n)
+	variance := f.sumSq/float64(f.n) - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	return NullDistribution{
+		Mean:    mean,
+		StdDev:  math.Sqrt(variance),
+		Entries: entries,
+	}
+}
+
+// nullQueries returns the queries whose distances to every entry make up the
+// null distribution: either the query itself or its decoys.
+func nullQueries(opts SearchOptions, query bow.Bowed) []bow.Bowed {
+	if opts.Null != NullDecoys {
+		return []bow.Bowed{query}
+	}
+	n := opts.Decoys
+	if n < 1 {
+		n = defaultDecoys
+	}
+
+	// Decoys are seeded deterministically, so that searching with the same
+	// query always produces the same significance estimates.
+	rng := rand.New(rand.NewSource(1))
+	decoys := make([]bow.Bowed, n)
+	for i := range decoys {
+		decoy := bow.NewBow(query.Bow.Len())
+		for j, k := range rng.Perm(query.Bow.Len()) {
+			decoy.Freqs[k] = query.Bow.Freqs[j]
+		}
+		decoys[i] = bow.Bowed{Id: query.Id, Bow: decoy}
+	}
+	return decoys
+}
+
+// fitNull fits the null distribution requested by opts over numEntries
+// entries, where distanceTo returns a function computing the distance
+// between the query given and the i'th entry.
+func fitNull(
+	opts SearchOptions,
+	query bow.Bowed,
+	numEntries int,
+	distanceTo func(query bow.Bowed) func(i int) float64,
+) NullDistribution {
+	var distances []func(i int) float64
+	for _, q := range nullQueries(opts, query) {
+		distances = append(distances, distanceTo(q))
+	}
+
+	chunks := make([]nullFit, (numEntries+nullChunkSize-1)/nullChunkSize)
+	workers := opts.Workers
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	next := make(chan int)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range next {
+				end := (c + 1) * nullChunkSize
+				if end > numEntries {
+					end = numEntries
+				}
+				for i := c * nullChunkSize; i < end; i++ {
+					for _, distance := range distances {
+						chunks[c].add(distance(i))
+					}
+				}
+			}
+		}()
+	}
+	for c := range chunks {
+		next <- c
+	}
+	close(next)
+	wg.Wait()
+
+	var fit nullFit
+	for _, chunk := range chunks {
+		fit.merge(chunk)
+	}
+	return fit.distribution(numEntries)
+}
+
+// FitNull fits a null distribution of distances to the query given, using
+// the null model and metric in the search options. (When opts.Null is
+// NullNone, NullScores is used.) Every entry in the database is compared with
+// the query or its decoys.
+func (db *DB) FitNull(opts SearchOptions, query bow.Bowed) NullDistribution {
+	if opts.Null == NullNone {
+		opts.Null = NullScores
+	}
+	n, _, _, _ := db.scan(opts, query)
+	return fitNull(opts, query, n, db.distanceTo(opts))
+}
+
+// distanceTo returns a function that returns the distance function of a
+// query, as computed by scan.
+func (db *DB) distanceTo(
+	opts SearchOptions,
+) func(query bow.Bowed) func(i int) float64 {
+	return func(query bow.Bowed) func(i int) float64 {
+		_, distance, _, _ := db.scan(opts, query)
+		return distance
+	}
+}
+
+// significant fits the null distribution requested by opts, if any, and
+// returns the search options with MaxEValue converted into a bound on the
+// distance, so that the threshold is applied before Limit. The distribution
+// returned should be given to the collector of the search.
+func significant(
+	opts SearchOptions,
+	query bow.Bowed,
+	numEntries int,
+	distanceTo func(query bow.Bowed) func(i int) float64,
+) (SearchOptions, *NullDistribution) {
+	if opts.Null == NullNone {
+		return opts, nil
+	}
+	null := fitNull(opts, query, numEntries, distanceTo)
+	return null.bound(opts), &null
+}
+
+// bound returns the search options with Max lowered to the greatest distance
+// satisfying MaxEValue.
+func (null *NullDistribution) bound(opts SearchOptions) SearchOptions {
+	if opts.MaxEValue <= 0 {
+		return opts
+	}
+	if max, ok := null.maxDistance(opts.MaxEValue); ok && max < opts.Max {
+		opts.Max = max
+	}
+	return opts
+}
+
+// SortBySignificance sorts search results by E-value, from most to least
+// significant, with ties broken by ID. This is useful for combining the
+// results of different queries or databases, whose distances aren't
+// comparable. (The results of a single search with OrderAsc are already
+// sorted by E-value.)
+func SortBySignificance(results []SearchResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].EValue != results[j].EValue {
+			return results[i].EValue < results[j].EValue
+		}
+		return results[i].Id < results[j].Id
+	})
+}
@@ -0,0 +1,112 @@
+package bowdb
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestNullDistribution(t *testing.T) {
+	null := NullDistribution{Mean: 0.5, StdDev: 0.1, Entries: 1000}
+	tests := []struct {
+		name      string
+		got, want float64
+	}{
+		{"ZScore(0.3)", null.ZScore(0.3), 2},
+		{"ZScore(0.6)", null.ZScore(0.6), -1},
+		{"PValue(0.5)", null.PValue(0.5), 0.5},
+		{"PValue(0.3)", null.PValue(0.3), 0.022750131948179},
+		{"EValue(0.5)", null.EValue(0.5), 500},
+		{"EValue(0.3)", null.EValue(0.3), 22.750131948179},
+	}
+	for _, test := range tests {
+		if math.Abs(test.got-test.want) > 1e-9 {
+			t.Errorf("%s = %g, want %g", test.name, test.got, test.want)
+		}
+	}
+
+	for _, maxE := range []float64{1e-10, 0.001, 1, 22.75, 999} {
+		d, ok := null.maxDistance(maxE)
+		if !ok {
+			t.Fatalf("No maximum distance for an E-value of %g.", maxE)
+		}
+		next := math.Nextafter(d, math.Inf(1))
+		if null.EValue(d) > maxE || null.EValue(next) <= maxE {
+			t.Errorf("%g is not the greatest distance with an E-value of "+
+				"at most %g.", d, maxE)
+		}
+	}
+}
+
+func TestSearchSignificance(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	entries := randomEntries(rng, 500, 12)
+	queries := randomEntries(rng, 5, 12)
+
+	for _, null := range []NullModel{NullScores, NullDecoys} {
+		for _, query := range queries {
+			opts := SearchOptions{
+				Limit:   -1,
+				Min:     0,
+				Max:     math.MaxFloat64,
+				SortBy:  SortByCosine,
+				Order:   OrderAsc,
+				Workers: 1,
+				Null:    null,
+			}
+			all := SearchEntries(opts, query, entries)
+			for i := 1; i < len(all); i++ {
+				if all[i].EValue < all[i-1].EValue {
+					t.Fatalf("E-values are not increasing: %g then %g.",
+						all[i-1].EValue, all[i].EValue)
+				}
+			}
+			if null == NullScores {
+				var sum, sumSq float64
+				for _, r := range all {
+					sum += r.Cosine
+					sumSq += r.Cosine * r.Cosine
+				}
+				n := float64(len(entries))
+				mean := sum / n
+				sd := math.Sqrt(sumSq/n - mean*mean)
+				want := (mean - all[0].Cosine) / sd
+				if math.Abs(all[0].ZScore-want) > 1e-6 {
+					t.Fatalf("Best hit has z-score %g, want %g.",
+						all[0].ZScore, want)
+				}
+			}
+
+			for _, maxE := range []float64{0.01, 1, 10} {
+				for _, limit := range []int{-1, 3} {
+					var want []SearchResult
+					for _, r := range all {
+						if r.EValue <= maxE && (limit < 0 || len(want) < limit) {
+							want = append(want, r)
+						}
+					}
+
+					opts.MaxEValue = maxE
+					opts.Limit = limit
+					for _, workers := range []int{1, 4} {
+						opts.Workers = workers
+						got := SearchEntries(opts, query, entries)
+						if !sameResults(want, got) {
+							t.Fatalf("Search with MaxEValue %g differs from "+
+								"thresholding afterwards (options: %+v)."+
+								"\nwant: %s\ngot:  %s", maxE, opts,
+								resultIds(want), resultIds(got))
+						}
+						for i := range got {
+							if got[i].EValue != want[i].EValue {
+								t.Fatalf("E-value of %s differs with %d "+
+									"workers: %g != %g", got[i].Id, workers,
+									got[i].EValue, want[i].EValue)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
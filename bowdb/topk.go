@@ -0,0 +1,195 @@
+package bowdb
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// hit is a single entry collected by a search.
+type hit struct {
+	bow.Bowed
+	distance float64
+	pos      int
+}
+
+// hitHeap is a heap of hits with the worst hit on top, where better orders
+// hits from best to worst.
+type hitHeap struct {
+	hits   []hit
+	better func(h1, h2 *hit) bool
+}
+
+func (h *hitHeap) Len() int {
+	return len(h.hits)
+}
+
+func (h *hitHeap) Less(i, j int) bool {
+	return h.better(&h.hits[j], &h.hits[i])
+}
+
+func (h *hitHeap) Swap(i, j int) {
+	h.hits[i], h.hits[j] = h.hits[j], h.hits[i]
+}
+
+func (h *hitHeap) Push(x interface{}) {
+	h.hits = append(h.hits, x.(hit))
+}
+
+func (h *hitHeap) Pop() interface{} {
+	last := h.hits[len(h.hits)-1]
+	h.hits = h.hits[:len(h.hits)-1]
+	return last
+}
+
+// collector accumulates the best hits of a search with respect to the
+// search options given. Hits are ordered by distance (in the order given by
+// the search options), then by ID and then by their position in the
+// database, so that the hits collected never depend on the order in which
+// entries are seen.
+//
+// When there is a limit, the hits are kept in a heap with the worst hit on
+// top, so that each insertion takes O(log Limit) time. Without a limit,
+// every hit is kept and sorted once at the end.
+type collector struct {
+	opts   SearchOptions
+	query  bow.Bowed
+	heap   hitHeap
+	closer func(d1, d2 float64) bool
+	accept func(entry bow.Bowed) bool // nil when there is no filter
+	null   *NullDistribution          // nil without significance estimates
+}
+
+func newCollector(opts SearchOptions, query bow.Bowed) *collector {
+	var closer func(d1, d2 float64) bool
+	switch opts.Order {
+	case OrderAsc:
+		closer = func(d1, d2 float64) bool { return d1 < d2 }
+	case OrderDesc:
+		closer = func(d1, d2 float64) bool { return d1 > d2 }
+	default:
+		panic(fmt.Sprintf("Unrecognized Order value: %d", opts.Order))
+	}
+	switch opts.SortBy {
+	case SortByCosine, SortByEuclid:
+	default:
+		panic(fmt.Sprintf("Unrecognized SortBy value: %d", opts.SortBy))
+	}
+	better := func(h1, h2 *hit) bool {
+		if h1.distance != h2.distance {
+			return closer(h1.distance, h2.distance)
+		}
+		if h1.Id != h2.Id {
+			return h1.Id < h2.Id
+		}
+		return h1.pos < h2.pos
+	}
+	return &collector{
+		opts:   opts,
+		query:  query,
+		heap:   hitHeap{better: better},
+		closer: closer,
+		accept: opts.Filter.accepter(query),
+	}
+}
+
+// full returns true if the collector has as many hits as the limit allows.
+func (c *collector) full() bool {
+	return c.opts.Limit >= 0 && len(c.heap.hits) >= c.opts.Limit
+}
+
+// wants returns true if an entry with the distance and position given might
+// be added to the current set of hits. It is a cheap check that doesn't need
+// the entry itself: an entry may still be rejected by insert once it is
+// known, either by the search's filter or because it has the same distance
+// as the worst hit but a greater ID.
+func (c *collector) wants(dist float64, pos int) bool {
+	// If the distance isn't in the min/max thresholds specified, skip it.
+	if dist > c.opts.Max || dist < c.opts.Min {
+		return false
+	}
+	if c.opts.Limit == 0 {
+		return false
+	}
+	if c.full() {
+		worst := c.heap.hits[0].distance
+		return dist == worst || c.closer(dist, worst)
+	}
+	return true
+}
+
+// beaten returns true if no entry with a distance of at least lowerBound
+// would be added to the current set of hits.
+func (c *collector) beaten(lowerBound float64) bool {
+	if c.opts.Order != OrderAsc {
+		return false
+	}
+	if lowerBound > c.opts.Max {
+		return true
+	}
+	return c.opts.Limit == 0 ||
+		(c.full() && lowerBound > c.heap.hits[0].distance)
+}
+
+// insert adds an entry to the set of hits if it passes the search's filter
+// and, when the limit has been reached, it is better than the worst hit. It
+// should only be called when wants returns true for the entry's distance and
+// position.
+func (c *collector) insert(entry bow.Bowed, dist float64, pos int) {
+	if c.accept != nil && !c.accept(entry) {
+		return
+	}
+	c.add(hit{entry, dist, pos})
+}
+
+// add is like insert, except the hit is not checked against the search's
+// filter. It is used to merge hits that have already been filtered.
+func (c *collector) add(h hit) {
+	if c.opts.Limit < 0 {
+		c.heap.hits = append(c.heap.hits, h)
+		return
+	}
+	if !c.full() {
+		heap.Push(&c.heap, h)
+		return
+	}
+	if c.heap.better(&h, &c.heap.hits[0]) {
+		c.heap.hits[0] = h
+		heap.Fix(&c.heap, 0)
+	}
+}
+
+// each calls f for every hit collected, in no particular order.
+func (c *collector) each(f func(h *hit)) {
+	for i := range c.heap.hits {
+		f(&c.heap.hits[i])
+	}
+}
+
+// results returns the hits collected in the order specified by the search
+// options. If the collector has a null distribution, the significance of
+// every hit is set and hits with an E-value greater than opts.MaxEValue are
+// dropped. No more hits may be inserted afterwards.
+func (c *collector) results() []SearchResult {
+	hits := c.heap.hits
+	sort.Slice(hits, func(i, j int) bool {
+		return c.heap.better(&hits[i], &hits[j])
+	})
+	results := make([]SearchResult, 0, len(hits))
+	for i := range hits {
+		r := newSearchResult(c.query, hits[i].Bowed)
+		if c.null != nil {
+			dist := hits[i].distance
+			r.ZScore = c.null.ZScore(dist)
+			r.PValue = c.null.PValue(dist)
+			r.EValue = c.null.EValue(dist)
+			if c.opts.MaxEValue > 0 && r.EValue > c.opts.MaxEValue {
+				continue
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
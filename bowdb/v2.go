@@ -0,0 +1,523 @@
+package bowdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+
+	"github.com/yunwilliamyu/esfragbag"
+	"github.com/yunwilliamyu/esfragbag/bow"
+)
+
+// The version 2 format is a single flat file designed to be mapped into
+// memory, so that a database can be opened and searched without decoding
+// it. All integers are big-endian. The file starts with a header:
+//
+//	magic        8 bytes, "BOWDBv2\n"
+//	layout       uint32, the layout of the file described here (v2Layout)
+//	library size uint32, the number of fragments in the library
+//	entries      uint64, the number of entries
+//	sections     6 pairs of uint64 (offset, length) from the start of the
+//	             file, in the order listed below
+//
+// followed by these sections:
+//
+//	library   The fragment library as JSON (as written by fragbag.Save).
+//	encoding  The name of the frequency encoding (e.g., "float32").
+//	offsets   entries+1 uint64 offsets into the blocks section. Entry i is
+//	          stored in [offsets[i], offsets[i+1]).
+//	blocks    One block per entry: the length of the ID and of the data as
+//	          uint32s, the ID, the data and the sparse BOW, which is encoded
+//	          exactly as in a version 1 database.
+//	norms     The squared magnitude of every entry's BOW as a float32.
+//	checksums The CRC-32C of each of the sections above as a uint32.
+//
+// The layout is 3. Files with layout 2 were written before the checksums
+// section was added. They cannot be opened, and must be converted from the
+// original database again. (DB.Version is 2 regardless of the layout.)
+//
+// A version 2 database has no tombstones, segments or stored inverted index.
+// It is written from an existing database with Convert, and cannot be
+// modified afterwards. Since a database is opened without reading it, Open
+// only checks the header and the sizes of the sections, in constant time.
+// The offsets of an entry are checked when it is read, and DB.Verify checks
+// every offset and the checksums.
+const (
+	v2Magic      = "BOWDBv2\n"
+	v2Layout     = 3
+	v2Sections   = 6
+	v2HeaderSize = len(v2Magic) + 4 + 4 + 8 + v2Sections*16
+)
+
+const (
+	v2SectionLibrary = iota
+	v2SectionEncoding
+	v2SectionOffsets
+	v2SectionBlocks
+	v2SectionNorms
+	v2SectionChecksums
+)
+
+// v2File is a version 2 database mapped into memory.
+type v2File struct {
+	data    []byte       // The entire file.
+	unmap   func() error // Releases data.
+	n       int          // The number of entries.
+	offsets []byte
+	blocks  []byte
+	norms   []byte
+
+	sections [v2Sections][]byte
+}
+
+// formatVersion returns the format version of the BOW database in f by
+// looking at its first bytes. The file offset is not changed.
+func formatVersion(f *os.File) (int, error) {
+	magic := make([]byte, len(v2Magic))
+	if _, err := f.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return 0, err
+	}
+	if string(magic) == v2Magic {
+		return 2, nil
+	}
+	return 1, nil
+}
+
+// openV2 maps the version 2 database in f into memory and reads its header,
+// fragment library and encoding.
+func (db *DB) openV2(f *os.File) error {
+	data, unmap, err := mapFile(f)
+	if err != nil {
+		return err
+	}
+	v2, err := db.readV2(data)
+	if err != nil {
+		unmap()
+		return fmt.Errorf("Could not read BOW database '%s': %s", db.Name, err)
+	}
+	v2.unmap = unmap
+	db.v2 = v2
+	db.Version = 2
+	db.tombstones = make(map[string]int)
+	return nil
+}
+
+func (db *DB) readV2(data []byte) (*v2File, error) {
+	if len(data) < v2HeaderSize || string(data[:len(v2Magic)]) != v2Magic {
+		return nil, fmt.Errorf("File is too short to have a header.")
+	}
+	hdr := data[len(v2Magic):]
+	switch layout := binary.BigEndian.Uint32(hdr[0:4]); layout {
+	case v2Layout:
+	case 2:
+		return nil, fmt.Errorf("File has layout 2 of format version 2, " +
+			"which has no checksums and is no longer supported. Convert " +
+			"the original database to version 2 again.")
+	default:
+		return nil, fmt.Errorf("Unknown layout %d of format version 2. It "+
+			"may have been written by a newer version of this package.",
+			layout)
+	}
+	libSize := int(binary.BigEndian.Uint32(hdr[4:8]))
+	numEntries := binary.BigEndian.Uint64(hdr[8:16])
+
+	var sections [v2Sections][]byte
+	for s := range sections {
+		pair := hdr[16+s*16:]
+		off := binary.BigEndian.Uint64(pair[0:8])
+		length := binary.BigEndian.Uint64(pair[8:16])
+		if off > uint64(len(data)) || length > uint64(len(data))-off {
+			return nil, fmt.Errorf("Section %d (offset %d, length %d) is "+
+				"past the end of the file (%d bytes).",
+				s, off, length, len(data))
+		}
+		sections[s] = data[off : off+length]
+	}
+	if numEntries > uint64(len(data)) {
+		return nil, fmt.Errorf("Header has %d entries, but the file only "+
+			"has %d bytes.", numEntries, len(data))
+	}
+	v2 := &v2File{
+		data:     data,
+		n:        int(numEntries),
+		offsets:  sections[v2SectionOffsets],
+		blocks:   sections[v2SectionBlocks],
+		norms:    sections[v2SectionNorms],
+		sections: sections,
+	}
+	if len(v2.offsets) != 8*(v2.n+1) {
+		return nil, fmt.Errorf("Offset table has %d bytes, but %d entries "+
+			"need %d bytes.", len(v2.offsets), v2.n, 8*(v2.n+1))
+	}
+	if len(v2.norms) != 4*v2.n {
+		return nil, fmt.Errorf("Norms have %d bytes, but %d entries "+
+			"need %d bytes.", len(v2.norms), v2.n, 4*v2.n)
+	}
+	if len(sections[v2SectionChecksums]) != 4*v2SectionChecksums {
+		return nil, fmt.Errorf("Checksums have %d bytes, but should have %d.",
+			len(sections[v2SectionChecksums]), 4*v2SectionChecksums)
+	}
+	lib, err := fragbag.Open(bytes.NewReader(sections[v2SectionLibrary]))
+	if err != nil {
+		return nil, err
+	}
+	if lib.Size() != libSize {
+		return nil, fmt.Errorf("Header has a library with %d fragments, "+
+			"but the embedded library has %d.", libSize, lib.Size())
+	}
+	enc, err := bow.NewEncoding(string(sections[v2SectionEncoding]))
+	if err != nil {
+		return nil, err
+	}
+	db.Lib, db.Encoding = lib, enc
+	return v2, nil
+}
+
+// verify checks every offset and every section against its checksum.
+func (v2 *v2File) verify() error {
+	var last uint64
+	for i := 0; i <= v2.n; i++ {
+		off := binary.BigEndian.Uint64(v2.offsets[8*i:])
+		if off < last || off > uint64(len(v2.blocks)) ||
+			(i > 0 && off-last < 8) {
+			return fmt.Errorf("Offset %d of entry %d is invalid.", off, i)
+		}
+		last = off
+	}
+
+	sums := v2.sections[v2SectionChecksums]
+	for s := 0; s < v2SectionChecksums; s++ {
+		want := binary.BigEndian.Uint32(sums[4*s:])
+		if got := crc32.Checksum(v2.sections[s], crcTable); got != want {
+			return fmt.Errorf("Section %d is corrupt: its checksum is %08x, "+
+				"but the file lists %08x.", s, got, want)
+		}
+	}
+	return nil
+}
+
+// block returns the ID, data and sparse BOW of the i'th entry. The slices
+// returned refer to the mapped file and must not be modified.
+//
+// A malformed block or offset is treated as an empty entry rather than
+// panicking in the middle of a search. (Verify reports it.)
+func (v2 *v2File) block(i int) ([]byte, []byte, []byte) {
+	start := binary.BigEndian.Uint64(v2.offsets[8*i:])
+	end := binary.BigEndian.Uint64(v2.offsets[8*(i+1):])
+	if start > end || end > uint64(len(v2.blocks)) || end-start < 8 {
+		return nil, nil, nil
+	}
+	b := v2.blocks[start:end]
+	idLen := uint64(binary.BigEndian.Uint32(b[0:4]))
+	dataLen := uint64(binary.BigEndian.Uint32(b[4:8]))
+	b = b[8:]
+	if idLen > uint64(len(b)) || dataLen > uint64(len(b))-idLen {
+		return nil, nil, nil
+	}
+	return b[:idLen], b[idLen : idLen+dataLen], b[idLen+dataLen:]
+}
+
+// mapped returns true if the entries of the database are read from the
+// mapped file of a version 2 database, rather than from memory.
+func (db *DB) mapped() bool {
+	return db.v2 != nil && db.Entries == nil && db.QuantizedEntries == nil
+}
+
+// v2Entry decodes the i'th entry of a version 2 database.
+func (db *DB) v2Entry(i int) bow.Bowed {
+	id, data, buf := db.v2.block(i)
+	entry := bow.Bowed{Id: string(id), Bow: bow.NewBow(db.Lib.Size())}
+	if len(data) > 0 {
+		entry.Data = append([]byte(nil), data...)
+	}
+	sparseFreqs(db.Encoding, buf, func(frag int, freq float32) {
+		entry.Bow.Freqs[frag] = freq
+	})
+	return entry
+}
+
+// norm returns the squared magnitude of the i'th entry's BOW.
+func (v2 *v2File) norm(i int) float32 {
+	return math.Float32frombits(binary.BigEndian.Uint32(v2.norms[4*i:]))
+}
+
+// readV2Raw is like readRaw for a version 2 database.
+func (db *DB) readV2Raw() (string, []byte, []byte, error) {
+	if db.v2Pos >= db.v2.n {
+		return "", nil, nil, io.EOF
+	}
+	id, data, freqs := db.v2.block(db.v2Pos)
+	db.v2Pos++
+	return string(id), data, freqs, nil
+}
+
+// sparseFreqs calls f with every fragment number and frequency in the
+// sparse BOW given, in order of fragment number. Quantized frequencies are
+// scaled exactly as bow.QuantizedBow.Freq scales them.
+func sparseFreqs(
+	enc bow.Encoding,
+	buf []byte,
+	f func(frag int, freq float32),
+) {
+	switch enc {
+	case bow.EncodingFloat32:
+		for i := 0; i+6 <= len(buf); i += 6 {
+			f(int(binary.BigEndian.Uint16(buf[i:i+2])),
+				math.Float32frombits(binary.BigEndian.Uint32(buf[i+2:i+6])))
+		}
+	case bow.EncodingUint8, bow.EncodingScalar8:
+		scale := float32(1)
+		if enc == bow.EncodingScalar8 {
+			if len(buf) < 4 {
+				return
+			}
+			scale = math.Float32frombits(binary.BigEndian.Uint32(buf[0:4]))
+			buf = buf[4:]
+		}
+		for i := 0; i+3 <= len(buf); i += 3 {
+			f(int(binary.BigEndian.Uint16(buf[i:i+2])), float32(buf[i+2])*scale)
+		}
+	case bow.EncodingUint16:
+		for i := 0; i+4 <= len(buf); i += 4 {
+			f(int(binary.BigEndian.Uint16(buf[i:i+2])),
+				float32(binary.BigEndian.Uint16(buf[i+2:i+4])))
+		}
+	default:
+		panic(fmt.Sprintf("Unrecognized encoding: %s", enc))
+	}
+}
+
+// squaredNorm returns the squared magnitude of a sparse BOW, accumulated in
+// the same order as bow.Bow.Cosine accumulates it.
+func squaredNorm(enc bow.Encoding, buf []byte) float32 {
+	var mag float32
+	sparseFreqs(enc, buf, func(frag int, freq float32) {
+		mag += freq * freq
+	})
+	return mag
+}
+
+// scanV2 is like scan, except distances are computed directly from the
+// mapped file, so that no entry needs to be decoded. Distances are exactly
+// the same as those computed by scan on the entries in memory: since zero
+// frequencies contribute nothing to a dot product, only the non-zero
+// frequencies of each entry are visited.
+func (db *DB) scanV2(
+	opts SearchOptions,
+	query bow.Bowed,
+) (int, func(i int) float64, func(i int) bow.Bowed) {
+	v2, enc := db.v2, db.Encoding
+	qfreqs := query.Bow.Freqs
+	var qmag float32
+	for _, f := range qfreqs {
+		qmag += f * f
+	}
+
+	cosine := func(i int) float64 {
+		_, _, buf := v2.block(i)
+		var dot float32
+		sparseFreqs(enc, buf, func(frag int, freq float32) {
+			dot += qfreqs[frag] * freq
+		})
+		mag := float64(qmag) * float64(v2.norm(i))
+		r := 1.0 - (float64(dot) / math.Sqrt(mag))
+		if math.IsNaN(r) {
+			return 1.0
+		}
+		return r
+	}
+	euclid := func(i int) float64 {
+		_, _, buf := v2.block(i)
+		var squareSum float32
+		next := 0
+		sparseFreqs(enc, buf, func(frag int, freq float32) {
+			for ; next < frag; next++ {
+				squareSum += qfreqs[next] * qfreqs[next]
+			}
+			d := freq - qfreqs[frag]
+			squareSum += d * d
+			next = frag + 1
+		})
+		for ; next < len(qfreqs); next++ {
+			squareSum += qfreqs[next] * qfreqs[next]
+		}
+		return math.Sqrt(float64(squareSum))
+	}
+	if opts.SortBy == SortByCosine {
+		return v2.n, cosine, db.v2Entry
+	}
+	return v2.n, euclid, db.v2Entry
+}
+
+// Convert writes the BOW database at src to dst with the format version
+// given, which must be 1 or 2. Deleted entries are not copied. The entries,
+// their encoding and the fragment library are otherwise unchanged, so that
+// searching either database gives the same results.
+//
+// A version 2 database can be opened and searched almost instantly, since it
+// is mapped into memory instead of being read. (Search does not need to call
+// ReadAll.) But it cannot be appended to or compacted. Convert it back to
+// version 1 first.
+func Convert(src, dst string, version int) error {
+	db, err := Open(src)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch version {
+	case 1:
+		out, err := CreateEncoded(db.Lib, dst, db.Encoding)
+		if err != nil {
+			return err
+		}
+		if err := db.copyRaw(out); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	case 2:
+		return db.writeV2(dst)
+	}
+	return fmt.Errorf("Unknown BOW database format version %d.", version)
+}
+
+// writeV2 writes every entry of the database to a new version 2 database
+// at fpath.
+func (db *DB) writeV2(fpath string) (err error) {
+	if _, err := os.Stat(fpath); err == nil || !os.IsNotExist(err) {
+		return fmt.Errorf("BOW database '%s' already exists.", fpath)
+	}
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(fpath)
+		}
+	}()
+
+	w := &countingWriter{
+		w:   bufio.NewWriterSize(f, 1<<20),
+		crc: crc32.New(crcTable),
+	}
+	var sections [v2Sections][2]uint64
+	var sums [v2SectionChecksums]uint32
+	begin := func(s int) {
+		sections[s][0] = uint64(w.n)
+		w.crc.Reset()
+	}
+	end := func(s int) {
+		sections[s][1] = uint64(w.n) - sections[s][0]
+		if s < v2SectionChecksums {
+			sums[s] = w.crc.Sum32()
+		}
+	}
+
+	if _, err := w.Write(make([]byte, v2HeaderSize)); err != nil {
+		return err
+	}
+
+	begin(v2SectionLibrary)
+	if err := fragbag.Save(w, db.Lib); err != nil {
+		return fmt.Errorf("Could not copy fragment library: %s", err)
+	}
+	end(v2SectionLibrary)
+
+	begin(v2SectionEncoding)
+	if _, err := io.WriteString(w, db.Encoding.String()); err != nil {
+		return err
+	}
+	end(v2SectionEncoding)
+
+	begin(v2SectionBlocks)
+	var offsets []uint64
+	var norms []float32
+	var lens [8]byte
+	for {
+		id, data, freqs, err := db.readRaw()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		offsets = append(offsets, uint64(w.n)-sections[v2SectionBlocks][0])
+		norms = append(norms, squaredNorm(db.Encoding, freqs))
+
+		binary.BigEndian.PutUint32(lens[0:4], uint32(len(id)))
+		binary.BigEndian.PutUint32(lens[4:8], uint32(len(data)))
+		if _, err := w.Write(lens[:]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, id); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if _, err := w.Write(freqs); err != nil {
+			return err
+		}
+	}
+	end(v2SectionBlocks)
+	offsets = append(offsets, sections[v2SectionBlocks][1])
+
+	begin(v2SectionOffsets)
+	if err := binw(w, offsets); err != nil {
+		return err
+	}
+	end(v2SectionOffsets)
+
+	begin(v2SectionNorms)
+	if err := binw(w, norms); err != nil {
+		return err
+	}
+	end(v2SectionNorms)
+
+	begin(v2SectionChecksums)
+	if err := binw(w, sums); err != nil {
+		return err
+	}
+	end(v2SectionChecksums)
+
+	if err := w.w.(*bufio.Writer).Flush(); err != nil {
+		return err
+	}
+
+	hdr := new(bytes.Buffer)
+	hdr.WriteString(v2Magic)
+	header := []interface{}{
+		uint32(v2Layout), uint32(db.Lib.Size()), uint64(len(norms)), sections,
+	}
+	for _, v := range header {
+		if err := binw(hdr, v); err != nil {
+			return err
+		}
+	}
+	_, err = f.WriteAt(hdr.Bytes(), 0)
+	return err
+}
+
+// countingWriter counts the bytes written to it and computes their checksum.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	crc hash.Hash32
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.crc.Write(p[:n])
+	return n, err
+}
@@ -0,0 +1,144 @@
+package bowdb
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatV2(t *testing.T) {
+	lib := testLibrary(t)
+	rng := rand.New(rand.NewSource(5))
+	entries := testEntries(rng, 500, lib)
+	queries := testEntries(rng, 5, lib)
+
+	for _, enc := range testEncodings {
+		dir, err := ioutil.TempDir("", "bowdb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		v1Path := filepath.Join(dir, "v1.bowdb")
+		createTestDB(t, lib, v1Path, enc, entries)
+		v2Path := filepath.Join(dir, "v2.bowdb")
+		if err := Convert(v1Path, v2Path, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		v1, err := Open(v1Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v2, err := Open(v2Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v1.Version != 1 || v2.Version != 2 || v2.Encoding != enc {
+			t.Fatalf("Got versions %d and %d with encoding %s.",
+				v1.Version, v2.Version, v2.Encoding)
+		}
+		for _, query := range queries {
+			for _, sortBy := range []int{SortByCosine, SortByEuclid} {
+				opts := SearchDefault
+				opts.SortBy = sortBy
+				want := v1.Search(opts, query)
+				got := v2.Search(opts, query)
+				if !reflect.DeepEqual(want, got) {
+					t.Fatalf("Version 2 search (%s, %+v) differs:\n"+
+						"want: %s\ngot:  %s", enc, opts,
+						resultIds(want), resultIds(got))
+				}
+			}
+		}
+		if v2.Entries != nil || v2.QuantizedEntries != nil {
+			t.Fatalf("Searching a version 2 database read its entries.")
+		}
+
+		if _, err := OpenAppend(lib, v2Path); err == nil {
+			t.Fatalf("Opened a version 2 database for appending.")
+		}
+		v1.Close()
+		v2.Close()
+
+		// Converting back must give the original entries.
+		v1Again := filepath.Join(dir, "v1-again.bowdb")
+		if err := Convert(v2Path, v1Again, 1); err != nil {
+			t.Fatal(err)
+		}
+		want := readAll(t, v1Path)
+		if got := readAll(t, v1Again); !reflect.DeepEqual(want, got) {
+			t.Fatalf("Entries differ after converting to version 2 and " +
+				"back.")
+		}
+		if got := readAll(t, v2Path); !reflect.DeepEqual(want, got) {
+			t.Fatalf("Entries of the version 2 database differ.")
+		}
+
+		// A corrupt offset is only found when the entry is read, or by
+		// Verify. The entry is then empty.
+		good, err := ioutil.ReadFile(v2Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pair := len(v2Magic) + 16 + v2SectionOffsets*16
+		offsets := binary.BigEndian.Uint64(good[pair:])
+		corrupt := append([]byte(nil), good...)
+		binary.BigEndian.PutUint64(corrupt[offsets+8*10:], 1<<40)
+		bad := filepath.Join(dir, "bad.bowdb")
+		if err := ioutil.WriteFile(bad, corrupt, 0644); err != nil {
+			t.Fatal(err)
+		}
+		db, err := Open(bad)
+		if err != nil {
+			t.Fatalf("Could not open a database with a corrupt offset: %s", err)
+		}
+		if e := db.v2Entry(10); e.Id != "" || e.Bow.Magnitude() != 0 {
+			t.Fatalf("Read entry '%s' with a corrupt offset.", e.Id)
+		}
+		db.Search(SearchDefault, queries[0])
+		if err := db.Verify(); err == nil ||
+			!strings.Contains(err.Error(), "Offset") {
+			t.Fatalf("Expected an invalid offset, but got: %v", err)
+		}
+		db.Close()
+
+		// Files with another layout must not open.
+		for layout, errMsg := range map[uint32]string{
+			2: "layout 2 of format version 2", 99: "Unknown layout 99",
+		} {
+			old := append([]byte(nil), good...)
+			binary.BigEndian.PutUint32(old[len(v2Magic):], layout)
+			if err := ioutil.WriteFile(bad, old, 0644); err != nil {
+				t.Fatal(err)
+			}
+			db, err := Open(bad)
+			if err == nil {
+				db.Close()
+				t.Fatalf("Opened a version 2 database with layout %d.", layout)
+			}
+			if !strings.Contains(err.Error(), errMsg) {
+				t.Fatalf("Expected an error containing %q, but got: %s",
+					errMsg, err)
+			}
+		}
+
+		// A truncated file must not open.
+		info, err := os.Stat(v2Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(v2Path, info.Size()-1); err != nil {
+			t.Fatal(err)
+		}
+		if db, err := Open(v2Path); err == nil {
+			db.Close()
+			t.Fatalf("Opened a truncated version 2 database.")
+		}
+	}
+}
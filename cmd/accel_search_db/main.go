@@ -10,6 +10,7 @@ import (
     //"strconv"
     "encoding/gob"
     "math/rand"
+    "os"
 
     "github.com/yunwilliamyu/esfragbag/bow"
     "github.com/yunwilliamyu/esfragbag/bowdb"
@@ -30,9 +31,12 @@ var (
     metricFlag = ""
     potentialTargetsLoc = ""
     maxRadius = 0.0
-    clusterRadius = 10000
+    clusterRadius = 10000.0
     lasttime = time.Now().UTC().UnixNano()
     gobLoc = "clusters.gob"
+    explain = false
+    queryId = ""
+    explainTop = 10
 )
 
 
@@ -45,7 +49,10 @@ func init() {
     flag.StringVar(&metricFlag, "metricFlag", metricFlag, "Choice of metric to use; valid options are 'cosine' and 'euclidean'")
     flag.StringVar(&potentialTargetsLoc, "potentialTargets", potentialTargetsLoc, "the location of the full fragment library database")
     flag.Float64Var(&maxRadius, "maxRadius", maxRadius, "maximum radius to search in")
-    flag.IntVar(&clusterRadius, "clusterRadius", clusterRadius, "maximum cluster radius in database")
+    flag.Float64Var(&clusterRadius, "clusterRadius", clusterRadius, "maximum cluster radius in database, as printed by create_db")
+    flag.StringVar(&queryId, "queryId", queryId, "the identifier of the entry in the search query library to use as the query (defaults to the first entry)")
+    flag.BoolVar(&explain, "explain", explain, "when set, explain every accelerated hit fragment by fragment")
+    flag.IntVar(&explainTop, "explainTop", explainTop, "the number of fragments shown in each explanation")
 
     flag.Parse()
 
@@ -92,9 +99,19 @@ func main() {
 
     fmt.Println("Loading query")
     db_query, _ := bowdb.Open(searchQuery)
-    db_query.ReadAll()
     var query bow.Bowed
-    query = db_query.Entries[0]
+    if queryId == "" {
+        db_query.ReadAll()
+        if len(db_query.Entries) == 0 {
+            log.Fatalf("No entries in '%s'", searchQuery)
+        }
+        query = db_query.Entries[0]
+    } else {
+        var ok bool
+        if query, ok = db_query.Get(queryId); !ok {
+            log.Fatalf("No entry with identifier '%s' in '%s'", queryId, searchQuery)
+        }
+    }
     fmt.Println(fmt.Sprintf("\t%d",timer()))
 
     fmt.Println(fmt.Sprintf("Opening centers library"))
@@ -176,4 +193,14 @@ func main() {
     fmt.Println("")
     fmt.Println(fmt.Sprintf("Accel:\t%d",coarse_results_time+fine_results_time))
     fmt.Println(fmt.Sprintf("Naive:\t%d",long_results_time))
+
+    if explain {
+        for _, result := range fine_results {
+            fmt.Println("")
+            ex := bow.Explain(query, result.Bowed)
+            if err := ex.Report(os.Stdout, db.Lib, explainTop); err != nil {
+                log.Fatal("explain error:", err)
+            }
+        }
+    }
 }
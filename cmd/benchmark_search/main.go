@@ -5,7 +5,7 @@ import (
     "log"
     "fmt"
     "time"
-//    "os"
+    "os"
     "bytes"
     "io/ioutil"
     //"strconv"
@@ -33,6 +33,8 @@ var (
     lasttime = time.Now().UTC().UnixNano()
     gobLoc = "clusters.gob"
     repeatNum = 10
+    explain = false
+    explainTop = 10
 )
 
 
@@ -46,6 +48,8 @@ func init() {
     flag.StringVar(&potentialTargetsLoc, "potentialTargets", potentialTargetsLoc, "the location of the full fragment library database")
     flag.Float64Var(&clusterRadius, "clusterRadius", clusterRadius, "maximum cluster radius in database")
     flag.IntVar(&repeatNum, "repeatNum", repeatNum, "number of trials for each data point (default 10)")
+    flag.BoolVar(&explain, "explain", explain, "when set, explain every accelerated hit at the largest radius fragment by fragment, after the benchmark table")
+    flag.IntVar(&explainTop, "explainTop", explainTop, "the number of fragments shown in each explanation")
 
     flag.Parse()
 
@@ -131,6 +135,7 @@ func main() {
     db.ReadAll()
 
     //repeatNum := 10 // How many times to repeat each run for timing purposes
+    var lastHits []bowdb.SearchResult // Accelerated hits at the largest radius.
     fmt.Println("Radius\tAccelCount\tLongCount\tAccel\tNaive\tSpeedup\tSensitivity\tFineCandidates")
     for maxR := 0; maxR < 50; maxR=maxR+1 {
         maxRadius := 0.0
@@ -220,6 +225,7 @@ func main() {
             accelTime[rep] = accel_time
             naiveTime[rep] = long_results_time
             fineCandidates[rep] = fine_candidates
+            lastHits = fine_results
         }
         accelCountAvg := averageInt2F64(accelCount)
         naiveCountAvg := averageInt2F64(longCount)
@@ -231,4 +237,14 @@ func main() {
         fmt.Println(fmt.Sprintf("%f\t%f\t%f\t%f\t%f\t%f\t%f\t%f",maxRadius,accelCountAvg,naiveCountAvg,accelTimeAvg,naiveTimeAvg,speedup,sensitivity,fineSearchCount))
     }
 
+    if explain {
+        for _, result := range lastHits {
+            fmt.Println("")
+            ex := bow.Explain(query, result.Bowed)
+            if err := ex.Report(os.Stdout, db.Lib, explainTop); err != nil {
+                log.Fatal("explain error:", err)
+            }
+        }
+    }
+
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/yunwilliamyu/esfragbag/bowdb"
+)
+
+var (
+	flagIndex = false
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.BoolVar(&flagIndex, "index", flagIndex, "when set, an inverted index is stored in each database after it is compacted")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s bow-db-file [bow-db-file ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nRewrites each BOW database without its deleted entries.\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	for _, fpath := range flag.Args() {
+		if err := bowdb.Compact(fpath); err != nil {
+			log.Fatalf("Could not compact BOW database '%s': %s", fpath, err)
+		}
+		if flagIndex {
+			if err := bowdb.WriteInvertedIndex(fpath); err != nil {
+				log.Fatalf("Could not index BOW database '%s': %s", fpath, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/yunwilliamyu/esfragbag/bowdb"
+)
+
+var (
+	flagVersion = 2
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.IntVar(&flagVersion, "version", flagVersion, "the format version to write: 1 (a TAR archive that can be appended to) or 2 (a file that is mapped into memory and can be searched without reading it)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s in-bow-db-file out-bow-db-file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nWrites a copy of a BOW database with a different format version.\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	src, dst := flag.Arg(0), flag.Arg(1)
+	if err := bowdb.Convert(src, dst, flagVersion); err != nil {
+		log.Fatalf("Could not convert BOW database '%s': %s", src, err)
+	}
+}
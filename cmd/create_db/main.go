@@ -39,6 +39,7 @@ var (
     centerType = randomSelec
     kCenterAlg = ""
     maxRadius = -1.0
+    refineFlag = "none"
     lasttime = time.Now().UTC().UnixNano()
 )
 
@@ -53,6 +54,7 @@ func init() {
     flag.StringVar(&metricFlag, "metricFlag", metricFlag, "Choice of metric to use; valid options are 'cosine' and 'euclidean'")
     flag.StringVar(&kCenterAlg, "kCenterAlg", kCenterAlg, "Choice of which KCenter algorithm to use; valid options are 'metricApprox', 'random', and 'halfhalf'")
     flag.Float64Var(&maxRadius, "maxRadius", maxRadius, "maximum cluster radius as an float; if set, this will supercede numCenters")
+    flag.StringVar(&refineFlag, "refine", refineFlag, "how to refine each center after clustering; valid options are 'none', 'medoid' (the cluster entry closest to every other entry) and 'mean' (the centroid of the cluster, named after its center); entries keep their clusters, so they may end up farther than maxRadius from a refined center")
 
     flag.Parse()
 
@@ -73,6 +75,10 @@ func init() {
         centerType = halfhalf
     }
 
+    if refineFlag != "medoid" && refineFlag != "mean" && refineFlag != "none" {
+        log.Fatalf("Unrecognized refinement '%s'.", refineFlag)
+    }
+
 }
 
 func maxRadiusKCenter (db []bow.Bowed, optDist distType, r float64) []bow.Bowed {
@@ -172,6 +178,42 @@ func distanceFromSet (optDist distType, query bow.Bowed, set []bow.Bowed) (float
     return minDist, bestResult, bestIndex
 }
 
+// Replaces every center with the medoid or the mean of the entries assigned to
+// it, and updates the distance of every entry to its center. Assignments are
+// not changed, so an entry may be farther from its refined center than from
+// the original one. A mean center keeps the ID of the center it replaces.
+func refineCenters(db []bow.Bowed, optDist distType, centers []bow.Bowed, codes []int, distances []float64) {
+    dist := func(b1, b2 bow.Bow) float64 {
+        if optDist == euclideanDist {
+            return b1.Euclid(b2)
+        }
+        return b1.Cosine(b2)
+    }
+
+    members := make([][]int, len(centers))
+    for j := range db {
+        members[codes[j]] = append(members[codes[j]], j)
+    }
+    for i, cluster := range members {
+        if len(cluster) == 0 {
+            continue
+        }
+        bows := make([]bow.Bow, len(cluster))
+        for k, j := range cluster {
+            bows[k] = db[j].Bow
+        }
+        switch refineFlag {
+        case "medoid":
+            centers[i] = db[cluster[bow.Medoid(bows, dist)]]
+        case "mean":
+            centers[i] = bow.Bowed{Id: centers[i].Id, Bow: bow.Mean(bows)}
+        }
+    }
+    for j := range db {
+        distances[j] = dist(centers[codes[j]].Bow, db[j].Bow)
+    }
+}
+
 func enc_gob_ss_db(db_slices [][]bow.Bowed, name string) {
     f, err := os.Create(name)
     defer f.Close()
@@ -235,6 +277,11 @@ func main() {
     }
     runtime.GOMAXPROCS(20)
 
+    if refineFlag != "none" {
+        fmt.Println(fmt.Sprintf("%d: Refining cluster centers (%s)", timer(), refineFlag))
+        refineCenters(db.Entries, metric, kCenters, db_codes, distances)
+    }
+
     fmt.Println(fmt.Sprintf("%d: Writing out centers.cluster.db", timer()))
     db_centers, _ := bowdb.Create(db.Lib, "centers.cluster.db")
     for _, center := range kCenters {
@@ -283,6 +330,16 @@ func main() {
     for j, entry := range kCenters {
         fmt.Println(entry.Id + fmt.Sprintf("\t%f\t%d", cluster_radii[j], cluster_count[j] ))
     }
+
+    // The largest distance from an entry to its (possibly refined) center is
+    // what accel_search_db needs to avoid missing results.
+    maxClusterRadius := 0.0
+    for _, r := range cluster_radii {
+        if r > maxClusterRadius {
+            maxClusterRadius = r
+        }
+    }
+    fmt.Println(fmt.Sprintf("Maximum cluster radius: %v (use as -clusterRadius for accel_search_db)", maxClusterRadius))
     fmt.Println(fmt.Sprintf("%d: Finished!!",timer()))
 
 
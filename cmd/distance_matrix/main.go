@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/yunwilliamyu/esfragbag/bowdb"
+)
+
+var (
+	metricFlag = "cosine"
+	maxDist    = -1.0
+	chunkRows  = bowdb.MatrixDefault.ChunkRows
+	numWorkers = bowdb.MatrixDefault.Workers
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.StringVar(&metricFlag, "metric", metricFlag, "the distance metric; valid options are 'cosine' and 'euclid'")
+	flag.Float64Var(&maxDist, "max", maxDist, "when non-negative, a sparse matrix is written with only the pairs of entries at most this distance apart")
+	flag.IntVar(&chunkRows, "chunk", chunkRows, "the number of rows computed in memory before they are written")
+	flag.IntVar(&numWorkers, "workers", numWorkers, "the number of goroutines computing distances")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] bow-db-file out-file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nA dense matrix is written as big-endian float32 values in row-major order.\n")
+		fmt.Fprintf(os.Stderr, "A sparse matrix is written as lines of 'id1<TAB>id2<TAB>distance'.\n\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	opts := bowdb.MatrixDefault
+	switch metricFlag {
+	case "cosine":
+		opts.SortBy = bowdb.SortByCosine
+	case "euclid":
+		opts.SortBy = bowdb.SortByEuclid
+	default:
+		log.Fatalf("Unrecognized metric '%s'.", metricFlag)
+	}
+	if maxDist >= 0 {
+		opts.Sparse = true
+		opts.Max = maxDist
+	}
+	opts.ChunkRows = chunkRows
+	opts.Workers = numWorkers
+
+	db, err := bowdb.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Could not open BOW database '%s': %s", flag.Arg(0), err)
+	}
+	defer db.Close()
+
+	out, err := os.Create(flag.Arg(1))
+	if err != nil {
+		log.Fatalf("Could not create '%s': %s", flag.Arg(1), err)
+	}
+	if err := db.WriteDistanceMatrix(out, opts); err != nil {
+		log.Fatalf("Could not write distance matrix: %s", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Could not write distance matrix: %s", err)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TuftsBCB/io/fasta"
+	"github.com/TuftsBCB/seq"
+	"github.com/yunwilliamyu/esfragbag"
+	"github.com/yunwilliamyu/esfragbag/bow"
+	"github.com/yunwilliamyu/esfragbag/bowdb"
+)
+
+var (
+	fragmentLibraryLoc = ""
+	numWorkers         = runtime.NumCPU()
+	idRule             = "first"
+	progressEvery      = 10000
+	resume             = false
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.StringVar(&fragmentLibraryLoc, "fragLib", fragmentLibraryLoc, "the location of the sequence fragment library")
+	flag.IntVar(&numWorkers, "workers", numWorkers, "the number of goroutines computing BOWs")
+	flag.StringVar(&idRule, "id", idRule, "how to get an identifier from a FASTA header; valid options are 'first' (the first field), 'full' (the whole header), 'uniprot' (the accession in 'sp|P12345|NAME') and 'regexp:EXPR' (the first submatch of EXPR)")
+	flag.IntVar(&progressEvery, "progress", progressEvery, "report progress after this many sequences; 0 disables progress reports")
+	flag.BoolVar(&resume, "resume", resume, "resume an interrupted run by skipping sequences already in its journal")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -fragLib frag-lib-file [flags] bow-db-file fasta-file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nA FASTA file of '-' reads from stdin.\n")
+		fmt.Fprintf(os.Stderr, "BOWs are journaled to 'bow-db-file.journal' until the database is written.\n")
+		fmt.Fprintf(os.Stderr, "If writing the database fails, it is removed and the journal is kept.\n\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if len(fragmentLibraryLoc) == 0 || flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+}
+
+// sequenceIdFunc returns the function corresponding to an identifier rule.
+func sequenceIdFunc(rule string) (bow.SequenceIdFunc, error) {
+	switch {
+	case rule == "first":
+		return bow.SequenceIdFirstField, nil
+	case rule == "full":
+		return strings.TrimSpace, nil
+	case rule == "uniprot":
+		return func(name string) string {
+			first := bow.SequenceIdFirstField(name)
+			if pieces := strings.Split(first, "|"); len(pieces) >= 3 {
+				return pieces[1]
+			}
+			return first
+		}, nil
+	case strings.HasPrefix(rule, "regexp:"):
+		re, err := regexp.Compile(rule[len("regexp:"):])
+		if err != nil {
+			return nil, err
+		}
+		if re.NumSubexp() < 1 {
+			return nil, fmt.Errorf("Regexp '%s' has no submatch.", re)
+		}
+		return func(name string) string {
+			if m := re.FindStringSubmatch(name); m != nil {
+				return m[1]
+			}
+			return bow.SequenceIdFirstField(name)
+		}, nil
+	}
+	return nil, fmt.Errorf("Unrecognized identifier rule '%s'.", rule)
+}
+
+func main() {
+	dbPath, fastaPath := flag.Arg(0), flag.Arg(1)
+	journalPath := dbPath + ".journal"
+
+	idf, err := sequenceIdFunc(idRule)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lib := openSequenceLibrary(fragmentLibraryLoc)
+	if _, err := os.Stat(dbPath); err == nil {
+		log.Fatalf("BOW database '%s' already exists.", dbPath)
+	}
+
+	done := make(map[string]bool)
+	if _, err := os.Stat(journalPath); err == nil {
+		if !resume {
+			log.Fatalf("Journal '%s' exists. Use -resume to continue the "+
+				"run that created it.", journalPath)
+		}
+		done, err = readJournal(journalPath, lib.Size())
+		if err != nil {
+			log.Fatalf("Could not resume from '%s': %s", journalPath, err)
+		}
+		log.Printf("Resuming with %d sequences already computed.", len(done))
+	}
+
+	journalf, err := os.OpenFile(journalPath,
+		os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("Could not open journal '%s': %s", journalPath, err)
+	}
+	if err := computeBows(lib, idf, fastaPath, done, journalf); err != nil {
+		journalf.Close()
+		log.Fatalf("Stopped reading '%s': %s\nThe journal '%s' has been "+
+			"kept; use -resume to continue.", fastaPath, err, journalPath)
+	}
+	if err := journalf.Close(); err != nil {
+		log.Fatalf("Could not close journal '%s': %s", journalPath, err)
+	}
+
+	n, err := writeDB(lib, journalPath, dbPath)
+	if err != nil {
+		log.Fatalf("Could not write BOW database '%s': %s\nThe journal '%s' "+
+			"has been kept; use -resume to write it again.",
+			dbPath, err, journalPath)
+	}
+	if err := os.Remove(journalPath); err != nil {
+		log.Fatalf("Could not remove journal '%s': %s", journalPath, err)
+	}
+	log.Printf("Wrote %d entries to '%s'.", n, dbPath)
+}
+
+func openSequenceLibrary(fpath string) fragbag.SequenceLibrary {
+	flib, err := os.Open(fpath)
+	if err != nil {
+		log.Fatalf("Could not open fragment library '%s': %s", fpath, err)
+	}
+	defer flib.Close()
+
+	lib, err := fragbag.Open(flib)
+	if err != nil {
+		log.Fatalf("Could not read fragment library '%s': %s", fpath, err)
+	}
+	if !fragbag.IsSequence(lib) {
+		log.Fatalf("Fragment library '%s' is not a sequence library.", fpath)
+	}
+	return lib.(fragbag.SequenceLibrary)
+}
+
+// computeBows reads every sequence in the FASTA file, computes its BOW on a
+// pool of workers and appends it to the journal. Sequences with identifiers
+// in `done` are skipped.
+func computeBows(
+	lib fragbag.SequenceLibrary,
+	idf bow.SequenceIdFunc,
+	fastaPath string,
+	done map[string]bool,
+	journal io.Writer,
+) error {
+	var r io.Reader = os.Stdin
+	if fastaPath != "-" {
+		f, err := os.Open(fastaPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	seqs := make(chan seq.Sequence, numWorkers*2)
+	bowed := make(chan bow.Bowed, numWorkers*2)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range seqs {
+				bowed <- bow.BowerFromSequenceId(s, idf).SequenceBow(lib)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(bowed)
+	}()
+
+	// A single goroutine owns the journal. Each entry is flushed as soon as
+	// it is written, so that an interrupted run loses at most one entry.
+	journalDone := make(chan error, 1)
+	go func() {
+		var jerr error
+		buf := bufio.NewWriter(journal)
+		enc := bow.NewJSONEncoder(buf, bow.DataBase64)
+		for b := range bowed {
+			if jerr != nil {
+				continue
+			}
+			if jerr = enc.Encode(b); jerr == nil {
+				jerr = buf.Flush()
+			}
+		}
+		journalDone <- jerr
+	}()
+
+	var readErr error
+	start := time.Now()
+	read, skipped := 0, 0
+	fr := fasta.NewReader(r)
+	for {
+		s, err := fr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			readErr = err
+			break
+		}
+
+		read++
+		if done[idf(s.Name)] {
+			skipped++
+		} else {
+			seqs <- s
+		}
+		if progressEvery > 0 && read%progressEvery == 0 {
+			log.Printf("%d sequences read (%d skipped) in %s.",
+				read, skipped, time.Since(start))
+		}
+	}
+	close(seqs)
+	if err := <-journalDone; err != nil {
+		return fmt.Errorf("Could not write journal: %s", err)
+	}
+	if readErr != nil {
+		return readErr
+	}
+	log.Printf("%d sequences read (%d skipped) in %s.",
+		read, skipped, time.Since(start))
+	return nil
+}
+
+// readJournal returns the identifiers of every entry in the journal. If the
+// last entry was only partially written, it is removed from the journal.
+func readJournal(journalPath string, libSize int) (map[string]bool, error) {
+	f, err := os.Open(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	done := make(map[string]bool)
+	good := int64(0)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		dec := bow.NewJSONDecoder(bytes.NewReader(line), libSize,
+			bow.DataBase64)
+		b, err := dec.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt journal entry at byte %d: %s",
+				good, err)
+		}
+		done[b.Id] = true
+		good += int64(len(line))
+	}
+	if err := os.Truncate(journalPath, good); err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// writeDB creates a BOW database from every entry in the journal.
+//
+// If any entry cannot be read or the database cannot be written, the
+// database is removed, so that it isn't mistaken for a complete one and so
+// that it can be written again with '-resume'. The journal remains on disk
+// either way.
+func writeDB(lib fragbag.Library, journalPath, dbPath string) (int, error) {
+	f, err := os.Open(journalPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	db, err := bowdb.Create(lib, dbPath)
+	if err != nil {
+		return 0, err
+	}
+	n, err := addJournal(db, f, lib.Size())
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dbPath)
+		return n, err
+	}
+	return n, nil
+}
+
+// addJournal adds every entry in the journal to the database.
+func addJournal(db *bowdb.DB, journal io.Reader, libSize int) (int, error) {
+	n := 0
+	dec := bow.NewJSONDecoder(bufio.NewReader(journal), libSize,
+		bow.DataBase64)
+	for {
+		b, err := dec.Decode()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		db.Add(b)
+		n++
+	}
+}
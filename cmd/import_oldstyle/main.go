@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/yunwilliamyu/esfragbag"
+	"github.com/yunwilliamyu/esfragbag/bow"
+	"github.com/yunwilliamyu/esfragbag/bowdb"
+)
+
+var (
+	fragmentLibraryLoc = ""
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.StringVar(&fragmentLibraryLoc, "fragLib", fragmentLibraryLoc, "the location of the fragment library used to compute the old-style BOWs")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -fragLib frag-lib-file bow-db-file oldstyle-file [oldstyle-file ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nAn old-style file of '-' reads from stdin.\n\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if len(fragmentLibraryLoc) == 0 || flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	flib, err := os.Open(fragmentLibraryLoc)
+	if err != nil {
+		log.Fatalf("Could not open fragment library '%s': %s", fragmentLibraryLoc, err)
+	}
+	lib, err := fragbag.Open(flib)
+	if err != nil {
+		log.Fatalf("Could not read fragment library '%s': %s", fragmentLibraryLoc, err)
+	}
+	flib.Close()
+
+	db, err := bowdb.Create(lib, flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Could not create BOW database '%s': %s", flag.Arg(0), err)
+	}
+
+	added, malformed := 0, 0
+	for _, fpath := range flag.Args()[1:] {
+		a, m, err := importFile(db, lib.Size(), fpath)
+		added, malformed = added+a, malformed+m
+		if err != nil {
+			log.Printf("Could not read '%s': %s", fpath, err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		log.Fatalf("Could not close BOW database '%s': %s", flag.Arg(0), err)
+	}
+	log.Printf("Imported %d entries (%d malformed lines skipped).", added, malformed)
+}
+
+// importFile adds every well-formed line of an old-style file to the
+// database. Malformed lines are reported and skipped.
+func importFile(db *bowdb.DB, libSize int, fpath string) (int, int, error) {
+	var r io.Reader = os.Stdin
+	if fpath != "-" {
+		f, err := os.Open(fpath)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	added, malformed := 0, 0
+	oldr := bow.NewOldStyleReader(r, libSize)
+	for {
+		b, err := oldr.Read()
+		if err == io.EOF {
+			return added, malformed, nil
+		} else if oerr, ok := err.(*bow.OldStyleError); ok {
+			log.Printf("%s:%d: %s", fpath, oerr.Line, oerr.Err)
+			malformed++
+			continue
+		} else if err != nil {
+			return added, malformed, err
+		}
+		db.Add(b)
+		added++
+	}
+}
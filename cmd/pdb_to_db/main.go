@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	path "path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/TuftsBCB/io/pdb"
+	"github.com/TuftsBCB/io/pdbx"
+	"github.com/yunwilliamyu/esfragbag"
+	"github.com/yunwilliamyu/esfragbag/bow"
+	"github.com/yunwilliamyu/esfragbag/bowdb"
+)
+
+var (
+	fragmentLibraryLoc = ""
+	bowerFlag          = "chain"
+	ensembleFlag       = "model"
+	domainsLoc         = ""
+	skipLoc            = ""
+	numWorkers         = runtime.NumCPU()
+	update             = false
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.StringVar(&fragmentLibraryLoc, "fragLib", fragmentLibraryLoc, "the location of the structure fragment library")
+	flag.StringVar(&bowerFlag, "bower", bowerFlag, "how BOWs are computed for each file; valid options are 'chain', 'entity', 'assembly', 'ensemble' and 'domain'")
+	flag.StringVar(&ensembleFlag, "ensemble", ensembleFlag, "with '-bower ensemble', how models are combined; valid options are 'model', 'mean', 'min' and 'max'")
+	flag.StringVar(&domainsLoc, "domains", domainsLoc, "with '-bower domain', the location of a SCOP/CATH style domain boundary file")
+	flag.StringVar(&skipLoc, "skip", skipLoc, "the location of a file with one PDB identifier, file name or BOW identifier per line to skip")
+	flag.IntVar(&numWorkers, "workers", numWorkers, "the number of goroutines computing BOWs")
+	flag.BoolVar(&update, "update", update, "when set, an existing BOW database is updated in place: entries replace existing entries with the same identifier")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -fragLib frag-lib-file [flags] bow-db-file (dir | file) ...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nDirectories are searched recursively for PDB (.pdb, .ent) and PDBx/mmCIF (.cif) files, which may be gzipped.\n\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if len(fragmentLibraryLoc) == 0 || flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+}
+
+// bower computes the Bowed values of a single file.
+type bower struct {
+	lib      fragbag.StructureLibrary
+	domains  map[string][]bow.Domain
+	entry    bow.EntryPolicy
+	ensemble bow.EnsemblePolicy
+}
+
+func newBower(lib fragbag.StructureLibrary) (*bower, error) {
+	b := &bower{lib: lib}
+	switch bowerFlag {
+	case "chain", "entity", "assembly":
+		policy, err := bow.NewEntryPolicy(bowerFlag)
+		if err != nil {
+			return nil, err
+		}
+		b.entry = policy
+	case "ensemble":
+		policy, err := bow.NewEnsemblePolicy(ensembleFlag)
+		if err != nil {
+			return nil, err
+		}
+		b.ensemble = policy
+	case "domain":
+		if len(domainsLoc) == 0 {
+			return nil, fmt.Errorf("'-bower domain' requires '-domains'.")
+		}
+		f, err := os.Open(domainsLoc)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		domains, err := bow.ReadDomains(f)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read domains: %s", err)
+		}
+		b.domains = bow.DomainsByEntry(domains)
+	default:
+		return nil, fmt.Errorf("Unrecognized bower '%s'.", bowerFlag)
+	}
+	return b, nil
+}
+
+// bows reads a PDB or PDBx/mmCIF file and computes its Bowed values.
+// Panics from parsing malformed files are returned as errors.
+func (b *bower) bows(fpath string) (bs []bow.Bowed, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(fpath, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	if isCif(fpath) {
+		entries, err := pdbx.Read(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			ebs, err := b.cifBows(e)
+			if err != nil {
+				return nil, err
+			}
+			bs = append(bs, ebs...)
+		}
+		return bs, nil
+	}
+
+	e, err := pdb.Read(r, fpath)
+	if err != nil {
+		return nil, err
+	}
+	return b.pdbBows(e), nil
+}
+
+func (b *bower) pdbBows(e *pdb.Entry) []bow.Bowed {
+	switch bowerFlag {
+	case "ensemble":
+		bs := make([]bow.Bowed, 0, len(e.Chains))
+		for _, c := range e.Chains {
+			bs = append(bs,
+				bow.BowerFromEnsemble(c, b.ensemble).StructureBows(b.lib)...)
+		}
+		return bs
+	case "domain":
+		domains := b.domains[strings.ToLower(e.IdCode)]
+		return bow.BowerFromDomains(e, domains).StructureBows(b.lib)
+	}
+	return bow.BowerFromEntry(e, b.entry).StructureBows(b.lib)
+}
+
+func (b *bower) cifBows(e *pdbx.Entry) ([]bow.Bowed, error) {
+	switch bowerFlag {
+	case "ensemble":
+		bs := make([]bow.Bowed, 0)
+		for _, ent := range e.Entities {
+			for _, c := range ent.Chains {
+				bs = append(bs, bow.BowerFromCifEnsemble(c,
+					b.ensemble).StructureBows(b.lib)...)
+			}
+		}
+		return bs, nil
+	case "domain":
+		return nil, fmt.Errorf("Domains are not supported for PDBx/mmCIF " +
+			"files.")
+	}
+	return bow.BowerFromCifEntry(e, b.entry).StructureBows(b.lib), nil
+}
+
+// structureName returns the name of a structure file without its directory
+// and extensions. e.g., "pdb1ctf.ent.gz" becomes "pdb1ctf".
+func structureName(fpath string) string {
+	name := strings.TrimSuffix(path.Base(fpath), ".gz")
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+func isCif(fpath string) bool {
+	return path.Ext(strings.TrimSuffix(fpath, ".gz")) == ".cif"
+}
+
+func isStructure(fpath string) bool {
+	switch path.Ext(strings.TrimSuffix(fpath, ".gz")) {
+	case ".pdb", ".ent", ".cif":
+		return true
+	}
+	return false
+}
+
+// skipped returns true if the file should be skipped. A file is skipped if
+// its name or the PDB identifier in its name is in the skip list.
+func skipped(skip map[string]bool, fpath string) bool {
+	name := strings.ToLower(structureName(fpath))
+	if skip[name] {
+		return true
+	}
+	if strings.HasPrefix(name, "pdb") && len(name) == 7 {
+		return skip[name[3:]]
+	}
+	return false
+}
+
+func readSkipList(fpath string) (map[string]bool, error) {
+	skip := make(map[string]bool)
+	if len(fpath) == 0 {
+		return skip, nil
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); len(line) > 0 {
+			skip[strings.ToLower(line)] = true
+		}
+	}
+	return skip, scanner.Err()
+}
+
+type fileResult struct {
+	fpath string
+	bows  []bow.Bowed
+	err   error
+}
+
+func main() {
+	lib := openStructureLibrary(fragmentLibraryLoc)
+	b, err := newBower(lib)
+	if err != nil {
+		log.Fatal(err)
+	}
+	skip, err := readSkipList(skipLoc)
+	if err != nil {
+		log.Fatalf("Could not read skip list '%s': %s", skipLoc, err)
+	}
+
+	db, err := openDB(lib, flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Could not create BOW database '%s': %s", flag.Arg(0), err)
+	}
+
+	fpaths := make(chan string, numWorkers*2)
+	results := make(chan fileResult, numWorkers*2)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fpath := range fpaths {
+				bs, err := b.bows(fpath)
+				results <- fileResult{fpath, bs, err}
+			}
+		}()
+	}
+
+	filesSkipped := 0
+	go func() {
+		for _, root := range flag.Args()[1:] {
+			err := path.Walk(root, func(fpath string, info os.FileInfo, err error) error {
+				if err != nil {
+					log.Printf("%s: %s", fpath, err)
+					return nil
+				}
+				if info.IsDir() || !isStructure(fpath) {
+					return nil
+				}
+				if skipped(skip, fpath) {
+					filesSkipped++
+					return nil
+				}
+				fpaths <- fpath
+				return nil
+			})
+			if err != nil {
+				log.Printf("Could not search '%s': %s", root, err)
+			}
+		}
+		close(fpaths)
+		wg.Wait()
+		close(results)
+	}()
+
+	files, failed, added, entriesSkipped := 0, 0, 0, 0
+	for result := range results {
+		files++
+		if result.err != nil {
+			log.Printf("%s: %s", result.fpath, result.err)
+			failed++
+			continue
+		}
+		for _, bowed := range result.bows {
+			if skip[strings.ToLower(bowed.Id)] {
+				entriesSkipped++
+				continue
+			}
+			if update {
+				db.Replace(bowed)
+			} else {
+				db.Add(bowed)
+			}
+			added++
+		}
+	}
+	if err := db.Close(); err != nil {
+		log.Fatalf("Could not close BOW database '%s': %s", flag.Arg(0), err)
+	}
+
+	log.Printf("Read %d files (%d failed, %d skipped).",
+		files, failed, filesSkipped)
+	log.Printf("Wrote %d entries (%d skipped) to '%s'.",
+		added, entriesSkipped, flag.Arg(0))
+}
+
+// openDB creates a new BOW database, or opens an existing one for appending
+// when -update is set.
+func openDB(lib fragbag.Library, fpath string) (*bowdb.DB, error) {
+	if update {
+		if _, err := os.Stat(fpath); err == nil {
+			return bowdb.OpenAppend(lib, fpath)
+		}
+	}
+	return bowdb.Create(lib, fpath)
+}
+
+func openStructureLibrary(fpath string) fragbag.StructureLibrary {
+	flib, err := os.Open(fpath)
+	if err != nil {
+		log.Fatalf("Could not open fragment library '%s': %s", fpath, err)
+	}
+	defer flib.Close()
+
+	lib, err := fragbag.Open(flib)
+	if err != nil {
+		log.Fatalf("Could not read fragment library '%s': %s", fpath, err)
+	}
+	if !fragbag.IsStructure(lib) {
+		log.Fatalf("Fragment library '%s' is not a structure library.", fpath)
+	}
+	return lib.(fragbag.StructureLibrary)
+}
@@ -25,10 +25,19 @@ func init() {
 }
 
 func main() {
-	db, _ := bowdb.Open(fragmentLibraryLoc)
-	db.ReadAll()
-	for _, item := range db.Entries {
+	db, err := bowdb.Open(fragmentLibraryLoc)
+	if err != nil {
+		log.Fatalf("Could not open BOW database '%s': %s", fragmentLibraryLoc, err)
+	}
+	it, err := db.Iter()
+	if err != nil {
+		log.Fatalf("Could not read BOW database '%s': %s", fragmentLibraryLoc, err)
+	}
+	for it.Next() {
+		item := it.Entry()
 		fmt.Println(item.Id + ": " + fmt.Sprintf("%v", item.Bow.Freqs))
 	}
-
+	if err := it.Err(); err != nil {
+		log.Fatalf("Could not read BOW database '%s': %s", fragmentLibraryLoc, err)
+	}
 }
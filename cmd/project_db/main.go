@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+
+	"github.com/yunwilliamyu/esfragbag/bow"
+	"github.com/yunwilliamyu/esfragbag/bow/projection"
+	"github.com/yunwilliamyu/esfragbag/bowdb"
+)
+
+var (
+	flagMethod  = string(projection.MethodPCA)
+	flagDims    = 32
+	flagSample  = 20000
+	flagIters   = projection.PCADefault.Iterations
+	flagEuclid  = false
+	flagSeed    = projection.PCADefault.Seed
+	flagOutPath = ""
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.StringVar(&flagMethod, "method", flagMethod, "the projection to build: 'pca' or 'gaussian'")
+	flag.IntVar(&flagDims, "dims", flagDims, "the number of dimensions to project BOWs onto")
+	flag.IntVar(&flagSample, "sample", flagSample, "the number of randomly sampled entries used to fit a PCA projection (a value <= 0 uses every entry)")
+	flag.IntVar(&flagIters, "iters", flagIters, "the number of power iterations used to fit a PCA projection")
+	flag.BoolVar(&flagEuclid, "euclid", flagEuclid, "when set, the projection approximates Euclidean distance instead of cosine distance")
+	flag.Int64Var(&flagSeed, "seed", flagSeed, "the random seed")
+	flag.StringVar(&flagOutPath, "out", flagOutPath, "where to write the projection (defaults to alongside the BOW database)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] bow-db-file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	dbPath := flag.Arg(0)
+	db, err := bowdb.Open(dbPath)
+	if err != nil {
+		log.Fatalf("Could not open BOW database '%s': %s", dbPath, err)
+	}
+	defer db.Close()
+
+	var p *projection.Projection
+	switch projection.Method(flagMethod) {
+	case projection.MethodPCA:
+		bows, err := sample(db)
+		if err != nil {
+			log.Fatalf("Could not read BOW database '%s': %s", dbPath, err)
+		}
+		if len(bows) == 0 {
+			log.Fatalf("Cannot fit a PCA projection: BOW database '%s' "+
+				"has no entries.", dbPath)
+		}
+		opts := projection.PCADefault
+		opts.Iterations = flagIters
+		opts.Normalize = !flagEuclid
+		opts.Seed = flagSeed
+		p = projection.FitPCA(bows, flagDims, opts)
+	case projection.MethodGaussian:
+		p = projection.NewGaussian(db.Lib.Size(), flagDims, !flagEuclid, flagSeed)
+	default:
+		log.Fatalf("Unknown projection method '%s'.", flagMethod)
+	}
+
+	outPath := flagOutPath
+	if len(outPath) == 0 {
+		outPath = projection.PathFor(dbPath)
+	}
+	if err := p.Save(outPath); err != nil {
+		log.Fatalf("Could not write projection '%s': %s", outPath, err)
+	}
+}
+
+// sample returns the BOWs of a random sample of entries in the database.
+// Quantized entries are only expanded once they have been sampled.
+func sample(db *bowdb.DB) ([]bow.Bow, error) {
+	var n int
+	var bowAt func(i int) bow.Bow
+	if db.Encoding == bow.EncodingFloat32 {
+		entries, err := db.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		n, bowAt = len(entries), func(i int) bow.Bow { return entries[i].Bow }
+	} else {
+		entries, err := db.ReadAllQuantized()
+		if err != nil {
+			return nil, err
+		}
+		n = len(entries)
+		bowAt = func(i int) bow.Bow { return entries[i].Bow.Bow() }
+	}
+
+	var perm []int
+	if flagSample <= 0 || flagSample >= n {
+		perm = make([]int, n)
+		for i := range perm {
+			perm[i] = i
+		}
+	} else {
+		rng := rand.New(rand.NewSource(flagSeed))
+		perm = rng.Perm(n)[:flagSample]
+	}
+	sampled := make([]bow.Bow, len(perm))
+	for i, j := range perm {
+		sampled[i] = bowAt(j)
+	}
+	return sampled, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/yunwilliamyu/esfragbag/bowdb"
+)
+
+func init() {
+	log.SetFlags(0)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s bow-db-file [bow-db-file ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nChecks that each BOW database is complete and matches the checksums recorded when it was written.\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	failed := false
+	for _, fpath := range flag.Args() {
+		if err := verify(fpath); err != nil {
+			log.Printf("%s: %s", fpath, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: OK\n", fpath)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func verify(fpath string) error {
+	db, err := bowdb.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Verify()
+}
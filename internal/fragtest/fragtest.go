@@ -0,0 +1,31 @@
+// Package fragtest provides the fixtures shared by the tests of the packages
+// in this repository.
+package fragtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yunwilliamyu/esfragbag"
+)
+
+// Library returns the structure library with 400 fragments of size 11 from
+// the directory named by the FRAGLIB_PATH environment variable. The test is
+// skipped if FRAGLIB_PATH isn't set.
+func Library(t *testing.T) fragbag.Library {
+	fraglibPath := os.Getenv("FRAGLIB_PATH")
+	if len(fraglibPath) == 0 {
+		t.Skip("Environment variable FRAGLIB_PATH is not set.")
+	}
+	f, err := os.Open(filepath.Join(fraglibPath, "structure", "400-11.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lib, err := fragbag.Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}